@@ -0,0 +1,458 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/hkjojo/go-toolkits/metric"
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+var (
+	// ErrCacheMiss is returned when a key is not cached.
+	ErrCacheMiss = errors.New("redis: cache miss")
+	// ErrNotFound is returned by loaders when the value does not exist,
+	// it is cached for the negative TTL and returned by the Cache.
+	ErrNotFound = errors.New("redis: not found")
+)
+
+// cached entries start with a header telling values from negative ones
+const (
+	entryValue    = 'v'
+	entryNotFound = 'n'
+)
+
+const (
+	defaultCacheTTL         = 10 * time.Minute
+	defaultCacheLoadTimeout = 10 * time.Second
+)
+
+// Codec encodes the values of a Cache.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// JSONCodec ...
+type JSONCodec struct{}
+
+// Marshal ...
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal ...
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// MsgpackCodec ...
+type MsgpackCodec struct{}
+
+// Marshal ...
+func (MsgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+// Unmarshal ...
+func (MsgpackCodec) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
+
+// ProtoCodec encodes values implementing proto.Message, a nil message
+// pointer is allocated before unmarshalling.
+type ProtoCodec struct{}
+
+// Marshal ...
+func (ProtoCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("redis: %T is not a proto.Message", v)
+	}
+	return proto.Marshal(m)
+}
+
+// Unmarshal ...
+func (ProtoCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		// pointer to a message pointer
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Ptr {
+			return fmt.Errorf("redis: %T is not a proto.Message", v)
+		}
+		if rv.Elem().IsNil() {
+			rv.Elem().Set(reflect.New(rv.Elem().Type().Elem()))
+		}
+		if m, ok = rv.Elem().Interface().(proto.Message); !ok {
+			return fmt.Errorf("redis: %T is not a proto.Message", v)
+		}
+	}
+	return proto.Unmarshal(data, m)
+}
+
+type cacheOptions struct {
+	codec       Codec
+	ttl         time.Duration
+	jitter      float64
+	negativeTTL time.Duration
+	loadTimeout time.Duration
+	requests    metric.Counter
+}
+
+// CacheOption ...
+type CacheOption func(*cacheOptions)
+
+// WithCodec sets the codec of the values, default JSONCodec.
+func WithCodec(codec Codec) CacheOption {
+	return func(o *cacheOptions) {
+		o.codec = codec
+	}
+}
+
+// WithCacheTTL sets the time to live of the entries, default 10 minutes.
+func WithCacheTTL(ttl time.Duration) CacheOption {
+	return func(o *cacheOptions) {
+		if ttl > 0 {
+			o.ttl = ttl
+		}
+	}
+}
+
+// WithCacheJitter extends every TTL by a random part of up to
+// fraction of it, so entries set together do not expire together.
+func WithCacheJitter(fraction float64) CacheOption {
+	return func(o *cacheOptions) {
+		if fraction > 0 {
+			o.jitter = fraction
+		}
+	}
+}
+
+// WithNegativeTTL caches ErrNotFound returned by loaders for ttl,
+// negative entries are not cached by default.
+func WithNegativeTTL(ttl time.Duration) CacheOption {
+	return func(o *cacheOptions) {
+		o.negativeTTL = ttl
+	}
+}
+
+// WithLoadTimeout bounds the loads of GetOrLoad, default 10 seconds.
+func WithLoadTimeout(timeout time.Duration) CacheOption {
+	return func(o *cacheOptions) {
+		if timeout > 0 {
+			o.loadTimeout = timeout
+		}
+	}
+}
+
+// WithCacheRequests counts lookups, c has two labels receiving the name
+// of the cache and hit or miss.
+func WithCacheRequests(c metric.Counter) CacheOption {
+	return func(o *cacheOptions) {
+		o.requests = c
+	}
+}
+
+// Loader loads the value of a key missing from a Cache.
+type Loader[K comparable, V any] func(ctx context.Context, key K) (V, error)
+
+// Cache is a typed cache of values encoded in redis strings, keyed by
+// its name and fmt.Sprint of the key.
+type Cache[K comparable, V any] struct {
+	pool  *Pool
+	name  string
+	opts  cacheOptions
+	group flightGroup[V]
+}
+
+// NewCache ...
+func NewCache[K comparable, V any](pool *Pool, name string, opts ...CacheOption) *Cache[K, V] {
+	o := cacheOptions{
+		codec:       JSONCodec{},
+		ttl:         defaultCacheTTL,
+		loadTimeout: defaultCacheLoadTimeout,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &Cache[K, V]{pool: pool, name: name, opts: o}
+}
+
+func (c *Cache[K, V]) key(key K) string {
+	return c.name + ":" + fmt.Sprint(key)
+}
+
+func (c *Cache[K, V]) expiry(ttl time.Duration) int64 {
+	if c.opts.jitter > 0 {
+		ttl += time.Duration(rand.Int63n(int64(float64(ttl)*c.opts.jitter) + 1))
+	}
+	if ms := ttl.Milliseconds(); ms > 0 {
+		return ms
+	}
+	return 1
+}
+
+func (c *Cache[K, V]) count(result string) {
+	if c.opts.requests != nil {
+		c.opts.requests.With(c.name, result).Inc()
+	}
+}
+
+func (c *Cache[K, V]) encode(value V) ([]byte, error) {
+	data, err := c.opts.codec.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{entryValue}, data...), nil
+}
+
+// decode returns ErrNotFound for negative entries.
+func (c *Cache[K, V]) decode(data []byte) (V, error) {
+	var value V
+	if len(data) == 0 {
+		return value, errors.New("redis: invalid cache entry")
+	}
+	switch data[0] {
+	case entryValue:
+		err := c.opts.codec.Unmarshal(data[1:], &value)
+		return value, err
+	case entryNotFound:
+		return value, ErrNotFound
+	}
+	return value, errors.New("redis: invalid cache entry")
+}
+
+// Get returns the value of key, ErrCacheMiss if it is not cached and
+// ErrNotFound if it is cached as missing.
+func (c *Cache[K, V]) Get(ctx context.Context, key K) (V, error) {
+	var value V
+	data, err := redis.Bytes(c.pool.DoContext(ctx, "GET", c.key(key)))
+	if err == redis.ErrNil {
+		c.count("miss")
+		return value, ErrCacheMiss
+	}
+	if err != nil {
+		return value, err
+	}
+	c.count("hit")
+	return c.decode(data)
+}
+
+// Set caches value for the TTL of the cache.
+func (c *Cache[K, V]) Set(ctx context.Context, key K, value V) error {
+	return c.SetTTL(ctx, key, value, c.opts.ttl)
+}
+
+// SetTTL caches value for ttl.
+func (c *Cache[K, V]) SetTTL(ctx context.Context, key K, value V, ttl time.Duration) error {
+	data, err := c.encode(value)
+	if err != nil {
+		return err
+	}
+	_, err = c.pool.DoContext(ctx, "SET", c.key(key), data, "PX", c.expiry(ttl))
+	return err
+}
+
+// Delete ...
+func (c *Cache[K, V]) Delete(ctx context.Context, keys ...K) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	args := make([]interface{}, len(keys))
+	for i, key := range keys {
+		args[i] = c.key(key)
+	}
+	return c.pool.DelsContext(ctx, args...)
+}
+
+// GetOrLoad returns the cached value of key or loads and caches it,
+// concurrent loads of a key in this process are made once. The load
+// keeps the values of the ctx of the first caller but not its deadline
+// or cancellation, it is bounded by the load timeout instead, and every
+// caller stops waiting for it once its own ctx is done. ErrNotFound from
+// loader is cached when WithNegativeTTL is set.
+func (c *Cache[K, V]) GetOrLoad(ctx context.Context, key K, loader Loader[K, V]) (V, error) {
+	value, err := c.Get(ctx, key)
+	if err != ErrCacheMiss {
+		return value, err
+	}
+
+	loadCtx := context.WithoutCancel(ctx)
+	return c.group.do(ctx, c.key(key), func() (V, error) {
+		ctx, cancel := context.WithTimeout(loadCtx, c.opts.loadTimeout)
+		defer cancel()
+
+		value, err := loader(ctx, key)
+		switch {
+		case err == nil:
+			if err := c.Set(ctx, key, value); err != nil {
+				return value, err
+			}
+		case errors.Is(err, ErrNotFound) && c.opts.negativeTTL > 0:
+			c.pool.DoContext(ctx, "SET", c.key(key), []byte{entryNotFound}, "PX", c.expiry(c.opts.negativeTTL))
+		}
+		return value, err
+	})
+}
+
+// MGet returns the cached values of keys, the missing ones and the ones
+// cached as missing are left out.
+func (c *Cache[K, V]) MGet(ctx context.Context, keys ...K) (map[K]V, error) {
+	values := make(map[K]V, len(keys))
+	if len(keys) == 0 {
+		return values, nil
+	}
+
+	var replies []interface{}
+	if c.pool.cluster != nil {
+		// the keys may be in different slots
+		cmds := make([]clusterCommand, len(keys))
+		for i, key := range keys {
+			cmds[i] = clusterCommand{key: c.key(key), name: "GET", args: []interface{}{c.key(key)}}
+		}
+		var err error
+		if replies, err = c.pool.cluster.pipeline(ctx, cmds); err != nil {
+			return nil, err
+		}
+	} else {
+		args := make([]interface{}, len(keys))
+		for i, key := range keys {
+			args[i] = c.key(key)
+		}
+		var err error
+		if replies, err = redis.Values(c.pool.DoContext(ctx, "MGET", args...)); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, reply := range replies {
+		data, err := redis.Bytes(reply, nil)
+		if err == redis.ErrNil {
+			c.count("miss")
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		c.count("hit")
+
+		value, err := c.decode(data)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		values[keys[i]] = value
+	}
+	return values, nil
+}
+
+// MSet caches values for the TTL of the cache in a pipeline.
+func (c *Cache[K, V]) MSet(ctx context.Context, values map[K]V) error {
+	if len(values) == 0 {
+		return nil
+	}
+
+	cmds := make([]clusterCommand, 0, len(values))
+	for key, value := range values {
+		data, err := c.encode(value)
+		if err != nil {
+			return err
+		}
+		k := c.key(key)
+		cmds = append(cmds, clusterCommand{key: k, name: "SET", args: []interface{}{k, data, "PX", c.expiry(c.opts.ttl)}})
+	}
+	if c.pool.cluster != nil {
+		_, err := c.pool.cluster.pipeline(ctx, cmds)
+		return err
+	}
+
+	conn, err := c.pool.ConnContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for _, cmd := range cmds {
+		conn.Send(cmd.name, cmd.args...)
+	}
+	if err := conn.Flush(); err != nil {
+		return err
+	}
+	var firstErr error
+	for range cmds {
+		if _, err := receive(ctx, conn); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// errFlightPanic is returned to the callers sharing a call which panicked.
+var errFlightPanic = errors.New("redis: loader panicked")
+
+// flightGroup runs a single call per key at a time, the callers of a
+// key in flight share its result.
+type flightGroup[V any] struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall[V]
+}
+
+type flightCall[V any] struct {
+	done  chan struct{}
+	value V
+	err   error
+	panic interface{} // raised again in the caller which started the call
+}
+
+// do runs f in a goroutine of its own unless a call of key is in flight,
+// then waits for its result until ctx is done.
+func (g *flightGroup[V]) do(ctx context.Context, key string, f func() (V, error)) (V, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall[V])
+	}
+	call, shared := g.calls[key]
+	if !shared {
+		call = &flightCall[V]{done: make(chan struct{})}
+		g.calls[key] = call
+		go g.run(key, call, f)
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		var value V
+		return value, ctx.Err()
+	}
+	if call.panic != nil && !shared {
+		panic(call.panic)
+	}
+	return call.value, call.err
+}
+
+func (g *flightGroup[V]) run(key string, call *flightCall[V], f func() (V, error)) {
+	defer func() {
+		// a panic of f fails the waiting callers
+		if r := recover(); r != nil {
+			call.panic, call.err = r, errFlightPanic
+		}
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(call.done)
+	}()
+
+	call.value, call.err = f()
+}
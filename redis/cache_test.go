@@ -0,0 +1,255 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hkjojo/go-toolkits/metric"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// testCounter panics like a prometheus counter when With is not given a
+// value per label.
+type testCounter struct {
+	mu     *sync.Mutex
+	labels int
+	counts map[string]float64
+	lvs    []string
+}
+
+func newTestCounter(labels int) *testCounter {
+	return &testCounter{mu: new(sync.Mutex), labels: labels, counts: make(map[string]float64)}
+}
+
+func (c *testCounter) With(lvs ...string) metric.Counter {
+	if len(lvs) != c.labels {
+		panic(fmt.Sprintf("inconsistent label cardinality: expected %d label values but got %d in %q", c.labels, len(lvs), lvs))
+	}
+	return &testCounter{mu: c.mu, labels: c.labels, counts: c.counts, lvs: lvs}
+}
+
+func (c *testCounter) Inc() {
+	c.Add(1)
+}
+
+func (c *testCounter) Add(delta float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[strings.Join(c.lvs, ",")] += delta
+}
+
+type user struct {
+	Name string `json:"name" msgpack:"name"`
+	Age  int    `json:"age" msgpack:"age"`
+}
+
+func TestCacheGetOrLoad(t *testing.T) {
+	m := newTestRedis(t)
+	p := newTestPool(m.Addr())
+	defer p.Close()
+
+	counter := newTestCounter(2)
+	cache := NewCache[int, user](p, "users", WithCacheRequests(counter))
+	ctx := context.Background()
+
+	if _, err := cache.Get(ctx, 1); err != ErrCacheMiss {
+		t.Fatalf("err = %v, want %v", err, ErrCacheMiss)
+	}
+
+	var (
+		loads int32
+		wg    sync.WaitGroup
+	)
+	loader := func(ctx context.Context, id int) (user, error) {
+		atomic.AddInt32(&loads, 1)
+		time.Sleep(50 * time.Millisecond)
+		return user{Name: "bob", Age: id}, nil
+	}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			u, err := cache.GetOrLoad(ctx, 7, loader)
+			if err != nil || u.Age != 7 {
+				t.Errorf("GetOrLoad = %+v, %v", u, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&loads); n != 1 {
+		t.Fatalf("loaded %d times, want 1", n)
+	}
+
+	if u, err := cache.Get(ctx, 7); err != nil || u.Name != "bob" {
+		t.Fatalf("Get = %+v, %v", u, err)
+	}
+	if counter.counts["users,hit"] != 1 {
+		t.Fatalf("counts = %v, want a hit", counter.counts)
+	}
+
+	if err := cache.Delete(ctx, 7); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.Get(ctx, 7); err != ErrCacheMiss {
+		t.Fatalf("err = %v, want %v", err, ErrCacheMiss)
+	}
+}
+
+func TestCacheNegative(t *testing.T) {
+	m := newTestRedis(t)
+	p := newTestPool(m.Addr())
+	defer p.Close()
+
+	cache := NewCache[string, user](p, "users", WithNegativeTTL(time.Minute))
+	ctx := context.Background()
+
+	var loads int
+	loader := func(ctx context.Context, name string) (user, error) {
+		loads++
+		return user{}, ErrNotFound
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := cache.GetOrLoad(ctx, "alice", loader); err != ErrNotFound {
+			t.Fatalf("err = %v, want %v", err, ErrNotFound)
+		}
+	}
+	if loads != 1 {
+		t.Fatalf("loaded %d times, want 1", loads)
+	}
+}
+
+func TestCacheMulti(t *testing.T) {
+	m := newTestRedis(t)
+	p := newTestPool(m.Addr())
+	defer p.Close()
+
+	cache := NewCache[string, user](p, "users", WithCodec(MsgpackCodec{}))
+	ctx := context.Background()
+
+	if err := cache.MSet(ctx, map[string]user{"a": {Name: "a", Age: 1}, "b": {Name: "b", Age: 2}}); err != nil {
+		t.Fatal(err)
+	}
+	values, err := cache.MGet(ctx, "a", "b", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(values) != 2 || values["a"].Age != 1 || values["b"].Name != "b" {
+		t.Fatalf("MGet = %+v", values)
+	}
+}
+
+func TestCacheProto(t *testing.T) {
+	m := newTestRedis(t)
+	p := newTestPool(m.Addr())
+	defer p.Close()
+
+	cache := NewCache[string, *wrapperspb.StringValue](p, "names", WithCodec(ProtoCodec{}))
+	ctx := context.Background()
+
+	if err := cache.Set(ctx, "a", wrapperspb.String("alice")); err != nil {
+		t.Fatal(err)
+	}
+	v, err := cache.Get(ctx, "a")
+	if err != nil || v.GetValue() != "alice" {
+		t.Fatalf("Get = %v, %v", v, err)
+	}
+}
+
+func TestCacheJitter(t *testing.T) {
+	cache := NewCache[string, string](nil, "jitter", WithCacheJitter(0.5))
+	for i := 0; i < 100; i++ {
+		ms := cache.expiry(time.Second)
+		if ms < 1000 || ms > 1500 {
+			t.Fatalf("expiry = %dms, want within [1000, 1500]", ms)
+		}
+	}
+}
+
+func TestCacheLoaderPanic(t *testing.T) {
+	m := newTestRedis(t)
+	p := newTestPool(m.Addr())
+	defer p.Close()
+
+	cache := NewCache[int, user](p, "users")
+	ctx := context.Background()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go func() {
+		defer func() { recover() }()
+		cache.GetOrLoad(ctx, 1, func(ctx context.Context, id int) (user, error) {
+			close(started)
+			<-release
+			panic("boom")
+		})
+	}()
+	<-started
+
+	done := make(chan error)
+	go func() {
+		_, err := cache.GetOrLoad(ctx, 1, func(ctx context.Context, id int) (user, error) {
+			return user{Name: "late"}, nil
+		})
+		done <- err
+	}()
+	// let the second caller join the call in flight
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	if err := <-done; err != errFlightPanic {
+		t.Fatalf("err = %v, want %v", err, errFlightPanic)
+	}
+}
+
+func TestCacheLoadContext(t *testing.T) {
+	m := newTestRedis(t)
+	p := newTestPool(m.Addr())
+	defer p.Close()
+
+	cache := NewCache[int, user](p, "users", WithLoadTimeout(time.Second))
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	first, cancel := context.WithCancel(context.Background())
+	firstErr := make(chan error)
+	go func() {
+		_, err := cache.GetOrLoad(first, 1, func(ctx context.Context, id int) (user, error) {
+			close(started)
+			<-release
+			return user{Name: "bob", Age: id}, ctx.Err()
+		})
+		firstErr <- err
+	}()
+	<-started
+
+	// a waiter leaves once its own ctx is done
+	short, cancelShort := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelShort()
+	if _, err := cache.GetOrLoad(short, 1, nil); err != context.DeadlineExceeded {
+		t.Fatalf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// the first caller leaving does not cancel the load of the others
+	waiterErr := make(chan error)
+	go func() {
+		u, err := cache.GetOrLoad(context.Background(), 1, nil)
+		if err == nil && u.Name != "bob" {
+			err = fmt.Errorf("got %+v", u)
+		}
+		waiterErr <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	if err := <-firstErr; err != context.Canceled {
+		t.Fatalf("first err = %v, want %v", err, context.Canceled)
+	}
+	close(release)
+	if err := <-waiterErr; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,732 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	// SlotCount is the number of hash slots of a Redis Cluster.
+	SlotCount = 16384
+
+	maxRedirects = 5
+)
+
+var (
+	errClusterNoNode = errors.New("redis cluster: no node available")
+	errConnClosed    = errors.New("redis cluster: conn closed")
+)
+
+// keylessCommands are routed to any node of the cluster.
+var keylessCommands = map[string]bool{
+	"":             true,
+	"ASKING":       true,
+	"AUTH":         true,
+	"CLUSTER":      true,
+	"DBSIZE":       true,
+	"DISCARD":      true,
+	"ECHO":         true,
+	"EXEC":         true,
+	"FLUSHALL":     true,
+	"FLUSHDB":      true,
+	"INFO":         true,
+	"MULTI":        true,
+	"PING":         true,
+	"PSUBSCRIBE":   true,
+	"PUBLISH":      true,
+	"PUNSUBSCRIBE": true,
+	"RANDOMKEY":    true,
+	"READONLY":     true,
+	"SCAN":         true,
+	"SCRIPT":       true,
+	"SUBSCRIBE":    true,
+	"TIME":         true,
+	"UNSUBSCRIBE":  true,
+	"UNWATCH":      true,
+}
+
+// Slot returns the hash slot of key, only the part between the first
+// { and the next } is hashed when it is not empty.
+func Slot(key string) int {
+	if i := strings.IndexByte(key, '{'); i >= 0 {
+		if j := strings.IndexByte(key[i+1:], '}'); j > 0 {
+			key = key[i+1 : i+1+j]
+		}
+	}
+	return int(crc16(key) % SlotCount)
+}
+
+// crc16 is the CRC16-XMODEM checksum used by Redis Cluster.
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// commandKey returns the key a command is routed by.
+func commandKey(command string, args []interface{}) (string, bool) {
+	command = strings.ToUpper(command)
+	if keylessCommands[command] {
+		return "", false
+	}
+
+	switch command {
+	case "EVAL", "EVALSHA":
+		// script, numkeys, key...
+		if len(args) < 3 {
+			return "", false
+		}
+		n, err := strconv.Atoi(argString(args[1]))
+		if err != nil || n <= 0 {
+			return "", false
+		}
+		return argString(args[2]), true
+	case "XGROUP", "XINFO":
+		// subcommand, key...
+		if len(args) < 2 {
+			return "", false
+		}
+		return argString(args[1]), true
+	case "XREAD", "XREADGROUP":
+		for i, arg := range args {
+			if strings.EqualFold(argString(arg), "STREAMS") && i+1 < len(args) {
+				return argString(args[i+1]), true
+			}
+		}
+		return "", false
+	}
+
+	if len(args) == 0 {
+		return "", false
+	}
+	return argString(args[0]), true
+}
+
+func argString(arg interface{}) string {
+	switch arg := arg.(type) {
+	case string:
+		return arg
+	case []byte:
+		return string(arg)
+	default:
+		return fmt.Sprint(arg)
+	}
+}
+
+// redirect parses a MOVED or ASK error reply.
+func redirect(err error) (slot int, addr string, ask bool, ok bool) {
+	rerr, isErr := err.(redis.Error)
+	if !isErr {
+		return 0, "", false, false
+	}
+
+	f := strings.Fields(string(rerr))
+	if len(f) != 3 || (f[0] != "MOVED" && f[0] != "ASK") {
+		return 0, "", false, false
+	}
+	slot, e := strconv.Atoi(f[1])
+	if e != nil {
+		return 0, "", false, false
+	}
+	return slot, f[2], f[0] == "ASK", true
+}
+
+// cluster keeps the slot map of a Redis Cluster and a pool per node.
+type cluster struct {
+	conf       *Config
+	startup    []string
+	dial       func(addr string) (redis.Conn, error)
+	refreshing int32
+
+	mu    sync.RWMutex // protects slots, nodes, pools
+	slots []string
+	nodes []string
+	pools map[string]*redis.Pool
+}
+
+// NewCluster returns a pool over the Redis Cluster reachable through
+// conf.Cluster, the slot map is discovered on first use and refreshed
+// on MOVED redirects.
+func NewCluster(conf *Config) *Pool {
+	var (
+		useTls   bool
+		password string
+		username string
+		startup  []string
+	)
+
+	for _, v := range conf.Cluster {
+		if !strings.HasPrefix(v, "redis://") && !strings.HasPrefix(v, "rediss://") {
+			v = "redis://" + v
+		}
+		if strings.HasPrefix(v, "rediss://") {
+			useTls = true
+		}
+		u, err := url.Parse(v)
+		if err != nil {
+			continue
+		}
+		if u.User != nil {
+			username = u.User.Username()
+			password, _ = u.User.Password()
+		}
+		startup = append(startup, u.Host)
+	}
+
+	var options = []redis.DialOption{
+		redis.DialConnectTimeout(time.Duration(conf.ConnectTimeout) * time.Second),
+		redis.DialReadTimeout(time.Duration(conf.ReadTimeout) * time.Second),
+		redis.DialWriteTimeout(time.Duration(conf.WriteTimeout) * time.Second),
+	}
+	if password != "" {
+		options = append(options, redis.DialPassword(password))
+	}
+	if username != "" {
+		options = append(options, redis.DialUsername(username))
+	}
+	if useTls {
+		options = append(options, redis.DialUseTLS(true))
+		options = append(options, redis.DialTLSSkipVerify(conf.TLSSkipVerify))
+	}
+
+	return &Pool{
+		cluster: newCluster(conf, startup, func(addr string) (redis.Conn, error) {
+			conn, err := redis.Dial("tcp", addr, options...)
+			if err != nil {
+				return nil, err
+			}
+			if conf.Debug {
+				return redis.NewLoggingConn(conn, log.Default(), addr), nil
+			}
+			return conn, nil
+		}),
+		scripts: make(map[string]*poolScript),
+	}
+}
+
+func newCluster(conf *Config, startup []string, dial func(string) (redis.Conn, error)) *cluster {
+	return &cluster{
+		conf:    conf,
+		startup: startup,
+		dial:    dial,
+		pools:   make(map[string]*redis.Pool),
+	}
+}
+
+// pool returns the pool of the node at addr.
+func (c *cluster) pool(addr string) *redis.Pool {
+	c.mu.RLock()
+	p := c.pools[addr]
+	c.mu.RUnlock()
+	if p != nil {
+		return p
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if p = c.pools[addr]; p == nil {
+		p = &redis.Pool{
+			MaxIdle:     c.conf.MaxIdle,
+			MaxActive:   c.conf.MaxActive,
+			IdleTimeout: time.Duration(c.conf.IdleTimeout) * time.Second,
+			Wait:        true,
+			Dial: func() (redis.Conn, error) {
+				return c.dial(addr)
+			},
+		}
+		c.pools[addr] = p
+	}
+	return p
+}
+
+// get returns a conn to the node at addr.
+func (c *cluster) get(ctx context.Context, addr string) (redis.Conn, error) {
+	return c.pool(addr).GetContext(ctx)
+}
+
+// ensureSlots discovers the slot map unless it is known.
+func (c *cluster) ensureSlots() error {
+	c.mu.RLock()
+	known := c.slots != nil
+	c.mu.RUnlock()
+	if known {
+		return nil
+	}
+	return c.refresh()
+}
+
+// refresh reloads the slot map with CLUSTER SLOTS from the first node
+// answering it.
+func (c *cluster) refresh() error {
+	c.mu.RLock()
+	addrs := append(append([]string{}, c.nodes...), c.startup...)
+	c.mu.RUnlock()
+
+	if len(addrs) == 0 {
+		return errClusterNoNode
+	}
+
+	var err error
+	for _, addr := range addrs {
+		var slots []string
+		if slots, err = c.clusterSlots(addr); err != nil {
+			continue
+		}
+
+		seen := make(map[string]bool)
+		var nodes []string
+		for _, node := range slots {
+			if node != "" && !seen[node] {
+				seen[node] = true
+				nodes = append(nodes, node)
+			}
+		}
+
+		c.mu.Lock()
+		c.slots, c.nodes = slots, nodes
+		c.mu.Unlock()
+		return nil
+	}
+	return err
+}
+
+func (c *cluster) clusterSlots(addr string) ([]string, error) {
+	conn, err := c.get(context.Background(), addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	ranges, err := redis.Values(conn.Do("CLUSTER", "SLOTS"))
+	if err != nil {
+		return nil, err
+	}
+
+	host, _, _ := net.SplitHostPort(addr)
+	slots := make([]string, SlotCount)
+	for _, r := range ranges {
+		// start, end, master [ip, port, id], replicas...
+		v, err := redis.Values(r, nil)
+		if err != nil || len(v) < 3 {
+			return nil, fmt.Errorf("redis cluster: unexpected CLUSTER SLOTS reply from %s", addr)
+		}
+		start, _ := redis.Int(v[0], nil)
+		end, _ := redis.Int(v[1], nil)
+		master, err := redis.Values(v[2], nil)
+		if err != nil || len(master) < 2 {
+			return nil, fmt.Errorf("redis cluster: unexpected CLUSTER SLOTS reply from %s", addr)
+		}
+		ip, _ := redis.String(master[0], nil)
+		port, _ := redis.Int(master[1], nil)
+		if ip == "" {
+			// the node replied with its own address unknown
+			ip = host
+		}
+
+		node := net.JoinHostPort(ip, strconv.Itoa(port))
+		for slot := start; slot <= end && slot < SlotCount; slot++ {
+			slots[slot] = node
+		}
+	}
+	return slots, nil
+}
+
+// moved records the new owner of slot and reloads the slot map in the
+// background.
+func (c *cluster) moved(slot int, addr string) {
+	c.mu.Lock()
+	if slot >= 0 && slot < len(c.slots) {
+		c.slots[slot] = addr
+	}
+	c.mu.Unlock()
+
+	if atomic.CompareAndSwapInt32(&c.refreshing, 0, 1) {
+		go func() {
+			defer atomic.StoreInt32(&c.refreshing, 0)
+			c.refresh()
+		}()
+	}
+}
+
+// addr returns the address of the node serving key, or of any node
+// when hasKey is false.
+func (c *cluster) addr(key string, hasKey bool) (string, error) {
+	if err := c.ensureSlots(); err != nil {
+		return "", err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if hasKey {
+		if addr := c.slots[Slot(key)]; addr != "" {
+			return addr, nil
+		}
+	}
+	if len(c.nodes) == 0 {
+		return "", errClusterNoNode
+	}
+	return c.nodes[rand.Intn(len(c.nodes))], nil
+}
+
+// masters returns the addresses of the nodes serving slots.
+func (c *cluster) masters() ([]string, error) {
+	if err := c.ensureSlots(); err != nil {
+		return nil, err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return append([]string{}, c.nodes...), nil
+}
+
+// each runs f on a conn to every master.
+func (c *cluster) each(ctx context.Context, f func(redis.Conn) error) error {
+	masters, err := c.masters()
+	if err != nil {
+		return err
+	}
+
+	for _, addr := range masters {
+		conn, err := c.get(ctx, addr)
+		if err != nil {
+			return err
+		}
+		err = f(conn)
+		conn.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clusterCommand is a command of a pipeline spanning several nodes.
+type clusterCommand struct {
+	key    string
+	name   string
+	args   []interface{}
+	script *redis.Script // sent with EVALSHA when set
+}
+
+// pipelineTarget is a node commands of a pipeline are sent to, preceded
+// by ASKING after an ASK redirect.
+type pipelineTarget struct {
+	addr string
+	ask  bool
+}
+
+// pipeline sends the commands grouped by node and returns their replies
+// in order, error replies included, the error is the first one replied.
+// Commands redirected with MOVED or ASK are sent again to their new node.
+func (c *cluster) pipeline(ctx context.Context, cmds []clusterCommand) ([]interface{}, error) {
+	groups := make(map[pipelineTarget][]int)
+	for i, cmd := range cmds {
+		addr, err := c.addr(cmd.key, true)
+		if err != nil {
+			return nil, err
+		}
+		target := pipelineTarget{addr: addr}
+		groups[target] = append(groups[target], i)
+	}
+
+	var (
+		replies = make([]interface{}, len(cmds))
+		errs    = make([]error, len(cmds))
+	)
+	for redirects := 0; len(groups) != 0; redirects++ {
+		next := make(map[pipelineTarget][]int)
+		for target, indexes := range groups {
+			if err := c.pipelineNode(ctx, target, cmds, indexes, replies, errs); err != nil {
+				return nil, err
+			}
+			if redirects == maxRedirects {
+				continue
+			}
+			for _, i := range indexes {
+				slot, addr, ask, ok := redirect(errs[i])
+				if !ok {
+					continue
+				}
+				if !ask {
+					c.moved(slot, addr)
+				}
+				target := pipelineTarget{addr: addr, ask: ask}
+				next[target] = append(next[target], i)
+			}
+		}
+		groups = next
+	}
+
+	for _, err := range errs {
+		if err != nil {
+			return replies, err
+		}
+	}
+	return replies, nil
+}
+
+// pipelineNode sends the commands of indexes to target and stores their
+// replies.
+func (c *cluster) pipelineNode(ctx context.Context, target pipelineTarget, cmds []clusterCommand, indexes []int, replies []interface{}, errs []error) error {
+	conn, err := c.get(ctx, target.addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	for _, i := range indexes {
+		if target.ask {
+			conn.Send("ASKING")
+		}
+		if cmds[i].script != nil {
+			cmds[i].script.SendHash(conn, cmds[i].args...)
+		} else {
+			conn.Send(cmds[i].name, cmds[i].args...)
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return err
+	}
+	for _, i := range indexes {
+		if target.ask {
+			receive(ctx, conn)
+		}
+		replies[i], errs[i] = receive(ctx, conn)
+		if e, ok := errs[i].(redis.Error); ok {
+			replies[i] = e
+		}
+	}
+	return nil
+}
+
+func (c *cluster) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for addr, p := range c.pools {
+		p.Close()
+		delete(c.pools, addr)
+	}
+}
+
+type sentCommand struct {
+	name string
+	args []interface{}
+}
+
+// clusterConn is a redis.Conn bound to the node serving the key of its
+// first command. Commands without a key sent before it are queued until
+// the conn is bound. Do follows MOVED and ASK redirects unless replies
+// of pipelined commands are pending or noRedirect is set.
+type clusterConn struct {
+	cluster *cluster
+	ctx     context.Context
+	conn    redis.Conn
+	queued  []sentCommand
+	pending int
+	err     error
+	// noRedirect is set by Pool.Tx, following a redirect would lose the
+	// WATCH of the conn
+	noRedirect bool
+}
+
+func newClusterConn(ctx context.Context, c *cluster) *clusterConn {
+	return &clusterConn{cluster: c, ctx: ctx}
+}
+
+func (c *clusterConn) bind(key string, hasKey bool) error {
+	if c.conn != nil {
+		return nil
+	}
+
+	addr, err := c.cluster.addr(key, hasKey)
+	if err != nil {
+		return err
+	}
+	conn, err := c.cluster.get(c.ctx, addr)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+
+	queued := c.queued
+	c.queued = nil
+	for _, cmd := range queued {
+		if err := conn.Send(cmd.name, cmd.args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *clusterConn) Close() error {
+	if c.err == errConnClosed {
+		return nil
+	}
+	c.err = errConnClosed
+	if c.conn != nil {
+		return c.conn.Close()
+	}
+	return nil
+}
+
+func (c *clusterConn) Err() error {
+	if c.err != nil {
+		return c.err
+	}
+	if c.conn != nil {
+		return c.conn.Err()
+	}
+	return c.cluster.ensureSlots()
+}
+
+func (c *clusterConn) Do(command string, args ...interface{}) (interface{}, error) {
+	return c.do(command, args, func(conn redis.Conn) (interface{}, error) {
+		return conn.Do(command, args...)
+	})
+}
+
+func (c *clusterConn) DoContext(ctx context.Context, command string, args ...interface{}) (interface{}, error) {
+	return c.do(command, args, func(conn redis.Conn) (interface{}, error) {
+		return redis.DoContext(conn, ctx, command, args...)
+	})
+}
+
+func (c *clusterConn) DoWithTimeout(timeout time.Duration, command string, args ...interface{}) (interface{}, error) {
+	return c.do(command, args, func(conn redis.Conn) (interface{}, error) {
+		return redis.DoWithTimeout(conn, timeout, command, args...)
+	})
+}
+
+func (c *clusterConn) do(command string, args []interface{}, run func(redis.Conn) (interface{}, error)) (interface{}, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	if command == "" && c.conn == nil && len(c.queued) == 0 {
+		return nil, nil
+	}
+
+	pipelined := c.pending > 0 || len(c.queued) > 0
+	c.pending = 0
+	if err := c.bind(commandKey(command, args)); err != nil {
+		return nil, err
+	}
+
+	reply, err := run(c.conn)
+	if pipelined || c.noRedirect {
+		return reply, err
+	}
+
+	for i := 0; i < maxRedirects; i++ {
+		slot, addr, ask, ok := redirect(err)
+		if !ok {
+			break
+		}
+
+		conn, e := c.cluster.get(c.ctx, addr)
+		if e != nil {
+			return nil, e
+		}
+		if ask {
+			conn.Send("ASKING")
+			reply, err = run(conn)
+			conn.Close()
+			continue
+		}
+
+		c.cluster.moved(slot, addr)
+		c.conn.Close()
+		c.conn = conn
+		reply, err = run(conn)
+	}
+	return reply, err
+}
+
+func (c *clusterConn) Send(command string, args ...interface{}) error {
+	if c.err != nil {
+		return c.err
+	}
+	if c.conn == nil {
+		key, hasKey := commandKey(command, args)
+		if !hasKey {
+			c.queued = append(c.queued, sentCommand{name: command, args: args})
+			c.pending++
+			return nil
+		}
+		if err := c.bind(key, true); err != nil {
+			return err
+		}
+	}
+	c.pending++
+	return c.conn.Send(command, args...)
+}
+
+func (c *clusterConn) Flush() error {
+	if c.err != nil {
+		return c.err
+	}
+	if c.conn == nil {
+		if len(c.queued) == 0 {
+			return nil
+		}
+		if err := c.bind("", false); err != nil {
+			return err
+		}
+	}
+	return c.conn.Flush()
+}
+
+func (c *clusterConn) Receive() (interface{}, error) {
+	return c.receive(func(conn redis.Conn) (interface{}, error) {
+		return conn.Receive()
+	})
+}
+
+func (c *clusterConn) ReceiveContext(ctx context.Context) (interface{}, error) {
+	return c.receive(func(conn redis.Conn) (interface{}, error) {
+		return redis.ReceiveContext(conn, ctx)
+	})
+}
+
+func (c *clusterConn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	return c.receive(func(conn redis.Conn) (interface{}, error) {
+		return redis.ReceiveWithTimeout(conn, timeout)
+	})
+}
+
+func (c *clusterConn) receive(run func(redis.Conn) (interface{}, error)) (interface{}, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	if err := c.bind("", false); err != nil {
+		return nil, err
+	}
+	if c.pending > 0 {
+		c.pending--
+	}
+	return run(c.conn)
+}
@@ -0,0 +1,411 @@
+package redis
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gomodule/redigo/redis"
+)
+
+// fakeNode is a cluster node speaking just enough RESP for the tests.
+type fakeNode struct {
+	ln      net.Listener
+	handler func(n *fakeNode, asking bool, args []string) string
+
+	mu    sync.Mutex
+	calls map[string]int
+}
+
+func newFakeNode(t *testing.T, handler func(n *fakeNode, asking bool, args []string) string) *fakeNode {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &fakeNode{ln: ln, handler: handler, calls: make(map[string]int)}
+	go n.serve()
+	t.Cleanup(func() { ln.Close() })
+	return n
+}
+
+func (n *fakeNode) addr() string {
+	return n.ln.Addr().String()
+}
+
+func (n *fakeNode) count(command string) int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.calls[command]
+}
+
+func (n *fakeNode) serve() {
+	for {
+		c, err := n.ln.Accept()
+		if err != nil {
+			return
+		}
+		go n.serveConn(c)
+	}
+}
+
+func (n *fakeNode) serveConn(c net.Conn) {
+	defer c.Close()
+
+	var (
+		r      = bufio.NewReader(c)
+		asking bool
+	)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+
+		command := strings.ToUpper(args[0])
+		n.mu.Lock()
+		n.calls[command]++
+		n.mu.Unlock()
+
+		var reply string
+		if command == "ASKING" {
+			asking = true
+			reply = "+OK\r\n"
+		} else {
+			reply = n.handler(n, asking, args)
+			asking = false
+		}
+		if _, err := io.WriteString(c, reply); err != nil {
+			return
+		}
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, count)
+	for i := range args {
+		if line, err = r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func bulk(s string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+}
+
+// newClusterNode returns a node serving the commands with a miniredis,
+// it answers CLUSTER SLOTS with slots as miniredis only knows a single
+// node.
+func newClusterNode(t *testing.T, slots func() string) (*fakeNode, *miniredis.Miniredis) {
+	m := newTestRedis(t)
+	conn, err := redis.Dial("tcp", m.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	var mu sync.Mutex
+	node := newFakeNode(t, func(n *fakeNode, asking bool, args []string) string {
+		if strings.ToUpper(args[0]) == "CLUSTER" {
+			return slots()
+		}
+		cmdArgs := make([]interface{}, len(args)-1)
+		for i, arg := range args[1:] {
+			cmdArgs[i] = arg
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return respReply(conn.Do(args[0], cmdArgs...))
+	})
+	return node, m
+}
+
+// respReply encodes a reply of redigo back to RESP.
+func respReply(reply interface{}, err error) string {
+	if err != nil {
+		if _, ok := err.(redis.Error); ok {
+			return "-" + err.Error() + "\r\n"
+		}
+		return "-ERR " + err.Error() + "\r\n"
+	}
+	switch v := reply.(type) {
+	case nil:
+		return "$-1\r\n"
+	case int64:
+		return ":" + strconv.FormatInt(v, 10) + "\r\n"
+	case string:
+		return "+" + v + "\r\n"
+	case []byte:
+		return bulk(string(v))
+	case redis.Error:
+		return "-" + v.Error() + "\r\n"
+	case []interface{}:
+		s := "*" + strconv.Itoa(len(v)) + "\r\n"
+		for _, e := range v {
+			s += respReply(e, nil)
+		}
+		return s
+	}
+	return fmt.Sprintf("-ERR unexpected reply %T\r\n", reply)
+}
+
+// slotsReply is a CLUSTER SLOTS reply giving [0, split) to a and the
+// other slots to b.
+func slotsReply(a, b string, split int) string {
+	node := func(addr string) string {
+		host, port, _ := net.SplitHostPort(addr)
+		return "*3\r\n" + bulk(host) + ":" + port + "\r\n" + bulk("id-"+port)
+	}
+	if b == "" {
+		return "*1\r\n*3\r\n:0\r\n:16383\r\n" + node(a)
+	}
+	return fmt.Sprintf("*2\r\n*3\r\n:0\r\n:%d\r\n%s*3\r\n:%d\r\n:16383\r\n%s",
+		split-1, node(a), split, node(b))
+}
+
+func newTestCluster(addrs ...string) *Pool {
+	conf := &Config{Cluster: addrs}
+	conf.merge(defaultConfig)
+	return NewCluster(conf)
+}
+
+func TestSlot(t *testing.T) {
+	for key, slot := range map[string]int{
+		"123456789":             12739,
+		"foo":                   12182,
+		"bar":                   5061,
+		"{foo}.bar":             12182,
+		"x{foo}y{bar}":          12182,
+		"{user1000}.followers":  Slot("{user1000}.following"),
+		"user1000}.followers{":  Slot("user1000}.followers{"),
+		"{user1000}.following2": Slot("user1000"),
+	} {
+		if got := Slot(key); got != slot {
+			t.Errorf("Slot(%q) = %d, want %d", key, got, slot)
+		}
+	}
+	if Slot("{}foo") == Slot("") {
+		t.Error("an empty hash tag must hash the whole key")
+	}
+}
+
+func TestCommandKey(t *testing.T) {
+	for _, tt := range []struct {
+		command string
+		args    []interface{}
+		key     string
+		hasKey  bool
+	}{
+		{"GET", []interface{}{"foo"}, "foo", true},
+		{"hset", []interface{}{[]byte("h"), "f", 1}, "h", true},
+		{"PING", nil, "", false},
+		{"MULTI", nil, "", false},
+		{"EVALSHA", []interface{}{"sha", 1, "k", "a"}, "k", true},
+		{"EVALSHA", []interface{}{"sha", 0, "a"}, "", false},
+		{"XGROUP", []interface{}{"CREATE", "s", "g", "$"}, "s", true},
+		{"XREADGROUP", []interface{}{"GROUP", "g", "c", "STREAMS", "s", ">"}, "s", true},
+	} {
+		key, hasKey := commandKey(tt.command, tt.args)
+		if key != tt.key || hasKey != tt.hasKey {
+			t.Errorf("commandKey(%s, %v) = %q, %v, want %q, %v", tt.command, tt.args, key, hasKey, tt.key, tt.hasKey)
+		}
+	}
+}
+
+func TestClusterRedirects(t *testing.T) {
+	var a, b *fakeNode
+	b = newFakeNode(t, func(n *fakeNode, asking bool, args []string) string {
+		switch strings.ToUpper(args[0]) {
+		case "CLUSTER":
+			return slotsReply(a.addr(), "", 0)
+		case "GET":
+			if args[1] == "migrating" && !asking {
+				return fmt.Sprintf("-MOVED %d %s\r\n", Slot(args[1]), a.addr())
+			}
+			return bulk("b:" + args[1])
+		}
+		return "-ERR unknown command\r\n"
+	})
+	a = newFakeNode(t, func(n *fakeNode, asking bool, args []string) string {
+		switch strings.ToUpper(args[0]) {
+		case "CLUSTER":
+			return slotsReply(a.addr(), "", 0)
+		case "GET":
+			switch args[1] {
+			case "foo":
+				return fmt.Sprintf("-MOVED %d %s\r\n", Slot(args[1]), b.addr())
+			case "migrating":
+				return fmt.Sprintf("-ASK %d %s\r\n", Slot(args[1]), b.addr())
+			}
+			return bulk("a:" + args[1])
+		}
+		return "-ERR unknown command\r\n"
+	})
+
+	p := newTestCluster(a.addr())
+	defer p.Close()
+
+	if v, err := p.Get("bar"); err != nil || v != "a:bar" {
+		t.Fatalf("Get(bar) = %q, %v", v, err)
+	}
+	if v, err := p.Get("foo"); err != nil || v != "b:foo" {
+		t.Fatalf("Get(foo) = %q, %v", v, err)
+	}
+	if v, err := p.Get("migrating"); err != nil || v != "b:migrating" {
+		t.Fatalf("Get(migrating) = %q, %v", v, err)
+	}
+	if n := b.count("ASKING"); n != 1 {
+		t.Fatalf("ASKING sent %d times, want 1", n)
+	}
+
+	// ASK does not change the slot map
+	if addr, _ := p.cluster.addr("migrating", true); addr != a.addr() {
+		t.Fatalf("migrating served by %s, want %s", addr, a.addr())
+	}
+}
+
+func TestClusterPipelineRedirects(t *testing.T) {
+	var (
+		a, b  *fakeNode
+		moved int32 // foo moved to b
+	)
+	slots := func() string {
+		if atomic.LoadInt32(&moved) == 1 {
+			return slotsReply(a.addr(), b.addr(), 8192)
+		}
+		return slotsReply(a.addr(), "", 0)
+	}
+	b = newFakeNode(t, func(n *fakeNode, asking bool, args []string) string {
+		switch strings.ToUpper(args[0]) {
+		case "CLUSTER":
+			return slots()
+		case "GET":
+			if args[1] == "migrating" && !asking {
+				return fmt.Sprintf("-MOVED %d %s\r\n", Slot(args[1]), a.addr())
+			}
+			return bulk("b:" + args[1])
+		}
+		return "-ERR unknown command\r\n"
+	})
+	a = newFakeNode(t, func(n *fakeNode, asking bool, args []string) string {
+		switch strings.ToUpper(args[0]) {
+		case "CLUSTER":
+			return slots()
+		case "GET":
+			switch args[1] {
+			case "foo":
+				atomic.StoreInt32(&moved, 1)
+				return fmt.Sprintf("-MOVED %d %s\r\n", Slot(args[1]), b.addr())
+			case "migrating":
+				return fmt.Sprintf("-ASK %d %s\r\n", Slot(args[1]), b.addr())
+			}
+			return bulk("a:" + args[1])
+		}
+		return "-ERR unknown command\r\n"
+	})
+
+	p := newTestCluster(a.addr())
+	defer p.Close()
+
+	var cmds []clusterCommand
+	for _, key := range []string{"bar", "foo", "migrating", "baz"} {
+		cmds = append(cmds, clusterCommand{key: key, name: "GET", args: []interface{}{key}})
+	}
+	replies, err := p.cluster.pipeline(context.Background(), cmds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values, err := redis.Strings(replies, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(values, ","); got != "a:bar,b:foo,b:migrating,a:baz" {
+		t.Fatalf("replies = %s", got)
+	}
+	if n := b.count("ASKING"); n != 1 {
+		t.Fatalf("ASKING sent %d times, want 1", n)
+	}
+
+	// MOVED updates the slot map, ASK does not
+	if addr, _ := p.cluster.addr("foo", true); addr != b.addr() {
+		t.Fatalf("foo served by %s, want %s", addr, b.addr())
+	}
+	if addr, _ := p.cluster.addr("migrating", true); addr != a.addr() {
+		t.Fatalf("migrating served by %s, want %s", addr, a.addr())
+	}
+}
+
+func TestClusterScripts(t *testing.T) {
+	var a, b *fakeNode
+	slots := func() string { return slotsReply(a.addr(), b.addr(), 8192) }
+	a, ma := newClusterNode(t, slots)
+	b, mb := newClusterNode(t, slots)
+
+	dir := t.TempDir()
+	src := []byte("return redis.call('INCRBY', KEYS[1], ARGV[1])")
+	if err := ioutil.WriteFile(filepath.Join(dir, "incr_1.lua"), src, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	p := newTestCluster(a.addr())
+	defer p.Close()
+	if err := p.loadScript(dir); err != nil {
+		t.Fatal(err)
+	}
+	if a.count("SCRIPT") != 1 || b.count("SCRIPT") != 1 {
+		t.Fatalf("SCRIPT LOAD sent %d and %d times, want once per master", a.count("SCRIPT"), b.count("SCRIPT"))
+	}
+
+	// "bar" is in the first half of the slots, "foo" in the second
+	if err := p.BulkScript("incr_1", [][]interface{}{{"bar", 1}, {"foo", 1}, {"bar", 2}}); err != nil {
+		t.Fatal(err)
+	}
+	if a.count("EVALSHA") != 2 || b.count("EVALSHA") != 1 {
+		t.Fatalf("EVALSHA sent %d and %d times, want 2 and 1", a.count("EVALSHA"), b.count("EVALSHA"))
+	}
+	if v, _ := ma.Get("bar"); v != "3" {
+		t.Fatalf("bar = %q on the first master, want 3", v)
+	}
+	if v, _ := mb.Get("foo"); v != "1" {
+		t.Fatalf("foo = %q on the second master, want 1", v)
+	}
+
+	if err := p.ScanDels("*"); err != nil {
+		t.Fatal(err)
+	}
+	if a.count("SCAN") != 1 || b.count("SCAN") != 1 {
+		t.Fatalf("SCAN sent %d and %d times, want once per master", a.count("SCAN"), b.count("SCAN"))
+	}
+	if len(ma.Keys())+len(mb.Keys()) != 0 {
+		t.Fatalf("keys left %v and %v", ma.Keys(), mb.Keys())
+	}
+}
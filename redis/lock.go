@@ -0,0 +1,352 @@
+package redis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	mrand "math/rand"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+var (
+	// ErrNotObtained is returned when a lock is held by someone else.
+	ErrNotObtained = errors.New("redis: lock not obtained")
+	// ErrLockNotHeld is returned when releasing or extending a lock which
+	// expired or was taken over.
+	ErrLockNotHeld = errors.New("redis: lock not held")
+)
+
+// scripts of the Locker, named after the key-count convention of the
+// script loader
+const (
+	lockAcquireScript = "locker_acquire_1"
+	lockReleaseScript = "locker_release_1"
+	lockExtendScript  = "locker_extend_1"
+)
+
+var lockScripts = map[string]string{
+	lockAcquireScript: `if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then return 1 end return 0`,
+	lockReleaseScript: `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) end return 0`,
+	lockExtendScript:  `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("PEXPIRE", KEYS[1], ARGV[2]) end return 0`,
+}
+
+const (
+	defaultLockTTL        = 30 * time.Second
+	defaultLockMinBackoff = 50 * time.Millisecond
+	defaultLockMaxBackoff = time.Second
+	// lockClockDrift is the clock drift factor of the Redlock algorithm.
+	lockClockDrift = 0.01
+)
+
+type lockerOptions struct {
+	ttl        time.Duration
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	watchdog   bool
+	pools      []*Pool
+}
+
+// LockerOption ...
+type LockerOption func(*lockerOptions)
+
+// WithLockTTL sets the time to live of the locks, default 30s.
+func WithLockTTL(ttl time.Duration) LockerOption {
+	return func(o *lockerOptions) {
+		if ttl > 0 {
+			o.ttl = ttl
+		}
+	}
+}
+
+// WithLockBackoff sets the delays between the attempts of Lock, they
+// double from min up to max, default 50ms and 1s.
+func WithLockBackoff(min, max time.Duration) LockerOption {
+	return func(o *lockerOptions) {
+		if min > 0 {
+			o.minBackoff = min
+		}
+		if max >= o.minBackoff {
+			o.maxBackoff = max
+		}
+	}
+}
+
+// WithWatchdog extends held locks every third of their TTL until they
+// are released, see Lock.Done.
+func WithWatchdog() LockerOption {
+	return func(o *lockerOptions) {
+		o.watchdog = true
+	}
+}
+
+// WithRedlock acquires the locks on a majority of pool and pools, which
+// must be independent masters, following the Redlock algorithm.
+func WithRedlock(pools ...*Pool) LockerOption {
+	return func(o *lockerOptions) {
+		o.pools = append(o.pools, pools...)
+	}
+}
+
+// Locker acquires distributed locks identified by a key.
+type Locker struct {
+	pools []*Pool
+	opts  lockerOptions
+}
+
+// NewLocker ...
+func NewLocker(pool *Pool, opts ...LockerOption) *Locker {
+	o := lockerOptions{
+		ttl:        defaultLockTTL,
+		minBackoff: defaultLockMinBackoff,
+		maxBackoff: defaultLockMaxBackoff,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	l := &Locker{
+		pools: append([]*Pool{pool}, o.pools...),
+		opts:  o,
+	}
+	for _, p := range l.pools {
+		for name, src := range lockScripts {
+			if p.script(name) == nil {
+				p.setScript(name, 1, src)
+			}
+		}
+	}
+	return l
+}
+
+// TryLock makes a single attempt to acquire the lock of key, it returns
+// ErrNotObtained if the lock is held.
+func (l *Locker) TryLock(ctx context.Context, key string) (*Lock, error) {
+	token, err := newLockToken()
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	n, err := l.run(ctx, lockAcquireScript, key, token, l.opts.ttl.Milliseconds())
+	validity := l.opts.ttl - time.Since(start) - l.drift()
+	if n < l.quorum() || validity <= 0 {
+		if n > 0 {
+			l.run(context.Background(), lockReleaseScript, key, token)
+		}
+		if e := ctx.Err(); e != nil {
+			return nil, e
+		}
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrNotObtained
+	}
+
+	lock := &Lock{
+		locker: l,
+		key:    key,
+		token:  token,
+		done:   make(chan struct{}),
+		stop:   make(chan struct{}),
+	}
+	if l.opts.watchdog {
+		go lock.watchdog()
+	}
+	return lock, nil
+}
+
+// Lock acquires the lock of key, retrying with backoff until ctx is done.
+func (l *Locker) Lock(ctx context.Context, key string) (*Lock, error) {
+	backoff := l.opts.minBackoff
+	for {
+		lock, err := l.TryLock(ctx, key)
+		if err != ErrNotObtained {
+			return lock, err
+		}
+
+		// sleep between half and the whole backoff, so that contenders
+		// do not retry in step
+		delay := backoff/2 + time.Duration(mrand.Int63n(int64(backoff/2)+1))
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+
+		if backoff *= 2; backoff > l.opts.maxBackoff {
+			backoff = l.opts.maxBackoff
+		}
+	}
+}
+
+// run runs script on every pool and returns the number of them on
+// which it succeeded, the error is set when every pool failed with one.
+func (l *Locker) run(ctx context.Context, script, key string, args ...interface{}) (int, error) {
+	if len(l.pools) == 1 {
+		ok, err := l.runOn(ctx, l.pools[0], script, key, args...)
+		if ok {
+			return 1, nil
+		}
+		return 0, err
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		n    int
+		errs int
+		err  error
+	)
+	for _, p := range l.pools {
+		wg.Add(1)
+		go func(p *Pool) {
+			defer wg.Done()
+			ok, e := l.runOn(ctx, p, script, key, args...)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if ok {
+				n++
+			}
+			if e != nil {
+				errs++
+				err = e
+			}
+		}(p)
+	}
+	wg.Wait()
+
+	if errs < len(l.pools) {
+		err = nil
+	}
+	return n, err
+}
+
+func (l *Locker) runOn(ctx context.Context, p *Pool, script, key string, args ...interface{}) (bool, error) {
+	var ok bool
+	err := p.SendScriptContext(ctx, script, func(reply interface{}, err error) error {
+		n, err := redis.Int(reply, err)
+		ok = err == nil && n == 1
+		return err
+	}, append([]interface{}{key}, args...)...)
+	return ok, err
+}
+
+func (l *Locker) quorum() int {
+	return len(l.pools)/2 + 1
+}
+
+// drift is the clock drift allowed for by the Redlock algorithm.
+func (l *Locker) drift() time.Duration {
+	return time.Duration(float64(l.opts.ttl)*lockClockDrift) + 2*time.Millisecond
+}
+
+func newLockToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Lock is a lock acquired by a Locker.
+type Lock struct {
+	locker *Locker
+	key    string
+	token  string
+
+	doneOnce sync.Once
+	done     chan struct{} // closed once released or lost
+	stopOnce sync.Once
+	stop     chan struct{} // closed on Unlock
+}
+
+// Key ...
+func (lk *Lock) Key() string {
+	return lk.key
+}
+
+// Token is the random value identifying the holder of the lock.
+func (lk *Lock) Token() string {
+	return lk.token
+}
+
+// Done is closed once the lock is released, or when the watchdog fails
+// to extend it and it may be held by someone else.
+func (lk *Lock) Done() <-chan struct{} {
+	return lk.done
+}
+
+// Extend resets the TTL of the lock, it returns ErrLockNotHeld if the
+// lock expired or was taken over.
+func (lk *Lock) Extend(ctx context.Context) error {
+	l := lk.locker
+	start := time.Now()
+	n, err := l.run(ctx, lockExtendScript, lk.key, lk.token, l.opts.ttl.Milliseconds())
+	if n < l.quorum() || time.Since(start)+l.drift() >= l.opts.ttl {
+		if e := ctx.Err(); e != nil {
+			return e
+		}
+		if err != nil {
+			return err
+		}
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+// Unlock releases the lock, it returns ErrLockNotHeld if the lock
+// expired or was taken over.
+func (lk *Lock) Unlock(ctx context.Context) error {
+	lk.stopOnce.Do(func() {
+		close(lk.stop)
+	})
+
+	l := lk.locker
+	n, err := l.run(ctx, lockReleaseScript, lk.key, lk.token)
+	lk.release()
+	if n < l.quorum() {
+		if e := ctx.Err(); e != nil {
+			return e
+		}
+		if err != nil {
+			return err
+		}
+		return ErrLockNotHeld
+	}
+	return nil
+}
+
+func (lk *Lock) release() {
+	lk.doneOnce.Do(func() {
+		close(lk.done)
+	})
+}
+
+func (lk *Lock) watchdog() {
+	interval := lk.locker.opts.ttl / 3
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-lk.stop:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		err := lk.Extend(ctx)
+		cancel()
+		if err != nil {
+			lk.release()
+			return
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package redis
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func newTestPool(addr string) *Pool {
+	conf := &Config{MasterAddr: addr}
+	conf.merge(defaultConfig)
+	return New(conf)
+}
+
+// downAddr returns an address refusing conns.
+func downAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+	return ln.Addr().String()
+}
+
+func TestLocker(t *testing.T) {
+	m := newTestRedis(t)
+	p := newTestPool(m.Addr())
+	defer p.Close()
+
+	ctx := context.Background()
+	l := NewLocker(p, WithLockBackoff(10*time.Millisecond, 20*time.Millisecond))
+
+	lock, err := l.TryLock(ctx, "job")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := m.Get("job"); v != lock.token || m.TTL("job") != defaultLockTTL {
+		t.Fatalf("key = %q with ttl %v, want the token with ttl %v", v, m.TTL("job"), defaultLockTTL)
+	}
+	if _, err := l.TryLock(ctx, "job"); err != ErrNotObtained {
+		t.Fatalf("err = %v, want %v", err, ErrNotObtained)
+	}
+
+	m.FastForward(20 * time.Second)
+	if err := lock.Extend(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := m.TTL("job"); ttl != defaultLockTTL {
+		t.Fatalf("ttl = %v after Extend, want %v", ttl, defaultLockTTL)
+	}
+
+	timeout, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if _, err := l.Lock(timeout, "job"); err != context.DeadlineExceeded {
+		t.Fatalf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// a waiting Lock gets the lock once released
+	acquired := make(chan error, 1)
+	go func() {
+		lock, err := l.Lock(ctx, "job")
+		if err == nil {
+			err = lock.Unlock(ctx)
+		}
+		acquired <- err
+	}()
+
+	time.Sleep(30 * time.Millisecond)
+	if err := lock.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-lock.Done():
+	default:
+		t.Fatal("Done not closed after Unlock")
+	}
+	if err := lock.Unlock(ctx); err != ErrLockNotHeld {
+		t.Fatalf("err = %v, want %v", err, ErrLockNotHeld)
+	}
+	if err := lock.Extend(ctx); err != ErrLockNotHeld {
+		t.Fatalf("err = %v, want %v", err, ErrLockNotHeld)
+	}
+
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Lock not acquired after Unlock")
+	}
+}
+
+func TestLockerWatchdog(t *testing.T) {
+	m := newTestRedis(t)
+	p := newTestPool(m.Addr())
+	defer p.Close()
+
+	l := NewLocker(p, WithLockTTL(60*time.Millisecond), WithWatchdog())
+	lock, err := l.TryLock(context.Background(), "job")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the clock of miniredis only moves forward when told
+	for i := 0; i < 10; i++ {
+		time.Sleep(10 * time.Millisecond)
+		m.FastForward(10 * time.Millisecond)
+	}
+	select {
+	case <-lock.Done():
+		t.Fatal("lock lost while extended")
+	default:
+	}
+	if !m.Exists("job") {
+		t.Fatal("key expired while extended")
+	}
+
+	// the key expired
+	m.FastForward(time.Second)
+	select {
+	case <-lock.Done():
+	case <-time.After(time.Second):
+		t.Fatal("Done not closed after the lock was lost")
+	}
+}
+
+func TestRedlock(t *testing.T) {
+	a, b := newTestRedis(t), newTestRedis(t)
+
+	pa, pb, pdown := newTestPool(a.Addr()), newTestPool(b.Addr()), newTestPool(downAddr(t))
+	defer pa.Close()
+	defer pb.Close()
+	defer pdown.Close()
+
+	ctx := context.Background()
+
+	// two of three masters are a majority
+	l := NewLocker(pa, WithRedlock(pb, pdown))
+	lock, err := l.TryLock(ctx, "job")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.TryLock(ctx, "job"); err != ErrNotObtained {
+		t.Fatalf("err = %v, want %v", err, ErrNotObtained)
+	}
+	if err := lock.Extend(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.Unlock(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// one of three is not
+	l = NewLocker(pa, WithRedlock(newTestPool(downAddr(t)), pdown))
+	if _, err := l.TryLock(ctx, "job"); err != ErrNotObtained {
+		t.Fatalf("err = %v, want %v", err, ErrNotObtained)
+	}
+
+	// nor none, the error is reported
+	l = NewLocker(pdown)
+	if _, err := l.TryLock(ctx, "job"); err == nil || err == ErrNotObtained {
+		t.Fatalf("err = %v, want the dial error", err)
+	}
+}
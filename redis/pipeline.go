@@ -0,0 +1,335 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	mrand "math/rand"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// ErrTxAborted is returned by Tx when a watched key kept changing, or
+// the slot of the keys kept being redirected, until the retries ran out.
+var ErrTxAborted = errors.New("redis: transaction aborted")
+
+const (
+	defaultTxRetries    = 3
+	defaultTxMinBackoff = 10 * time.Millisecond
+	defaultTxMaxBackoff = 100 * time.Millisecond
+)
+
+// Cmd is a queued command, its reply is set by the Exec of its pipeline
+// or transaction.
+type Cmd struct {
+	name  string
+	args  []interface{}
+	reply interface{}
+	err   error
+}
+
+// Reply ...
+func (c *Cmd) Reply() (interface{}, error) {
+	return c.reply, c.err
+}
+
+// Err ...
+func (c *Cmd) Err() error {
+	return c.err
+}
+
+// Int ...
+func (c *Cmd) Int() (int, error) {
+	return redis.Int(c.reply, c.err)
+}
+
+// Int64 ...
+func (c *Cmd) Int64() (int64, error) {
+	return redis.Int64(c.reply, c.err)
+}
+
+// Float64 ...
+func (c *Cmd) Float64() (float64, error) {
+	return redis.Float64(c.reply, c.err)
+}
+
+// Bool ...
+func (c *Cmd) Bool() (bool, error) {
+	return redis.Bool(c.reply, c.err)
+}
+
+// String ...
+func (c *Cmd) String() (string, error) {
+	return redis.String(c.reply, c.err)
+}
+
+// Bytes ...
+func (c *Cmd) Bytes() ([]byte, error) {
+	return redis.Bytes(c.reply, c.err)
+}
+
+// Strings ...
+func (c *Cmd) Strings() ([]string, error) {
+	return redis.Strings(c.reply, c.err)
+}
+
+// Values ...
+func (c *Cmd) Values() ([]interface{}, error) {
+	return redis.Values(c.reply, c.err)
+}
+
+// StringMap ...
+func (c *Cmd) StringMap() (map[string]string, error) {
+	return redis.StringMap(c.reply, c.err)
+}
+
+// Pipeline queues commands and sends them in a single round trip, per
+// node in cluster mode.
+type Pipeline struct {
+	pool *Pool
+	cmds []*Cmd
+}
+
+// Pipeline ...
+func (p *Pool) Pipeline() *Pipeline {
+	return &Pipeline{pool: p}
+}
+
+// Send queues a command, its reply is set once Exec returns.
+func (pl *Pipeline) Send(cmd string, args ...interface{}) *Cmd {
+	c := &Cmd{name: cmd, args: args}
+	pl.cmds = append(pl.cmds, c)
+	return c
+}
+
+// Len returns the number of queued commands.
+func (pl *Pipeline) Len() int {
+	return len(pl.cmds)
+}
+
+// Exec sends the queued commands and sets their replies, it returns the
+// first error and empties the pipeline.
+func (pl *Pipeline) Exec(ctx context.Context) error {
+	cmds := pl.cmds
+	pl.cmds = nil
+	if len(cmds) == 0 {
+		return nil
+	}
+
+	if pl.pool.cluster != nil {
+		ccmds := make([]clusterCommand, len(cmds))
+		for i, c := range cmds {
+			key, _ := commandKey(c.name, c.args)
+			ccmds[i] = clusterCommand{key: key, name: c.name, args: c.args}
+		}
+		replies, err := pl.pool.cluster.pipeline(ctx, ccmds)
+		if replies == nil {
+			return setCmdsErr(cmds, err)
+		}
+		for i, c := range cmds {
+			c.reply, c.err = splitReply(replies[i])
+		}
+		return err
+	}
+
+	conn, err := pl.pool.ConnContext(ctx)
+	if err != nil {
+		return setCmdsErr(cmds, err)
+	}
+	defer conn.Close()
+
+	for _, c := range cmds {
+		conn.Send(c.name, c.args...)
+	}
+	if err := conn.Flush(); err != nil {
+		return setCmdsErr(cmds, err)
+	}
+	var firstErr error
+	for _, c := range cmds {
+		c.reply, c.err = receive(ctx, conn)
+		if c.err != nil && firstErr == nil {
+			firstErr = c.err
+		}
+	}
+	return firstErr
+}
+
+// splitReply splits the error replies of pipelines.
+func splitReply(r interface{}) (interface{}, error) {
+	if err, ok := r.(redis.Error); ok {
+		return nil, err
+	}
+	return r, nil
+}
+
+func setCmdsErr(cmds []*Cmd, err error) error {
+	for _, c := range cmds {
+		c.reply, c.err = nil, err
+	}
+	return err
+}
+
+type txOptions struct {
+	retries    int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+}
+
+// TxOption ...
+type TxOption func(*txOptions)
+
+// WithTxRetries sets how many times Tx runs again after an abort,
+// default 3.
+func WithTxRetries(n int) TxOption {
+	return func(o *txOptions) {
+		if n >= 0 {
+			o.retries = n
+		}
+	}
+}
+
+// WithTxBackoff sets the delays between the attempts of Tx, they double
+// from min up to max, default 10ms and 100ms.
+func WithTxBackoff(min, max time.Duration) TxOption {
+	return func(o *txOptions) {
+		if min > 0 {
+			o.minBackoff = min
+		}
+		if max >= o.minBackoff {
+			o.maxBackoff = max
+		}
+	}
+}
+
+// Tx is an optimistic transaction, Do runs commands on the conn watching
+// the keys and Send queues the ones run by MULTI/EXEC.
+type Tx struct {
+	ctx      context.Context
+	conn     redis.Conn
+	cmds     []*Cmd
+	redirect error // MOVED or ASK replied to Do
+}
+
+// Do runs a command right away, it is meant for reading watched keys.
+func (tx *Tx) Do(cmd string, args ...interface{}) (interface{}, error) {
+	reply, err := do(tx.ctx, tx.conn, cmd, args...)
+	if _, _, _, ok := redirect(err); ok {
+		tx.redirect = err
+	}
+	return reply, err
+}
+
+// Send queues a command, its reply is set once the transaction is
+// committed.
+func (tx *Tx) Send(cmd string, args ...interface{}) *Cmd {
+	c := &Cmd{name: cmd, args: args}
+	tx.cmds = append(tx.cmds, c)
+	return c
+}
+
+// Tx watches keys, calls fn and commits the commands it queued with
+// MULTI/EXEC. fn runs again when a watched key changed before EXEC, up to
+// the retries, then ErrTxAborted is returned. An error of fn discards the
+// transaction and is returned. In cluster mode every key must be in the
+// slot of the first watched key, an attempt redirected with MOVED or ASK
+// is aborted and runs again.
+func (p *Pool) Tx(ctx context.Context, watchKeys []string, fn func(*Tx) error, opts ...TxOption) error {
+	o := txOptions{
+		retries:    defaultTxRetries,
+		minBackoff: defaultTxMinBackoff,
+		maxBackoff: defaultTxMaxBackoff,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	backoff := o.minBackoff
+	for attempt := 0; ; attempt++ {
+		committed, err := p.tx(ctx, watchKeys, fn)
+		if err != nil || committed {
+			return err
+		}
+		if attempt == o.retries {
+			return ErrTxAborted
+		}
+
+		delay := backoff/2 + time.Duration(mrand.Int63n(int64(backoff/2)+1))
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		if backoff *= 2; backoff > o.maxBackoff {
+			backoff = o.maxBackoff
+		}
+	}
+}
+
+// tx runs a single attempt, committed is false when EXEC was aborted.
+func (p *Pool) tx(ctx context.Context, watchKeys []string, fn func(*Tx) error) (committed bool, err error) {
+	conn, err := p.ConnContext(ctx)
+	if err != nil {
+		return false, err
+	}
+	// closing a pooled conn discards its WATCH and MULTI state
+	defer conn.Close()
+	if cc, ok := conn.(*clusterConn); ok {
+		cc.noRedirect = true
+	}
+
+	if len(watchKeys) != 0 {
+		if _, err := do(ctx, conn, "WATCH", redis.Args{}.AddFlat(watchKeys)...); err != nil {
+			if p.redirected(err) {
+				return false, nil
+			}
+			return false, err
+		}
+	}
+
+	tx := &Tx{ctx: ctx, conn: conn}
+	err = fn(tx)
+	if p.redirected(tx.redirect) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if len(tx.cmds) == 0 {
+		return true, nil
+	}
+
+	conn.Send("MULTI")
+	for _, c := range tx.cmds {
+		conn.Send(c.name, c.args...)
+	}
+	replies, err := redis.Values(do(ctx, conn, "EXEC"))
+	if err == redis.ErrNil || p.redirected(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, setCmdsErr(tx.cmds, err)
+	}
+
+	var firstErr error
+	for i, c := range tx.cmds {
+		if i < len(replies) {
+			c.reply, c.err = splitReply(replies[i])
+		}
+		if c.err != nil && firstErr == nil {
+			firstErr = c.err
+		}
+	}
+	return true, firstErr
+}
+
+// redirected reports whether err is a MOVED or ASK reply, the slot map
+// is updated on MOVED for the next attempt of a Tx.
+func (p *Pool) redirected(err error) bool {
+	slot, addr, ask, ok := redirect(err)
+	if ok && !ask && p.cluster != nil {
+		p.cluster.moved(slot, addr)
+	}
+	return ok
+}
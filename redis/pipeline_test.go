@@ -0,0 +1,182 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+func TestPipeline(t *testing.T) {
+	m := newTestRedis(t)
+	p := newTestPool(m.Addr())
+	defer p.Close()
+
+	pl := p.Pipeline()
+	set := pl.Send("SET", "a", "1")
+	incr := pl.Send("INCR", "a")
+	bad := pl.Send("NOPE")
+	get := pl.Send("GET", "a")
+	if pl.Len() != 4 {
+		t.Fatalf("Len = %d, want 4", pl.Len())
+	}
+
+	err := pl.Exec(context.Background())
+	if err == nil || !strings.HasPrefix(err.Error(), "ERR unknown command") {
+		t.Fatalf("err = %v, want the error of NOPE", err)
+	}
+	if set.Err() != nil || bad.Err() == nil {
+		t.Fatalf("errs = %v, %v", set.Err(), bad.Err())
+	}
+	if n, err := incr.Int(); err != nil || n != 2 {
+		t.Fatalf("INCR = %d, %v", n, err)
+	}
+	if s, err := get.String(); err != nil || s != "2" {
+		t.Fatalf("GET = %q, %v", s, err)
+	}
+	if pl.Len() != 0 {
+		t.Fatalf("Len = %d after Exec, want 0", pl.Len())
+	}
+}
+
+func TestTx(t *testing.T) {
+	m := newTestRedis(t)
+	p := newTestPool(m.Addr())
+	defer p.Close()
+	ctx := context.Background()
+
+	// the first attempt is raced by another writer
+	var (
+		attempts int
+		incr     *Cmd
+	)
+	err := p.Tx(ctx, []string{"balance"}, func(tx *Tx) error {
+		attempts++
+		balance, err := redis.Int(tx.Do("GET", "balance"))
+		if err != nil && err != redis.ErrNil {
+			return err
+		}
+		if attempts == 1 {
+			p.Set("balance", "100")
+		}
+		tx.Send("SET", "balance", balance+10)
+		incr = tx.Send("INCR", "version")
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 {
+		t.Fatalf("attempts = %d, want 2", attempts)
+	}
+	if n, err := incr.Int(); err != nil || n != 1 {
+		t.Fatalf("INCR = %d, %v", n, err)
+	}
+	if v, _ := p.Get("balance"); v != "110" {
+		t.Fatalf("balance = %s, want 110", v)
+	}
+
+	attempts = 0
+	err = p.Tx(ctx, []string{"balance"}, func(tx *Tx) error {
+		attempts++
+		p.Set("balance", strconv.Itoa(attempts))
+		tx.Send("SET", "balance", 0)
+		return nil
+	}, WithTxRetries(2))
+	if err != ErrTxAborted || attempts != 3 {
+		t.Fatalf("err = %v after %d attempts, want %v after 3", err, attempts, ErrTxAborted)
+	}
+
+	errStop := errors.New("stop")
+	if err := p.Tx(ctx, []string{"balance"}, func(tx *Tx) error {
+		tx.Send("SET", "balance", 0)
+		return errStop
+	}); err != errStop {
+		t.Fatalf("err = %v, want %v", err, errStop)
+	}
+	if v, _ := p.Get("balance"); v != "3" {
+		t.Fatalf("balance = %s, want 3", v)
+	}
+}
+
+func TestPipelineRedirects(t *testing.T) {
+	var (
+		a, b  *fakeNode
+		moved int32 // the first half of the slots moved to b
+	)
+	slots := func() string {
+		if atomic.LoadInt32(&moved) == 1 {
+			return slotsReply(b.addr(), a.addr(), 8192)
+		}
+		return slotsReply(a.addr(), "", 0)
+	}
+	a = newFakeNode(t, func(n *fakeNode, asking bool, args []string) string {
+		switch strings.ToUpper(args[0]) {
+		case "CLUSTER":
+			return slots()
+		case "WATCH":
+			return "+OK\r\n"
+		case "GET":
+			atomic.StoreInt32(&moved, 1)
+			return fmt.Sprintf("-MOVED %d %s\r\n", Slot(args[1]), b.addr())
+		}
+		return "-ERR unknown command\r\n"
+	})
+	b = newFakeNode(t, func(n *fakeNode, asking bool, args []string) string {
+		switch strings.ToUpper(args[0]) {
+		case "CLUSTER":
+			return slots()
+		case "WATCH", "MULTI":
+			return "+OK\r\n"
+		case "GET":
+			return bulk("10")
+		case "SET":
+			return "+QUEUED\r\n"
+		case "EXEC":
+			return "*1\r\n+OK\r\n"
+		}
+		return "-ERR unknown command\r\n"
+	})
+	ctx := context.Background()
+
+	p := newTestCluster(a.addr())
+	pl := p.Pipeline()
+	get := pl.Send("GET", "balance")
+	if err := pl.Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := get.String(); err != nil || v != "10" {
+		t.Fatalf("GET = %q, %v", v, err)
+	}
+	p.Close()
+
+	// the WATCH of a conn is lost when a redirect is followed, the
+	// attempt runs again on the new node instead
+	atomic.StoreInt32(&moved, 0)
+	p = newTestCluster(a.addr())
+	defer p.Close()
+	var attempts int
+	err := p.Tx(ctx, []string{"balance"}, func(tx *Tx) error {
+		attempts++
+		balance, err := redis.Int(tx.Do("GET", "balance"))
+		if err != nil {
+			return err
+		}
+		tx.Send("SET", "balance", balance+10)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 {
+		t.Fatalf("attempts = %d, want 2", attempts)
+	}
+	if b.count("WATCH") != 1 || b.count("EXEC") != 1 {
+		t.Fatalf("WATCH and EXEC sent %d and %d times to the new node, want once", b.count("WATCH"), b.count("EXEC"))
+	}
+}
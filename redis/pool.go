@@ -1,12 +1,12 @@
 package redis
 
 import (
+	"context"
 	"errors"
-	"io/ioutil"
-	"os"
-	"path/filepath"
-	"strconv"
-	"strings"
+	"io/fs"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gomodule/redigo/redis"
 )
@@ -14,11 +14,19 @@ import (
 // Pool ...
 type Pool struct {
 	pool           *redis.Pool
-	scripts        map[string]*redis.Script
+	cluster        *cluster
+	scriptsMu      sync.RWMutex // protects scripts
+	scripts        map[string]*poolScript
 	scriptCallback func(string, string)
+	scriptFS       fs.FS
+	scriptReload   time.Duration
+	scriptLogger   Logger
+	stopWatch      chan struct{}
+	reloads        flightGroup[struct{}]
 	startPubSub    bool
 	pubSubClient   *PubSubClient
 	reSubCallBack  func()
+	pubSubOpts     []PubSubOption
 }
 
 // Option ...
@@ -42,79 +50,117 @@ func WithPubSubReSubCallBack(cb func()) Option {
 	}
 }
 
-func (p *Pool) loadScript(script string) error {
-	return filepath.Walk(script,
-		func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-			if info.IsDir() {
-				return nil
-			}
-
-			var (
-				fileName   = info.Name()
-				fileprefix string
-				count      string
-			)
-
-			if !strings.HasSuffix(fileName, LuaFileSuffix) {
-				return nil
-			}
-
-			fileprefix = strings.TrimSuffix(fileName, LuaFileSuffix)
-			for i := len(fileprefix) - 1; i >= 0; i-- {
-				if fileprefix[i] != '_' {
-					continue
-				}
-
-				count = fileprefix[i+1:]
-				c, err := strconv.ParseInt(count, 10, 64)
-				if err != nil {
-					return nil
-				}
-
-				f, err := ioutil.ReadFile(path)
-				if err != nil {
-					return err
-				}
-
-				s := redis.NewScript(int(c), string(f))
-				conn := p.Conn()
-				err = s.Load(conn)
-				if err != nil {
-					return err
-				}
-				conn.Close()
-				p.scripts[fileprefix] = s
-				if p.scriptCallback != nil {
-					p.scriptCallback(fileprefix, s.Hash())
-				}
-				return nil
-			}
-			return nil
-		})
+// WithPubSubOptions sets the options of the pubsub client started by
+// Init.
+func WithPubSubOptions(opts ...PubSubOption) Option {
+	return func(p *Pool) {
+		p.pubSubOpts = append(p.pubSubOpts, opts...)
+	}
 }
 
-// Close ...
+// Close closes the pool and its pubsub client, it must not be called
+// from a pubsub callback.
 func (p *Pool) Close() {
+	if p.stopWatch != nil {
+		close(p.stopWatch)
+		p.stopWatch = nil
+	}
+	if p.pubSubClient != nil {
+		p.pubSubClient.Close()
+	}
+	if p.cluster != nil {
+		p.cluster.close()
+		return
+	}
 	p.pool.Close()
 }
 
 // Get ...
+// In cluster mode the conn is bound to the node serving the key of its
+// first command.
 func (p *Pool) Conn() redis.Conn {
+	if p.cluster != nil {
+		return newClusterConn(context.Background(), p.cluster)
+	}
 	return p.pool.Get()
 }
 
+// ConnContext gets a conn from the pool, waiting for a free one until
+// ctx is done.
+func (p *Pool) ConnContext(ctx context.Context) (redis.Conn, error) {
+	if p.cluster != nil {
+		return newClusterConn(ctx, p.cluster), nil
+	}
+	return p.pool.GetContext(ctx)
+}
+
+// scanKeys returns the keys matching match with SCAN.
+func scanKeys(ctx context.Context, conn redis.Conn, match string) ([]string, error) {
+	var keys []string
+
+	iter := 0
+	for {
+		arr, err := redis.Values(do(ctx, conn, "SCAN", iter, "MATCH", match))
+		if err != nil {
+			return nil, err
+		}
+
+		iter, _ = redis.Int(arr[0], nil)
+		k, _ := redis.Strings(arr[1], nil)
+		keys = append(keys, k...)
+
+		if iter == 0 {
+			return keys, nil
+		}
+	}
+}
+
+// clusterScanKeys returns the keys matching match on every master.
+func (p *Pool) clusterScanKeys(ctx context.Context, match string) ([]string, error) {
+	var keys []string
+	err := p.cluster.each(ctx, func(conn redis.Conn) error {
+		k, err := scanKeys(ctx, conn, match)
+		keys = append(keys, k...)
+		return err
+	})
+	return keys, err
+}
+
+// do runs the command on conn, the command fails once ctx is done and
+// the deadline of ctx, if shorter, replaces the read timeout.
+func do(ctx context.Context, conn redis.Conn, command string, args ...interface{}) (interface{}, error) {
+	if ctx.Done() == nil {
+		return conn.Do(command, args...)
+	}
+	return redis.DoContext(conn, ctx, command, args...)
+}
+
+// receive is like do for a pipelined reply.
+func receive(ctx context.Context, conn redis.Conn) (interface{}, error) {
+	if ctx.Done() == nil {
+		return conn.Receive()
+	}
+	return redis.ReceiveContext(conn, ctx)
+}
+
 // SendScript ...
 func (p *Pool) SendScript(script string, f ReplyFunc, args ...interface{}) error {
-	s := p.scripts[script]
+	return p.SendScriptContext(context.Background(), script, f, args...)
+}
+
+// SendScriptContext ...
+func (p *Pool) SendScriptContext(ctx context.Context, script string, f ReplyFunc, args ...interface{}) error {
+	s := p.script(script)
 	if s == nil {
 		return errors.New("not found script")
 	}
-	var conn = p.Conn()
+	conn, err := p.ConnContext(ctx)
+	if err != nil {
+		return err
+	}
 	defer conn.Close()
-	replay, err := s.Do(conn, args...)
+
+	replay, err := p.eval(ctx, conn, s, args)
 	if f != nil {
 		return f(replay, err)
 	}
@@ -123,31 +169,60 @@ func (p *Pool) SendScript(script string, f ReplyFunc, args ...interface{}) error
 
 // BulkScript ...
 func (p *Pool) BulkScript(script string, args [][]interface{}) error {
-	s := p.scripts[script]
+	return p.BulkScriptContext(context.Background(), script, args)
+}
+
+// BulkScriptContext ...
+func (p *Pool) BulkScriptContext(ctx context.Context, script string, args [][]interface{}) error {
+	s := p.script(script)
 	if s == nil {
 		return errors.New("not found script")
 	}
-	var conn = p.Conn()
-	defer conn.Close()
 
-	for _, arg := range args {
-		s.SendHash(conn, arg...)
+	start := time.Now()
+	replies, err := p.bulk(ctx, s, args)
+	var (
+		missing  [][]interface{}
+		firstErr error // not NOSCRIPT
+	)
+	for i, reply := range replies {
+		e, ok := reply.(redis.Error)
+		switch {
+		case ok && isNoScript(e):
+			missing = append(missing, args[i])
+		case ok && firstErr == nil:
+			firstErr = e
+		}
 	}
-	conn.Flush()
-	_, err := conn.Receive()
+	// the other scripts ran, only the missing ones are sent again
+	if len(missing) != 0 {
+		atomic.AddUint64(&s.stats.noScript, 1)
+		if p.reloadScripts(ctx) == nil {
+			_, err = p.bulk(ctx, s, missing)
+			if firstErr != nil {
+				err = firstErr
+			}
+		}
+	}
+	s.stats.observe(time.Since(start), err)
 	return err
 }
 
 // Set ...
 func (p *Pool) Set(key, value string) error {
-	var (
-		conn = p.Conn()
-		err  error
-	)
+	return p.SetContext(context.Background(), key, value)
+}
+
+// SetContext ...
+func (p *Pool) SetContext(ctx context.Context, key, value string) error {
+	conn, err := p.ConnContext(ctx)
+	if err != nil {
+		return err
+	}
 
 	defer conn.Close()
 
-	_, err = conn.Do("SET", key, value)
+	_, err = do(ctx, conn, "SET", key, value)
 	if err != nil {
 		return err
 	}
@@ -157,14 +232,19 @@ func (p *Pool) Set(key, value string) error {
 
 // GetSet ...
 func (p *Pool) GetSet(key, value string) (string, error) {
-	var (
-		conn = p.Conn()
-		err  error
-	)
+	return p.GetSetContext(context.Background(), key, value)
+}
+
+// GetSetContext ...
+func (p *Pool) GetSetContext(ctx context.Context, key, value string) (string, error) {
+	conn, err := p.ConnContext(ctx)
+	if err != nil {
+		return "", err
+	}
 
 	defer conn.Close()
 
-	value, err = redis.String(conn.Do("GETSET", key, value))
+	value, err = redis.String(do(ctx, conn, "GETSET", key, value))
 	if err != nil {
 		return "", err
 	}
@@ -175,40 +255,52 @@ func (p *Pool) GetSet(key, value string) (string, error) {
 
 // SetNX ...
 func (p *Pool) SetNX(key, value string) (int, error) {
-	var (
-		conn = p.Conn()
-		err  error
-		ret  int
-	)
-
-	defer conn.Close()
+	return p.SetNXContext(context.Background(), key, value)
+}
 
-	ret, err = redis.Int(conn.Do("SETNX", key, value))
+// SetNXContext ...
+func (p *Pool) SetNXContext(ctx context.Context, key, value string) (int, error) {
+	conn, err := p.ConnContext(ctx)
 	if err != nil {
-		return ret, err
+		return 0, err
 	}
 
-	return ret, nil
+	defer conn.Close()
+
+	return redis.Int(do(ctx, conn, "SETNX", key, value))
 }
 
 // SetEX ...
 func (p *Pool) SetEX(key, value string, seconds int) (int, error) {
-	var conn = p.Conn()
+	return p.SetEXContext(context.Background(), key, value, seconds)
+}
+
+// SetEXContext ...
+func (p *Pool) SetEXContext(ctx context.Context, key, value string, seconds int) (int, error) {
+	conn, err := p.ConnContext(ctx)
+	if err != nil {
+		return 0, err
+	}
 	defer conn.Close()
 
-	return redis.Int(conn.Do("SETEX", key, seconds, value))
+	return redis.Int(do(ctx, conn, "SETEX", key, seconds, value))
 }
 
 // HSet ...
 func (p *Pool) HSet(key string, field, value string) error {
-	var (
-		conn = p.Conn()
-		err  error
-	)
+	return p.HSetContext(context.Background(), key, field, value)
+}
+
+// HSetContext ...
+func (p *Pool) HSetContext(ctx context.Context, key string, field, value string) error {
+	conn, err := p.ConnContext(ctx)
+	if err != nil {
+		return err
+	}
 
 	defer conn.Close()
 
-	_, err = conn.Do("HSET", key, field, value)
+	_, err = do(ctx, conn, "HSET", key, field, value)
 	if err != nil {
 		return err
 	}
@@ -218,97 +310,151 @@ func (p *Pool) HSet(key string, field, value string) error {
 
 // HIncrBy ...
 func (p *Pool) HIncrBy(key string, field string, value int) (int, error) {
-	var (
-		conn = p.Conn()
-	)
+	return p.HIncrByContext(context.Background(), key, field, value)
+}
+
+// HIncrByContext ...
+func (p *Pool) HIncrByContext(ctx context.Context, key string, field string, value int) (int, error) {
+	conn, err := p.ConnContext(ctx)
+	if err != nil {
+		return 0, err
+	}
 
 	defer conn.Close()
 
-	return redis.Int(conn.Do("HINCRBY", key, field, value))
+	return redis.Int(do(ctx, conn, "HINCRBY", key, field, value))
 }
 
 // HMSet ...
 func (p *Pool) HMSet(key string, value interface{}) error {
-	var (
-		conn = p.Conn()
-		err  error
-	)
+	return p.HMSetContext(context.Background(), key, value)
+}
+
+// HMSetContext ...
+func (p *Pool) HMSetContext(ctx context.Context, key string, value interface{}) error {
+	conn, err := p.ConnContext(ctx)
+	if err != nil {
+		return err
+	}
 
 	defer conn.Close()
 
-	_, err = conn.Do("HMSET", redis.Args{}.Add(key).AddFlat(value)...)
+	_, err = do(ctx, conn, "HMSET", redis.Args{}.Add(key).AddFlat(value)...)
 	return err
 }
 
 // BulkHMSet ...
 func (p *Pool) BulkHMSet(values map[string]interface{}) error {
-	var (
-		conn = p.Conn()
-		err  error
-	)
+	return p.BulkHMSetContext(context.Background(), values)
+}
+
+// BulkHMSetContext ...
+func (p *Pool) BulkHMSetContext(ctx context.Context, values map[string]interface{}) error {
+	if p.cluster != nil {
+		cmds := make([]clusterCommand, 0, len(values))
+		for key, value := range values {
+			cmds = append(cmds, clusterCommand{key: key, name: "HMSET", args: redis.Args{}.Add(key).AddFlat(value)})
+		}
+		_, err := p.cluster.pipeline(ctx, cmds)
+		return err
+	}
+
+	conn, err := p.ConnContext(ctx)
+	if err != nil {
+		return err
+	}
 
 	defer conn.Close()
 
 	for key, value := range values {
 		conn.Send("HMSET", redis.Args{}.Add(key).AddFlat(value)...)
 	}
-	_, err = redis.Values(conn.Do("EXEC"))
+	_, err = redis.Values(do(ctx, conn, "EXEC"))
 	return err
 }
 
 // SAdd ...
 func (p *Pool) SAdd(key string, member string) error {
-	var (
-		conn = p.Conn()
-	)
+	return p.SAddContext(context.Background(), key, member)
+}
+
+// SAddContext ...
+func (p *Pool) SAddContext(ctx context.Context, key string, member string) error {
+	conn, err := p.ConnContext(ctx)
+	if err != nil {
+		return err
+	}
 
 	defer conn.Close()
-	_, err := conn.Do("SADD", key, member)
+	_, err = do(ctx, conn, "SADD", key, member)
 	return err
 }
 
 // SRem ...
 func (p *Pool) SRem(key string, member string) error {
-	var (
-		conn = p.Conn()
-	)
+	return p.SRemContext(context.Background(), key, member)
+}
+
+// SRemContext ...
+func (p *Pool) SRemContext(ctx context.Context, key string, member string) error {
+	conn, err := p.ConnContext(ctx)
+	if err != nil {
+		return err
+	}
 
 	defer conn.Close()
-	_, err := conn.Do("SREM", key, member)
+	_, err = do(ctx, conn, "SREM", key, member)
 	return err
 }
 
 // Smembers ...
 func (p *Pool) Smembers(key string) ([]string, error) {
-	var (
-		conn = p.Conn()
-	)
+	return p.SmembersContext(context.Background(), key)
+}
+
+// SmembersContext ...
+func (p *Pool) SmembersContext(ctx context.Context, key string) ([]string, error) {
+	conn, err := p.ConnContext(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	defer conn.Close()
-	return redis.Strings(conn.Do("SMEMBERS", key))
+	return redis.Strings(do(ctx, conn, "SMEMBERS", key))
 }
 
 // HGet ...
 func (p *Pool) HGet(key string, field string) (string, error) {
-	var (
-		conn = p.Conn()
-	)
+	return p.HGetContext(context.Background(), key, field)
+}
+
+// HGetContext ...
+func (p *Pool) HGetContext(ctx context.Context, key string, field string) (string, error) {
+	conn, err := p.ConnContext(ctx)
+	if err != nil {
+		return "", err
+	}
 
 	defer conn.Close()
 
-	return redis.String(conn.Do("HGET", key, field))
+	return redis.String(do(ctx, conn, "HGET", key, field))
 }
 
 // HGetAll ...
 func (p *Pool) HGetAll(key string, value interface{}) error {
-	var (
-		conn = p.Conn()
-		err  error
-	)
+	return p.HGetAllContext(context.Background(), key, value)
+}
+
+// HGetAllContext ...
+func (p *Pool) HGetAllContext(ctx context.Context, key string, value interface{}) error {
+	conn, err := p.ConnContext(ctx)
+	if err != nil {
+		return err
+	}
 
 	defer conn.Close()
 
-	v, err := redis.Values(conn.Do("HGETALL", key))
+	v, err := redis.Values(do(ctx, conn, "HGETALL", key))
 	if err != nil {
 		return err
 	}
@@ -322,14 +468,19 @@ func (p *Pool) HGetAll(key string, value interface{}) error {
 
 // HGetAllStringMap ...
 func (p *Pool) HGetAllStringMap(key string) (map[string]string, error) {
-	var (
-		conn = p.Conn()
-		err  error
-	)
+	return p.HGetAllStringMapContext(context.Background(), key)
+}
+
+// HGetAllStringMapContext ...
+func (p *Pool) HGetAllStringMapContext(ctx context.Context, key string) (map[string]string, error) {
+	conn, err := p.ConnContext(ctx)
+	if err != nil {
+		return nil, err
+	}
 
 	defer conn.Close()
 
-	v, err := redis.StringMap(conn.Do("HGETALL", key))
+	v, err := redis.StringMap(do(ctx, conn, "HGETALL", key))
 	if err != nil {
 		return nil, err
 	}
@@ -338,16 +489,19 @@ func (p *Pool) HGetAllStringMap(key string) (map[string]string, error) {
 
 // Get ...
 func (p *Pool) Get(key string) (string, error) {
+	return p.GetContext(context.Background(), key)
+}
 
-	var (
-		conn  = p.Conn()
-		err   error
-		value string
-	)
+// GetContext ...
+func (p *Pool) GetContext(ctx context.Context, key string) (string, error) {
+	conn, err := p.ConnContext(ctx)
+	if err != nil {
+		return "", err
+	}
 
 	defer conn.Close()
 
-	value, err = redis.String(conn.Do("GET", key))
+	value, err := redis.String(do(ctx, conn, "GET", key))
 	if err != nil && err == redis.ErrNil {
 		return "", nil
 	}
@@ -362,35 +516,48 @@ func (p *Pool) Get(key string) (string, error) {
 // ScanHGets ...
 // Note: Use SCAN instead of KEYS, KEYS will block the server
 func (p *Pool) ScanHGets(key string, f func([]interface{}) error) error {
-	var (
-		conn   = p.Conn()
-		err    error
-		values []interface{}
-		keys   []string
-	)
+	return p.ScanHGetsContext(context.Background(), key, f)
+}
 
-	defer conn.Close()
+// ScanHGetsContext ...
+// Note: Use SCAN instead of KEYS, KEYS will block the server
+func (p *Pool) ScanHGetsContext(ctx context.Context, key string, f func([]interface{}) error) error {
+	var values []interface{}
 
-	iter := 0
-	for {
-		arr, err := redis.Values(conn.Do("SCAN", iter, "MATCH", key))
+	if p.cluster != nil {
+		keys, err := p.clusterScanKeys(ctx, key)
 		if err != nil {
 			return err
 		}
+		cmds := make([]clusterCommand, len(keys))
+		for i, key := range keys {
+			cmds[i] = clusterCommand{key: key, name: "HGETALL", args: []interface{}{key}}
+		}
+		if values, err = p.cluster.pipeline(ctx, cmds); err != nil {
+			return err
+		}
+		if f != nil {
+			return f(values)
+		}
+		return nil
+	}
 
-		iter, _ = redis.Int(arr[0], nil)
-		k, _ := redis.Strings(arr[1], nil)
-		keys = append(keys, k...)
+	conn, err := p.ConnContext(ctx)
+	if err != nil {
+		return err
+	}
 
-		if iter == 0 {
-			break
-		}
+	defer conn.Close()
+
+	keys, err := scanKeys(ctx, conn, key)
+	if err != nil {
+		return err
 	}
 	conn.Send("MULTI")
 	for _, key := range keys {
 		conn.Send("HGETALL", key)
 	}
-	values, err = redis.Values(conn.Do("EXEC"))
+	values, err = redis.Values(do(ctx, conn, "EXEC"))
 	if err != nil && err == redis.ErrNil {
 		return nil
 	}
@@ -409,34 +576,36 @@ func (p *Pool) ScanHGets(key string, f func([]interface{}) error) error {
 // ScanDels ...
 // Note: Use SCAN instead of KEYS, KEYS will block the server
 func (p *Pool) ScanDels(key string) error {
-	var (
-		conn = p.Conn()
-		err  error
-		keys []string
-	)
-
-	defer conn.Close()
+	return p.ScanDelsContext(context.Background(), key)
+}
 
-	iter := 0
-	for {
-		arr, err := redis.Values(conn.Do("SCAN", iter, "MATCH", key))
+// ScanDelsContext ...
+// Note: Use SCAN instead of KEYS, KEYS will block the server
+func (p *Pool) ScanDelsContext(ctx context.Context, key string) error {
+	if p.cluster != nil {
+		keys, err := p.clusterScanKeys(ctx, key)
 		if err != nil {
 			return err
 		}
+		return p.clusterDels(ctx, keys)
+	}
 
-		iter, _ = redis.Int(arr[0], nil)
-		k, _ := redis.Strings(arr[1], nil)
-		keys = append(keys, k...)
+	conn, err := p.ConnContext(ctx)
+	if err != nil {
+		return err
+	}
 
-		if iter == 0 {
-			break
-		}
+	defer conn.Close()
+
+	keys, err := scanKeys(ctx, conn, key)
+	if err != nil {
+		return err
 	}
 	conn.Send("MULTI")
 	for i := range keys {
 		conn.Send("DEL", keys[i])
 	}
-	_, err = redis.Values(conn.Do("EXEC"))
+	_, err = redis.Values(do(ctx, conn, "EXEC"))
 	if err != nil && err == redis.ErrNil {
 		return nil
 	}
@@ -445,23 +614,54 @@ func (p *Pool) ScanDels(key string) error {
 
 // Dels ...
 func (p *Pool) Dels(key ...interface{}) error {
-	var (
-		conn = p.Conn()
-		err  error
-	)
+	return p.DelsContext(context.Background(), key...)
+}
+
+// DelsContext ...
+func (p *Pool) DelsContext(ctx context.Context, key ...interface{}) error {
+	if p.cluster != nil && len(key) > 1 {
+		keys := make([]string, len(key))
+		for i := range key {
+			keys[i] = argString(key[i])
+		}
+		return p.clusterDels(ctx, keys)
+	}
+
+	conn, err := p.ConnContext(ctx)
+	if err != nil {
+		return err
+	}
 
 	defer conn.Close()
 
-	_, err = redis.Int(conn.Do("DEL", key...))
+	_, err = redis.Int(do(ctx, conn, "DEL", key...))
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// clusterDels deletes keys of any slot, one DEL per key.
+func (p *Pool) clusterDels(ctx context.Context, keys []string) error {
+	cmds := make([]clusterCommand, len(keys))
+	for i, key := range keys {
+		cmds[i] = clusterCommand{key: key, name: "DEL", args: []interface{}{key}}
+	}
+	_, err := p.cluster.pipeline(ctx, cmds)
+	return err
+}
+
 // Do ...
 func (p *Pool) Do(command string, args ...interface{}) (interface{}, error) {
-	conn := p.Conn()
+	return p.DoContext(context.Background(), command, args...)
+}
+
+// DoContext ...
+func (p *Pool) DoContext(ctx context.Context, command string, args ...interface{}) (interface{}, error) {
+	conn, err := p.ConnContext(ctx)
+	if err != nil {
+		return nil, err
+	}
 	defer conn.Close()
-	return conn.Do(command, args...)
+	return do(ctx, conn, command, args...)
 }
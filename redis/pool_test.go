@@ -0,0 +1,96 @@
+package redis
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gomodule/redigo/redis"
+)
+
+// newTestRedis returns an in-memory redis running the Lua scripts for
+// real, it is closed with the test.
+func newTestRedis(t *testing.T) *miniredis.Miniredis {
+	return miniredis.RunT(t)
+}
+
+// newPipePool returns a pool dialing conns to a peer which reads every
+// command and never replies.
+func newPipePool(maxActive int) *Pool {
+	return &Pool{
+		pool: &redis.Pool{
+			MaxActive: maxActive,
+			Wait:      true,
+			Dial: func() (redis.Conn, error) {
+				client, server := net.Pipe()
+				go func() {
+					buf := make([]byte, 1024)
+					for {
+						if _, err := server.Read(buf); err != nil {
+							return
+						}
+					}
+				}()
+				return redis.NewConn(client, time.Second, time.Second), nil
+			},
+		},
+		scripts: make(map[string]*poolScript),
+	}
+}
+
+func TestPoolContextDeadline(t *testing.T) {
+	p := newPipePool(0)
+	defer p.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// the deadline of ctx is also the read deadline, whichever fires first
+	start := time.Now()
+	_, err := p.GetContext(ctx, "key")
+	if ne, ok := err.(net.Error); err != context.DeadlineExceeded && !(ok && ne.Timeout()) {
+		t.Fatalf("err = %v, want a timeout", err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Fatalf("returned after %v, want the ctx deadline", d)
+	}
+}
+
+func TestPoolContextCancel(t *testing.T) {
+	p := newPipePool(0)
+	defer p.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	_, err := p.DoContext(ctx, "PING")
+	if err != context.Canceled {
+		t.Fatalf("err = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestPoolConnContextWait(t *testing.T) {
+	p := newPipePool(1)
+	defer p.Close()
+
+	conn := p.Conn()
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := p.SetContext(ctx, "key", "value"); err != context.DeadlineExceeded {
+		t.Fatalf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestPoolMissingScriptContext(t *testing.T) {
+	p := newPipePool(0)
+	defer p.Close()
+
+	if err := p.SendScriptContext(context.Background(), "missing", nil); err == nil {
+		t.Fatal("want an error for a missing script")
+	}
+}
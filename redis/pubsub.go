@@ -1,143 +1,499 @@
 package redis
 
 import (
-	"fmt"
+	"errors"
+	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gomodule/redigo/redis"
+	"github.com/hkjojo/go-toolkits/metric"
 )
 
 type CallBack func(string)
 
+// PatternCallBack is called with the channel and the data of the
+// messages matching a pattern.
+type PatternCallBack func(channel, data string)
+
+// Logger ...
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// PubSubOverflow decides what a PubSubClient does with a message when
+// its queue is full.
+type PubSubOverflow int
+
+const (
+	// OverflowBlock stops receiving until the queue has room, redis may
+	// close the conn once its output buffer limit is reached.
+	OverflowBlock PubSubOverflow = iota
+	// OverflowDropNewest drops the new message.
+	OverflowDropNewest
+	// OverflowDropOldest drops the oldest queued message.
+	OverflowDropOldest
+)
+
+var errPubSubClosed = errors.New("redis: pubsub client closed")
+
+type pubSubOptions struct {
+	bufferSize   int
+	overflow     PubSubOverflow
+	pingInterval time.Duration
+	readTimeout  time.Duration
+	minBackoff   time.Duration
+	maxBackoff   time.Duration
+	logger       Logger
+	events       metric.Counter
+}
+
+// PubSubOption ...
+type PubSubOption func(*pubSubOptions)
+
+// WithPubSubBuffer sets the max number of messages waiting for their
+// callbacks, default 4096.
+func WithPubSubBuffer(size int) PubSubOption {
+	return func(o *pubSubOptions) {
+		if size > 0 {
+			o.bufferSize = size
+		}
+	}
+}
+
+// WithPubSubOverflow sets the policy applied when the queue is full,
+// default OverflowBlock.
+func WithPubSubOverflow(policy PubSubOverflow) PubSubOption {
+	return func(o *pubSubOptions) {
+		o.overflow = policy
+	}
+}
+
+// WithPubSubPing sets the ping interval and how long to wait for any
+// message before reconnecting while subscribed, default 5s and 10s.
+func WithPubSubPing(interval, readTimeout time.Duration) PubSubOption {
+	return func(o *pubSubOptions) {
+		if interval > 0 {
+			o.pingInterval = interval
+		}
+		if readTimeout > 0 {
+			o.readTimeout = readTimeout
+		}
+	}
+}
+
+// WithPubSubBackoff sets the delays between reconnect attempts, they
+// double from min up to max, default 100ms and 30s.
+func WithPubSubBackoff(min, max time.Duration) PubSubOption {
+	return func(o *pubSubOptions) {
+		if min > 0 {
+			o.minBackoff = min
+		}
+		if max >= o.minBackoff {
+			o.maxBackoff = max
+		}
+	}
+}
+
+// WithPubSubLogger sets the logger of errors, default log.Default().
+func WithPubSubLogger(logger Logger) PubSubOption {
+	return func(o *pubSubOptions) {
+		o.logger = logger
+	}
+}
+
+// WithPubSubEvents counts dropped messages and reconnects with a single
+// label receiving "dropped" or "reconnect".
+func WithPubSubEvents(c metric.Counter) PubSubOption {
+	return func(o *pubSubOptions) {
+		o.events = c
+	}
+}
+
+// PubSubStats ...
+type PubSubStats struct {
+	Received   uint64
+	Dropped    uint64
+	Reconnects uint64
+}
+
 // PubSubClient represents the Redis Pub/Sub client structure.
 type PubSubClient struct {
-	pool *Pool
-	psc  redis.PubSubConn
-	ch   chan redis.Message
+	received   uint64 // accessed atomically, keep 64-bit aligned
+	dropped    uint64
+	reconnects uint64
 
-	subMu         sync.RWMutex
-	subscriptions map[string]CallBack
+	pool          *Pool
+	opts          pubSubOptions
 	reSubCallBack func()
-	startPing     sync.Once
+	ch            chan redis.Message
 	doneCh        chan struct{}
+	wg            sync.WaitGroup
+
+	mu       sync.Mutex // protects psc, closed and serializes writes to it
+	psc      redis.PubSubConn
+	closed   bool
+	subMu    sync.RWMutex // protects channels, patterns
+	channels map[string][]CallBack
+	patterns map[string][]PatternCallBack
 }
 
 // NewPubSubClient creates a new Pub/Sub client.
-func NewPubSubClient(pool *Pool, reSubCallBack func()) (*PubSubClient, error) {
+func NewPubSubClient(pool *Pool, reSubCallBack func(), opts ...PubSubOption) (*PubSubClient, error) {
+	o := pubSubOptions{
+		bufferSize:   4096,
+		overflow:     OverflowBlock,
+		pingInterval: 5 * time.Second,
+		readTimeout:  10 * time.Second,
+		minBackoff:   100 * time.Millisecond,
+		maxBackoff:   30 * time.Second,
+		logger:       log.Default(),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	client := &PubSubClient{
 		pool:          pool,
+		opts:          o,
+		reSubCallBack: reSubCallBack,
 		psc:           redis.PubSubConn{Conn: pool.Conn()},
-		ch:            make(chan redis.Message, 4096),
-		subscriptions: make(map[string]CallBack),
+		ch:            make(chan redis.Message, o.bufferSize),
 		doneCh:        make(chan struct{}),
-		reSubCallBack: reSubCallBack,
+		channels:      make(map[string][]CallBack),
+		patterns:      make(map[string][]PatternCallBack),
 	}
 
+	client.wg.Add(3)
 	go client.pushMessages()
 	go client.receiveMessages()
+	go client.loopPing()
 	return client, nil
 }
 
-// receiveMessages listens for incoming messages and broadcasts them to the channel.
+func (c *PubSubClient) conn() redis.PubSubConn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.psc
+}
+
+func (c *PubSubClient) isClosed() bool {
+	select {
+	case <-c.doneCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// receiveMessages listens for incoming messages and queues them, it
+// owns the reads of the conn so it closes it too.
 func (c *PubSubClient) receiveMessages() {
-	for {
-		switch msg := c.psc.ReceiveWithTimeout(10 * time.Second).(type) {
+	defer c.wg.Done()
+	defer func() { c.conn().Close() }()
+
+	for !c.isClosed() {
+		// outside subscribed mode nothing is pinged, so wait without a
+		// deadline until a subscription reply comes
+		timeout := c.opts.readTimeout
+		if !c.subscribed() {
+			timeout = 0
+		}
+		switch msg := c.conn().ReceiveWithTimeout(timeout).(type) {
 		case redis.Message:
+			atomic.AddUint64(&c.received, 1)
+			c.push(msg)
+		case error:
+			if c.isClosed() {
+				return
+			}
+			c.opts.logger.Printf("redis pubsub receive fail, error: %s\n", msg.Error())
+			if !c.reconnect() {
+				return
+			}
+		}
+	}
+}
+
+// push queues msg following the overflow policy.
+func (c *PubSubClient) push(msg redis.Message) {
+	switch c.opts.overflow {
+	case OverflowDropNewest:
+		select {
+		case c.ch <- msg:
+		default:
+			c.drop()
+		}
+	case OverflowDropOldest:
+		for {
 			select {
 			case c.ch <- msg:
+				return
 			default:
-				_, ok := <-c.ch
-				if !ok {
-					return
-				}
-				// TODO solution channel is full
 			}
-		case redis.Subscription:
-			fmt.Printf("Subscription message: %s: %s %d\n", msg.Channel, msg.Kind, msg.Count)
-		case error:
-			fmt.Printf("Error: %s", msg)
-			time.Sleep(5 * time.Second)
-			c.reSubscribe()
+			select {
+			case <-c.ch:
+				c.drop()
+			default:
+			}
+		}
+	default:
+		select {
+		case c.ch <- msg:
+		case <-c.doneCh:
 		}
 	}
 }
 
-// receiveMessages listens for incoming messages and broadcasts them to the channel.
+func (c *PubSubClient) drop() {
+	atomic.AddUint64(&c.dropped, 1)
+	if c.opts.events != nil {
+		c.opts.events.With("dropped").Inc()
+	}
+}
+
+// pushMessages calls the callbacks of the queued messages.
 func (c *PubSubClient) pushMessages() {
-	defer close(c.ch)
+	defer c.wg.Done()
+
 	for {
 		select {
-		case msg, ok := <-c.ch:
-			if !ok {
-				return
-			}
-
-			c.subMu.RLock()
-			cb, ok := c.subscriptions[msg.Channel]
-			c.subMu.RUnlock()
-			if ok {
-				cb(string(msg.Data))
-			}
+		case msg := <-c.ch:
+			c.dispatch(msg)
 		case <-c.doneCh:
 			return
 		}
 	}
 }
 
-// receiveMessages listens for incoming messages and broadcasts them to the channel.
+func (c *PubSubClient) dispatch(msg redis.Message) {
+	data := string(msg.Data)
+	if msg.Pattern != "" {
+		c.subMu.RLock()
+		cbs := c.patterns[msg.Pattern]
+		c.subMu.RUnlock()
+		for _, cb := range cbs {
+			cb(msg.Channel, data)
+		}
+		return
+	}
+
+	c.subMu.RLock()
+	cbs := c.channels[msg.Channel]
+	c.subMu.RUnlock()
+	for _, cb := range cbs {
+		cb(data)
+	}
+}
+
+// subscribed reports whether any channel or pattern is subscribed.
+func (c *PubSubClient) subscribed() bool {
+	c.subMu.RLock()
+	defer c.subMu.RUnlock()
+	return len(c.channels) != 0 || len(c.patterns) != 0
+}
+
+// loopPing pings the server so that a dead conn is noticed, only while
+// subscribed since redis answers a plain PONG the receive loop rejects
+// otherwise.
 func (c *PubSubClient) loopPing() {
-	c.startPing.Do(func() {
-		go func() {
-			ticker := time.NewTicker(5 * time.Second)
-			for range ticker.C {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.opts.pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			// holding mu orders the ping before any unsubscribe
+			c.mu.Lock()
+			if c.subscribed() {
 				c.psc.Ping("PING")
 			}
-		}()
-	})
+			c.mu.Unlock()
+		case <-c.doneCh:
+			return
+		}
+	}
 }
 
-func (c *PubSubClient) reSubscribe() {
-	c.psc.Close()
-	c.psc = redis.PubSubConn{Conn: c.pool.Conn()}
-	// re-subscribe to channels after an error occurs
+// reconnect replaces the conn and subscribes again to every channel and
+// pattern, retrying with backoff, it returns false once closed.
+func (c *PubSubClient) reconnect() bool {
+	backoff := c.opts.minBackoff
+	for {
+		timer := time.NewTimer(backoff)
+		select {
+		case <-c.doneCh:
+			timer.Stop()
+			return false
+		case <-timer.C:
+		}
 
-	c.subMu.RLock()
-	for channel := range c.subscriptions {
-		err := c.psc.Subscribe(channel)
-		if err != nil {
-			fmt.Printf("reSubscribe Error: %s", err)
+		err := c.resubscribe()
+		if err == errPubSubClosed {
+			return false
+		}
+		if err == nil {
+			atomic.AddUint64(&c.reconnects, 1)
+			if c.opts.events != nil {
+				c.opts.events.With("reconnect").Inc()
+			}
+			if c.reSubCallBack != nil {
+				c.reSubCallBack()
+			}
+			return true
+		}
+
+		c.opts.logger.Printf("redis pubsub reconnect fail, error: %s\n", err.Error())
+		if backoff *= 2; backoff > c.opts.maxBackoff {
+			backoff = c.opts.maxBackoff
 		}
 	}
-	c.subMu.RUnlock()
 }
 
-func (c *PubSubClient) subscribe(channel string, cb CallBack) error {
-	err := c.psc.Subscribe(channel)
+func (c *PubSubClient) resubscribe() error {
+	conn := c.pool.Conn()
+	if err := conn.Err(); err != nil {
+		conn.Close()
+		return err
+	}
+	psc := redis.PubSubConn{Conn: conn}
+
+	// holding mu keeps Subscribe from writing to the old conn meanwhile
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		psc.Close()
+		return errPubSubClosed
+	}
+
+	c.subMu.RLock()
+	channels := make([]interface{}, 0, len(c.channels))
+	for channel := range c.channels {
+		channels = append(channels, channel)
+	}
+	patterns := make([]interface{}, 0, len(c.patterns))
+	for pattern := range c.patterns {
+		patterns = append(patterns, pattern)
+	}
+	c.subMu.RUnlock()
+
+	var err error
+	if len(channels) != 0 {
+		err = psc.Subscribe(channels...)
+	}
+	if err == nil && len(patterns) != 0 {
+		err = psc.PSubscribe(patterns...)
+	}
 	if err != nil {
+		c.mu.Unlock()
+		psc.Close()
 		return err
 	}
+	old := c.psc
+	c.psc = psc
+	c.mu.Unlock()
+
+	old.Close()
+	return nil
+}
+
+// Subscribe adds cb to the callbacks of channel. When the subscription
+// cannot be written the error is returned but cb stays registered, the
+// client subscribes again once it reconnects.
+func (c *PubSubClient) Subscribe(channel string, cb CallBack) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return errPubSubClosed
+	}
 
 	c.subMu.Lock()
-	c.subscriptions[channel] = cb
+	c.channels[channel] = append(c.channels[channel], cb)
+	first := len(c.channels[channel]) == 1
 	c.subMu.Unlock()
-	c.loopPing()
-	return nil
+	if !first {
+		return nil
+	}
+	return c.psc.Subscribe(channel)
 }
 
-func (c *PubSubClient) unsubscribe(channel string) {
-	c.psc.Unsubscribe(channel)
+// Unsubscribe removes every callback of channel.
+func (c *PubSubClient) Unsubscribe(channel string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return errPubSubClosed
+	}
+
 	c.subMu.Lock()
-	defer c.subMu.Unlock()
-	delete(c.subscriptions, channel)
+	delete(c.channels, channel)
+	c.subMu.Unlock()
+	return c.psc.Unsubscribe(channel)
 }
 
-// Close closes the client connection.
+// PSubscribe adds cb to the callbacks of the channels matching pattern.
+// Like Subscribe, cb stays registered when the error is returned.
+func (c *PubSubClient) PSubscribe(pattern string, cb PatternCallBack) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return errPubSubClosed
+	}
+
+	c.subMu.Lock()
+	c.patterns[pattern] = append(c.patterns[pattern], cb)
+	first := len(c.patterns[pattern]) == 1
+	c.subMu.Unlock()
+	if !first {
+		return nil
+	}
+	return c.psc.PSubscribe(pattern)
+}
+
+// PUnsubscribe removes every callback of pattern.
+func (c *PubSubClient) PUnsubscribe(pattern string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return errPubSubClosed
+	}
+
+	c.subMu.Lock()
+	delete(c.patterns, pattern)
+	c.subMu.Unlock()
+	return c.psc.PUnsubscribe(pattern)
+}
+
+// Stats ...
+func (c *PubSubClient) Stats() PubSubStats {
+	return PubSubStats{
+		Received:   atomic.LoadUint64(&c.received),
+		Dropped:    atomic.LoadUint64(&c.dropped),
+		Reconnects: atomic.LoadUint64(&c.reconnects),
+	}
+}
+
+// Close unsubscribes from everything and waits for the goroutines of
+// the client to stop, the queued messages are dropped. Callbacks must
+// not call Close, nor Pool.Close, since it waits for them to return.
 func (c *PubSubClient) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
 	close(c.doneCh)
-	err := c.psc.Close()
-	if err != nil {
-		return err
+	// the replies wake up the receive loop which closes the conn
+	err := c.psc.Unsubscribe()
+	if err == nil {
+		err = c.psc.PUnsubscribe()
 	}
-	return nil
+	c.mu.Unlock()
+
+	c.wg.Wait()
+	return err
 }
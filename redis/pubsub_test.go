@@ -4,9 +4,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"strconv"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/alicebob/miniredis/v2"
 	"github.com/gomodule/redigo/redis"
 )
 
@@ -84,3 +87,159 @@ redis.call('PUBLISH', 'position_update1', cjson.encode(position))
 	UnSubscribe("position_update")
 	UnSubscribe("position_update_engine")
 }
+
+// subscribers returns the number of conns subscribed to channel.
+func subscribers(m *miniredis.Miniredis, channel string) int {
+	return m.PubSubNumSub(channel)[channel]
+}
+
+// kick closes every conn of m.
+func kick(t *testing.T, m *miniredis.Miniredis) {
+	m.Close()
+	if err := m.Restart(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestPubSubClient(t *testing.T) {
+	m := newTestRedis(t)
+	p := newTestPool(m.Addr())
+	defer p.Close()
+
+	var resubs int32
+	counter := newTestCounter(1)
+	client, err := NewPubSubClient(p, func() { atomic.AddInt32(&resubs, 1) },
+		WithPubSubBackoff(time.Millisecond, 10*time.Millisecond),
+		WithPubSubEvents(counter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	got := make(chan string, 16)
+	client.Subscribe("quotes", func(data string) { got <- "first " + data })
+	client.Subscribe("quotes", func(data string) { got <- "second " + data })
+	client.PSubscribe("orders.*", func(channel, data string) { got <- channel + " " + data })
+	waitFor(t, "subscriptions", func() bool {
+		return subscribers(m, "quotes") == 1 && m.PubSubNumPat() == 1
+	})
+
+	expect := func(want ...string) {
+		t.Helper()
+		for _, w := range want {
+			select {
+			case g := <-got:
+				if g != w {
+					t.Fatalf("got %q, want %q", g, w)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatalf("timeout waiting for %q", w)
+			}
+		}
+	}
+	m.Publish("quotes", "1")
+	expect("first 1", "second 1")
+	m.Publish("orders.eu", "2")
+	expect("orders.eu 2")
+
+	kick(t, m)
+	waitFor(t, "resubscription", func() bool {
+		return atomic.LoadInt32(&resubs) == 1 && subscribers(m, "quotes") == 1 && m.PubSubNumPat() == 1
+	})
+	m.Publish("orders.us", "3")
+	expect("orders.us 3")
+
+	client.Unsubscribe("quotes")
+	waitFor(t, "unsubscription", func() bool { return subscribers(m, "quotes") == 0 })
+	m.Publish("quotes", "4")
+	m.Publish("orders.us", "5")
+	expect("orders.us 5")
+
+	if stats := client.Stats(); stats.Received != 4 || stats.Reconnects != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	counter.mu.Lock()
+	defer counter.mu.Unlock()
+	if counter.counts["reconnect"] != 1 {
+		t.Fatalf("counts = %v, want a reconnect", counter.counts)
+	}
+}
+
+func TestPubSubClientIdle(t *testing.T) {
+	m := newTestRedis(t)
+	p := newTestPool(m.Addr())
+	defer p.Close()
+
+	var resubs int32
+	client, err := NewPubSubClient(p, func() { atomic.AddInt32(&resubs, 1) },
+		WithPubSubPing(5*time.Millisecond, 50*time.Millisecond),
+		WithPubSubBackoff(time.Millisecond, 10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// idle before any subscription
+	time.Sleep(150 * time.Millisecond)
+
+	// and after the last one is gone
+	client.Subscribe("quotes", func(string) {})
+	waitFor(t, "subscription", func() bool { return subscribers(m, "quotes") == 1 })
+	time.Sleep(150 * time.Millisecond)
+	client.Unsubscribe("quotes")
+	waitFor(t, "unsubscription", func() bool { return subscribers(m, "quotes") == 0 })
+	time.Sleep(150 * time.Millisecond)
+
+	if stats := client.Stats(); stats.Reconnects != 0 || atomic.LoadInt32(&resubs) != 0 {
+		t.Fatalf("stats = %+v, resubs = %d, want no reconnect", stats, resubs)
+	}
+}
+
+func TestPubSubOverflow(t *testing.T) {
+	m := newTestRedis(t)
+	p := newTestPool(m.Addr())
+	defer p.Close()
+
+	counter := newTestCounter(1)
+	client, err := NewPubSubClient(p, nil,
+		WithPubSubBuffer(1),
+		WithPubSubOverflow(OverflowDropNewest),
+		WithPubSubEvents(counter))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	release := make(chan struct{})
+	var handled int32
+	client.Subscribe("quotes", func(data string) {
+		<-release
+		atomic.AddInt32(&handled, 1)
+	})
+	waitFor(t, "subscription", func() bool { return subscribers(m, "quotes") == 1 })
+
+	// one message blocks the callback, one waits in the queue
+	for i := 0; i < 5; i++ {
+		m.Publish("quotes", strconv.Itoa(i))
+	}
+	waitFor(t, "drops", func() bool { return client.Stats().Dropped == 3 })
+	counter.mu.Lock()
+	dropped := counter.counts["dropped"]
+	counter.mu.Unlock()
+	if dropped != 3 {
+		t.Fatalf("dropped events = %v, want 3", dropped)
+	}
+	close(release)
+	waitFor(t, "callbacks", func() bool { return atomic.LoadInt32(&handled) == 2 })
+}
@@ -0,0 +1,256 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// RateAlgorithm ...
+type RateAlgorithm int
+
+const (
+	// SlidingWindow logs the requests of the last period in a sorted set,
+	// it is exact but stores an entry per request.
+	SlidingWindow RateAlgorithm = iota
+	// FixedWindow counts the requests of the current period, up to twice
+	// the rate may pass around the end of a period.
+	FixedWindow
+	// TokenBucket is the generic cell rate algorithm, a token bucket of
+	// Burst tokens refilled at Rate per Period stored as a single time.
+	TokenBucket
+)
+
+// scripts of the RateLimiter, they read the time of the server so that
+// the clocks of the clients do not matter
+const (
+	rateSlidingScript = "ratelimit_sliding_1"
+	rateFixedScript   = "ratelimit_fixed_1"
+	rateGCRAScript    = "ratelimit_gcra_1"
+)
+
+var rateScripts = map[string]string{
+	// ARGV: limit, window ms, n
+	rateSlidingScript: `
+if redis.replicate_commands then redis.replicate_commands() end
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local limit, window, n = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now - window)
+local count = redis.call("ZCARD", KEYS[1])
+if count + n > limit then
+	local retry = window
+	local oldest = redis.call("ZRANGE", KEYS[1], count + n - limit - 1, count + n - limit - 1, "WITHSCORES")
+	if n <= limit and oldest[2] then retry = tonumber(oldest[2]) + window - now end
+	return {0, limit - count, retry}
+end
+for i = 1, n do
+	redis.call("ZADD", KEYS[1], now, now .. ":" .. (count + i))
+end
+redis.call("PEXPIRE", KEYS[1], window)
+return {1, limit - count - n, 0}`,
+	// ARGV: limit, window ms, n
+	rateFixedScript: `
+local limit, window, n = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+local count = redis.call("INCRBY", KEYS[1], n)
+if count == n then redis.call("PEXPIRE", KEYS[1], window) end
+if count > limit then
+	redis.call("DECRBY", KEYS[1], n)
+	return {0, limit - count + n, redis.call("PTTL", KEYS[1])}
+end
+return {1, limit - count, 0}`,
+	// ARGV: burst, emission interval us, n
+	rateGCRAScript: `
+if redis.replicate_commands then redis.replicate_commands() end
+local t = redis.call("TIME")
+local now = tonumber(t[1]) * 1000000 + tonumber(t[2])
+local burst, interval, n = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+local tolerance = interval * burst
+local tat = tonumber(redis.call("GET", KEYS[1])) or now
+if tat < now then tat = now end
+local newTat = tat + interval * n
+if newTat - now > tolerance then
+	return {0, math.floor((tolerance - (tat - now)) / interval), math.ceil((newTat - now - tolerance) / 1000)}
+end
+redis.call("SET", KEYS[1], string.format("%d", newTat), "PX", math.ceil((newTat - now) / 1000))
+return {1, math.floor((tolerance - (newTat - now)) / interval), 0}`,
+}
+
+// RateLimit allows Rate requests per Period, TokenBucket allows bursts
+// of up to Burst requests, Rate by default.
+type RateLimit struct {
+	Rate   int
+	Period time.Duration
+	Burst  int
+}
+
+// PerSecond ...
+func PerSecond(rate int) RateLimit {
+	return RateLimit{Rate: rate, Period: time.Second}
+}
+
+// PerMinute ...
+func PerMinute(rate int) RateLimit {
+	return RateLimit{Rate: rate, Period: time.Minute}
+}
+
+// RateResult ...
+type RateResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter is how long to wait before the request may be allowed,
+	// zero when it is.
+	RetryAfter time.Duration
+}
+
+type rateLimiterOptions struct {
+	algorithm  RateAlgorithm
+	failClosed bool
+}
+
+// RateLimiterOption ...
+type RateLimiterOption func(*rateLimiterOptions)
+
+// WithRateAlgorithm sets the algorithm of the limiter, default
+// SlidingWindow.
+func WithRateAlgorithm(algorithm RateAlgorithm) RateLimiterOption {
+	return func(o *rateLimiterOptions) {
+		o.algorithm = algorithm
+	}
+}
+
+// WithRateFailClosed makes the middlewares reject the requests when redis
+// fails, they are let through by default.
+func WithRateFailClosed() RateLimiterOption {
+	return func(o *rateLimiterOptions) {
+		o.failClosed = true
+	}
+}
+
+// RateLimiter limits the rate of the requests of a key, such as a user
+// or an address, across processes.
+type RateLimiter struct {
+	pool  *Pool
+	name  string
+	limit RateLimit
+	opts  rateLimiterOptions
+}
+
+// NewRateLimiter ...
+func NewRateLimiter(pool *Pool, name string, limit RateLimit, opts ...RateLimiterOption) *RateLimiter {
+	var o rateLimiterOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if limit.Burst <= 0 {
+		limit.Burst = limit.Rate
+	}
+
+	for script, src := range rateScripts {
+		if pool.script(script) == nil {
+			pool.setScript(script, 1, src)
+		}
+	}
+	return &RateLimiter{pool: pool, name: name, limit: limit, opts: o}
+}
+
+// Allow reports whether a request of key may happen now.
+func (l *RateLimiter) Allow(ctx context.Context, key string) (*RateResult, error) {
+	return l.AllowN(ctx, key, 1)
+}
+
+// AllowN reports whether n requests of key may happen now, they are
+// counted only when allowed.
+func (l *RateLimiter) AllowN(ctx context.Context, key string, n int) (*RateResult, error) {
+	var (
+		script = rateSlidingScript
+		limit  = l.limit.Rate
+		args   = []interface{}{l.key(key), l.limit.Rate, l.limit.Period.Milliseconds(), n}
+	)
+	switch l.opts.algorithm {
+	case FixedWindow:
+		script = rateFixedScript
+	case TokenBucket:
+		script = rateGCRAScript
+		limit = l.limit.Burst
+		interval := int64(1)
+		if l.limit.Rate > 0 && l.limit.Period.Microseconds() > int64(l.limit.Rate) {
+			interval = l.limit.Period.Microseconds() / int64(l.limit.Rate)
+		}
+		args = []interface{}{l.key(key), l.limit.Burst, interval, n}
+	}
+
+	var values []int64
+	err := l.pool.SendScriptContext(ctx, script, func(reply interface{}, err error) error {
+		values, err = redis.Int64s(reply, err)
+		return err
+	}, args...)
+	if err != nil {
+		return nil, err
+	}
+	if len(values) != 3 {
+		return nil, fmt.Errorf("redis: unexpected rate limit reply %v", values)
+	}
+
+	res := &RateResult{
+		Allowed:    values[0] == 1,
+		Limit:      limit,
+		Remaining:  int(values[1]),
+		RetryAfter: time.Duration(values[2]) * time.Millisecond,
+	}
+	if res.Remaining < 0 {
+		res.Remaining = 0
+	}
+	return res, nil
+}
+
+// Reset forgets the requests of key.
+func (l *RateLimiter) Reset(ctx context.Context, key string) error {
+	_, err := l.pool.DoContext(ctx, "DEL", l.key(key))
+	return err
+}
+
+// FailClosed reports whether the requests are rejected when redis fails,
+// see WithRateFailClosed.
+func (l *RateLimiter) FailClosed() bool {
+	return l.opts.failClosed
+}
+
+func (l *RateLimiter) key(key string) string {
+	return "ratelimit:" + l.name + ":" + key
+}
+
+// Middleware limits the requests by the key returned by keyFunc, requests
+// beyond the limit are answered with 429 Too Many Requests. The
+// X-RateLimit-Limit, X-RateLimit-Remaining and Retry-After headers are
+// set.
+func (l *RateLimiter) Middleware(keyFunc func(*http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			res, err := l.Allow(r.Context(), keyFunc(r))
+			if err != nil {
+				if l.opts.failClosed {
+					http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			h := w.Header()
+			h.Set("X-RateLimit-Limit", strconv.Itoa(res.Limit))
+			h.Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			if !res.Allowed {
+				h.Set("Retry-After", strconv.FormatInt(int64((res.RetryAfter+time.Second-1)/time.Second), 10))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
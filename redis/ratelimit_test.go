@@ -0,0 +1,124 @@
+package redis
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	type step struct {
+		at        time.Duration // since the first request
+		n         int
+		allowed   bool
+		remaining int
+		retry     time.Duration
+	}
+	for _, tc := range []struct {
+		algorithm RateAlgorithm
+		steps     []step
+	}{
+		{SlidingWindow, []step{
+			{0, 1, true, 2, 0},
+			{200 * time.Millisecond, 1, true, 1, 0},
+			{400 * time.Millisecond, 1, true, 0, 0},
+			// until the first request leaves the window
+			{500 * time.Millisecond, 1, false, 0, 500 * time.Millisecond},
+			// until the second one does
+			{500 * time.Millisecond, 2, false, 0, 700 * time.Millisecond},
+			// never, beyond the limit
+			{500 * time.Millisecond, 4, false, 0, time.Second},
+			{time.Second, 1, true, 0, 0},
+		}},
+		{FixedWindow, []step{
+			{0, 1, true, 2, 0},
+			{0, 1, true, 1, 0},
+			// until the window expires
+			{400 * time.Millisecond, 2, false, 1, 600 * time.Millisecond},
+			// the denied requests were not counted
+			{400 * time.Millisecond, 1, true, 0, 0},
+			{600 * time.Millisecond, 1, false, 0, 400 * time.Millisecond},
+			{time.Second, 2, true, 1, 0},
+		}},
+		{TokenBucket, []step{
+			{0, 1, true, 2, 0},
+			{0, 1, true, 1, 0},
+			{0, 1, true, 0, 0},
+			// a token every 333333us
+			{0, 1, false, 0, 334 * time.Millisecond},
+			{334 * time.Millisecond, 1, true, 0, 0},
+			{334 * time.Millisecond, 1, false, 0, 333 * time.Millisecond},
+			{time.Second, 2, true, 0, 0},
+		}},
+	} {
+		m := newTestRedis(t)
+		now := time.Unix(1700000000, 0)
+		m.SetTime(now)
+		p := newTestPool(m.Addr())
+		ctx := context.Background()
+
+		limiter := NewRateLimiter(p, "login", PerSecond(3), WithRateAlgorithm(tc.algorithm))
+		var elapsed time.Duration
+		for i, s := range tc.steps {
+			if d := s.at - elapsed; d > 0 {
+				elapsed = s.at
+				m.SetTime(now.Add(elapsed))
+				m.FastForward(d)
+			}
+			res, err := limiter.AllowN(ctx, "alice", s.n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := RateResult{Allowed: s.allowed, Limit: 3, Remaining: s.remaining, RetryAfter: s.retry}
+			if *res != want {
+				t.Fatalf("%v step %d: AllowN = %+v, want %+v", tc.algorithm, i, *res, want)
+			}
+		}
+
+		if err := limiter.Reset(ctx, "alice"); err != nil {
+			t.Fatal(err)
+		}
+		if m.Exists("ratelimit:login:alice") {
+			t.Fatalf("%v: key left after Reset", tc.algorithm)
+		}
+		if res, err := limiter.Allow(ctx, "alice"); err != nil || !res.Allowed || res.Remaining != 2 {
+			t.Fatalf("%v: Allow after Reset = %+v, %v", tc.algorithm, res, err)
+		}
+		p.Close()
+	}
+}
+
+func TestRateLimiterMiddleware(t *testing.T) {
+	m := newTestRedis(t)
+	p := newTestPool(m.Addr())
+	defer p.Close()
+
+	limiter := NewRateLimiter(p, "api", PerMinute(2))
+	handler := limiter.Middleware(func(r *http.Request) string {
+		return r.Header.Get("X-User")
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	call := func(user string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-User", user)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	for i := 0; i < 2; i++ {
+		if w := call("alice"); w.Code != http.StatusNoContent {
+			t.Fatalf("status = %d, want %d", w.Code, http.StatusNoContent)
+		}
+	}
+	w := call("alice")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "60" || w.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Fatalf("status = %d, headers = %v", w.Code, w.Header())
+	}
+	if w := call("bob"); w.Code != http.StatusNoContent {
+		t.Fatalf("status = %d for another key, want %d", w.Code, http.StatusNoContent)
+	}
+}
@@ -1,6 +1,7 @@
 package redis
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
@@ -34,6 +35,7 @@ type Config struct {
 	MasterAddr     string
 	Script         string
 	Sentinels      []string
+	Cluster        []string
 	ReadOnly       bool
 	Debug          bool
 	MaxActive      int
@@ -84,6 +86,8 @@ func Default() *Pool {
 func Init(conf *Config, opts ...Option) error {
 	conf.merge(defaultConfig)
 	switch {
+	case len(conf.Cluster) != 0:
+		defaultPool = NewCluster(conf)
 	case len(conf.Sentinels) != 0:
 		defaultPool = NewSentinel(conf)
 	case len(conf.MasterAddr) != 0:
@@ -105,10 +109,19 @@ func Init(conf *Config, opts ...Option) error {
 		if err != nil {
 			return err
 		}
+		if defaultPool.scriptReload > 0 {
+			defaultPool.watchScripts(conf.Script, defaultPool.scriptReload)
+		}
+	}
+
+	if defaultPool.scriptFS != nil {
+		if err := defaultPool.LoadScripts(defaultPool.scriptFS); err != nil {
+			return err
+		}
 	}
 
 	if defaultPool.startPubSub {
-		client, err := NewPubSubClient(defaultPool, defaultPool.reSubCallBack)
+		client, err := NewPubSubClient(defaultPool, defaultPool.reSubCallBack, defaultPool.pubSubOpts...)
 		if err != nil {
 			return err
 		}
@@ -163,7 +176,7 @@ func New(conf *Config) *Pool {
 				return conn, nil
 			},
 		},
-		scripts: make(map[string]*redis.Script),
+		scripts: make(map[string]*poolScript),
 	}
 }
 
@@ -262,7 +275,7 @@ func NewSentinel(conf *Config) *Pool {
 				return nil
 			},
 		},
-		scripts: make(map[string]*redis.Script),
+		scripts: make(map[string]*poolScript),
 	}
 }
 
@@ -271,82 +284,172 @@ func SendScript(script string, f ReplyFunc, args ...interface{}) error {
 	return defaultPool.SendScript(script, f, args...)
 }
 
+// SendScriptContext ...
+func SendScriptContext(ctx context.Context, script string, f ReplyFunc, args ...interface{}) error {
+	return defaultPool.SendScriptContext(ctx, script, f, args...)
+}
+
+// RegisterScript ...
+func RegisterScript(name string, script Script) error {
+	return defaultPool.RegisterScript(name, script)
+}
+
+// ScriptStats ...
+func ScriptStats() []ScriptStat {
+	return defaultPool.ScriptStats()
+}
+
 // BulkScript ...
 func BulkScript(script string, args [][]interface{}) error {
 	return defaultPool.BulkScript(script, args)
 }
 
+// BulkScriptContext ...
+func BulkScriptContext(ctx context.Context, script string, args [][]interface{}) error {
+	return defaultPool.BulkScriptContext(ctx, script, args)
+}
+
 // Set ...
 func Set(key, value string) error {
 	return defaultPool.Set(key, value)
 }
 
+// SetContext ...
+func SetContext(ctx context.Context, key, value string) error {
+	return defaultPool.SetContext(ctx, key, value)
+}
+
 // GetSet ...
 func GetSet(key, value string) (string, error) {
 	return defaultPool.GetSet(key, value)
 
 }
 
+// GetSetContext ...
+func GetSetContext(ctx context.Context, key, value string) (string, error) {
+	return defaultPool.GetSetContext(ctx, key, value)
+}
+
 // SetNX ...
 func SetNX(key, value string) (int, error) {
 	return defaultPool.SetNX(key, value)
 }
 
+// SetNXContext ...
+func SetNXContext(ctx context.Context, key, value string) (int, error) {
+	return defaultPool.SetNXContext(ctx, key, value)
+}
+
 // SetEX ...
 func SetEX(key, value string, seconds int) (int, error) {
 	return defaultPool.SetEX(key, value, seconds)
 }
 
+// SetEXContext ...
+func SetEXContext(ctx context.Context, key, value string, seconds int) (int, error) {
+	return defaultPool.SetEXContext(ctx, key, value, seconds)
+}
+
 // HSet ...
 func HSet(key string, field, value string) error {
 	return defaultPool.HSet(key, field, value)
 }
 
+// HSetContext ...
+func HSetContext(ctx context.Context, key string, field, value string) error {
+	return defaultPool.HSetContext(ctx, key, field, value)
+}
+
 // HIncrBy ...
 func HIncrBy(key string, field string, value int) (int, error) {
 	return defaultPool.HIncrBy(key, field, value)
 }
 
+// HIncrByContext ...
+func HIncrByContext(ctx context.Context, key string, field string, value int) (int, error) {
+	return defaultPool.HIncrByContext(ctx, key, field, value)
+}
+
 // HMSet ...
 func HMSet(key string, value interface{}) error {
 	return defaultPool.HMSet(key, value)
 }
 
+// HMSetContext ...
+func HMSetContext(ctx context.Context, key string, value interface{}) error {
+	return defaultPool.HMSetContext(ctx, key, value)
+}
+
 // BulkHMSet ...
 func BulkHMSet(values map[string]interface{}) error {
 	return defaultPool.BulkHMSet(values)
 }
 
+// BulkHMSetContext ...
+func BulkHMSetContext(ctx context.Context, values map[string]interface{}) error {
+	return defaultPool.BulkHMSetContext(ctx, values)
+}
+
 // SAdd ...
 func SAdd(key string, member string) error {
 	return defaultPool.SAdd(key, member)
 }
 
+// SAddContext ...
+func SAddContext(ctx context.Context, key string, member string) error {
+	return defaultPool.SAddContext(ctx, key, member)
+}
+
 // SRem ...
 func SRem(key string, member string) error {
 	return defaultPool.SRem(key, member)
 }
 
+// SRemContext ...
+func SRemContext(ctx context.Context, key string, member string) error {
+	return defaultPool.SRemContext(ctx, key, member)
+}
+
 // Smembers ...
 func Smembers(key string) ([]string, error) {
 	return defaultPool.Smembers(key)
 }
 
+// SmembersContext ...
+func SmembersContext(ctx context.Context, key string) ([]string, error) {
+	return defaultPool.SmembersContext(ctx, key)
+}
+
 // HGet ...
 func HGet(key string, field string) (string, error) {
 	return defaultPool.HGet(key, field)
 }
 
+// HGetContext ...
+func HGetContext(ctx context.Context, key string, field string) (string, error) {
+	return defaultPool.HGetContext(ctx, key, field)
+}
+
 // HGetAll ...
 func HGetAll(key string, value interface{}) error {
 	return defaultPool.HGetAll(key, value)
 }
 
+// HGetAllContext ...
+func HGetAllContext(ctx context.Context, key string, value interface{}) error {
+	return defaultPool.HGetAllContext(ctx, key, value)
+}
+
 // Get ...
 func Get(key string) (string, error) {
 	return defaultPool.Get(key)
 }
 
+// GetContext ...
+func GetContext(ctx context.Context, key string) (string, error) {
+	return defaultPool.GetContext(ctx, key)
+}
+
 // ScanHGets ...
 // Note: Use SCAN instead of KEYS, KEYS will block the server
 func ScanHGets(key string, f func([]interface{}) error) error {
@@ -354,23 +457,46 @@ func ScanHGets(key string, f func([]interface{}) error) error {
 
 }
 
+// ScanHGetsContext ...
+// Note: Use SCAN instead of KEYS, KEYS will block the server
+func ScanHGetsContext(ctx context.Context, key string, f func([]interface{}) error) error {
+	return defaultPool.ScanHGetsContext(ctx, key, f)
+}
+
 // ScanDels ...
 // Note: Use SCAN instead of KEYS, KEYS will block the server
 func ScanDels(key string) error {
 	return defaultPool.ScanDels(key)
 }
 
+// ScanDelsContext ...
+// Note: Use SCAN instead of KEYS, KEYS will block the server
+func ScanDelsContext(ctx context.Context, key string) error {
+	return defaultPool.ScanDelsContext(ctx, key)
+}
+
 // Dels ...
 func Dels(key ...interface{}) error {
 	return defaultPool.Dels(key...)
 }
 
+// DelsContext ...
+func DelsContext(ctx context.Context, key ...interface{}) error {
+	return defaultPool.DelsContext(ctx, key...)
+}
+
 // Do ...
 func Do(command string, args ...interface{}) (interface{}, error) {
 	return defaultPool.Do(command, args...)
 }
 
+// DoContext ...
+func DoContext(ctx context.Context, command string, args ...interface{}) (interface{}, error) {
+	return defaultPool.DoContext(ctx, command, args...)
+}
+
 // UnderlyingPool ...
+// It is nil in cluster mode, which has a pool per node.
 func UnderlyingPool() *redis.Pool {
 	return defaultPool.pool
 }
@@ -495,12 +621,27 @@ func Subscribe(channel string, cb CallBack) error {
 		return fmt.Errorf("pubsub not start")
 	}
 
-	return defaultPool.pubSubClient.subscribe(channel, cb)
+	return defaultPool.pubSubClient.Subscribe(channel, cb)
 }
 
 func UnSubscribe(channel string) {
 	if defaultPool.startPubSub {
-		defaultPool.pubSubClient.unsubscribe(channel)
+		defaultPool.pubSubClient.Unsubscribe(channel)
 	}
+}
 
+// PSubscribe ...
+func PSubscribe(pattern string, cb PatternCallBack) error {
+	if !defaultPool.startPubSub {
+		return fmt.Errorf("pubsub not start")
+	}
+
+	return defaultPool.pubSubClient.PSubscribe(pattern, cb)
+}
+
+// PUnSubscribe ...
+func PUnSubscribe(pattern string) {
+	if defaultPool.startPubSub {
+		defaultPool.pubSubClient.PUnsubscribe(pattern)
+	}
 }
@@ -0,0 +1,381 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// poolScript is a script registered in a pool.
+type poolScript struct {
+	*redis.Script
+	src      string
+	keyCount int
+	stats    *scriptStats // kept when the script is reloaded
+}
+
+type scriptStats struct {
+	calls    uint64 // accessed atomically
+	errors   uint64
+	noScript uint64
+	nanos    uint64
+	maxNanos uint64
+	loadedAt int64
+}
+
+func (s *scriptStats) observe(elapsed time.Duration, err error) {
+	atomic.AddUint64(&s.calls, 1)
+	if err != nil {
+		atomic.AddUint64(&s.errors, 1)
+	}
+	nanos := uint64(elapsed)
+	atomic.AddUint64(&s.nanos, nanos)
+	for {
+		max := atomic.LoadUint64(&s.maxNanos)
+		if nanos <= max || atomic.CompareAndSwapUint64(&s.maxNanos, max, nanos) {
+			return
+		}
+	}
+}
+
+// ScriptStat reports the calls of a script, a BulkScript counts as one.
+type ScriptStat struct {
+	Name     string
+	SHA      string
+	KeyCount int
+	Calls    uint64
+	Errors   uint64
+	// NoScript counts the NOSCRIPT replies recovered from by loading the
+	// scripts again, such as after a failover.
+	NoScript   uint64
+	Latency    time.Duration // total of the calls
+	MaxLatency time.Duration
+	// LoadedAt is when the script was last registered.
+	LoadedAt time.Time
+}
+
+// ScriptStats returns the stats of the registered scripts by name.
+func (p *Pool) ScriptStats() []ScriptStat {
+	p.scriptsMu.RLock()
+	defer p.scriptsMu.RUnlock()
+
+	stats := make([]ScriptStat, 0, len(p.scripts))
+	for name, s := range p.scripts {
+		stats = append(stats, ScriptStat{
+			Name:       name,
+			SHA:        s.Hash(),
+			KeyCount:   s.keyCount,
+			Calls:      atomic.LoadUint64(&s.stats.calls),
+			Errors:     atomic.LoadUint64(&s.stats.errors),
+			NoScript:   atomic.LoadUint64(&s.stats.noScript),
+			Latency:    time.Duration(atomic.LoadUint64(&s.stats.nanos)),
+			MaxLatency: time.Duration(atomic.LoadUint64(&s.stats.maxNanos)),
+			LoadedAt:   time.Unix(0, atomic.LoadInt64(&s.stats.loadedAt)),
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+// script returns the script named name.
+func (p *Pool) script(name string) *poolScript {
+	p.scriptsMu.RLock()
+	defer p.scriptsMu.RUnlock()
+	return p.scripts[name]
+}
+
+// setScript registers a script without loading it, it is loaded on the
+// first NOSCRIPT reply.
+func (p *Pool) setScript(name string, keyCount int, src string) *poolScript {
+	p.scriptsMu.Lock()
+	defer p.scriptsMu.Unlock()
+
+	s := &poolScript{
+		Script:   redis.NewScript(keyCount, src),
+		src:      src,
+		keyCount: keyCount,
+		stats:    &scriptStats{},
+	}
+	if old := p.scripts[name]; old != nil {
+		s.stats = old.stats
+	}
+	atomic.StoreInt64(&s.stats.loadedAt, time.Now().UnixNano())
+	p.scripts[name] = s
+	return s
+}
+
+// RegisterScript loads script and registers it as name, replacing the
+// script of the same name. It is called with the scripts of Config.Script
+// and WithScriptFS by Init.
+func (p *Pool) RegisterScript(name string, script Script) error {
+	if err := p.load(redis.NewScript(script.KeyCount, script.Src)); err != nil {
+		return err
+	}
+	s := p.setScript(name, script.KeyCount, script.Src)
+	if p.scriptCallback != nil {
+		p.scriptCallback(name, s.Hash())
+	}
+	return nil
+}
+
+// LoadScripts registers the scripts of fsys, such as an embed.FS, named
+// after their file and taking as many keys as their suffix, "incr_1.lua"
+// is the script "incr_1" taking one key.
+func (p *Pool) LoadScripts(fsys fs.FS) error {
+	return fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		return p.loadScriptFile(fsys, path)
+	})
+}
+
+// loadScript registers the script file or the scripts of the directory
+// script.
+func (p *Pool) loadScript(script string) error {
+	info, err := os.Stat(script)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return p.loadScriptFile(os.DirFS(filepath.Dir(script)), filepath.Base(script))
+	}
+	return p.LoadScripts(os.DirFS(script))
+}
+
+// loadScriptFile registers the script of file, files not following the
+// naming convention are skipped.
+func (p *Pool) loadScriptFile(fsys fs.FS, file string) error {
+	name, keyCount, ok := scriptName(path.Base(file))
+	if !ok {
+		return nil
+	}
+	src, err := fs.ReadFile(fsys, file)
+	if err != nil {
+		return err
+	}
+	return p.RegisterScript(name, Script{Src: string(src), KeyCount: keyCount})
+}
+
+// scriptName parses the name and key count of a script file.
+func scriptName(file string) (string, int, bool) {
+	if !strings.HasSuffix(file, LuaFileSuffix) {
+		return "", 0, false
+	}
+	name := strings.TrimSuffix(file, LuaFileSuffix)
+	i := strings.LastIndexByte(name, '_')
+	if i < 0 {
+		return "", 0, false
+	}
+	keyCount, err := strconv.Atoi(name[i+1:])
+	if err != nil {
+		return "", 0, false
+	}
+	return name, keyCount, true
+}
+
+// load loads s on the server, or on every master of a cluster.
+func (p *Pool) load(s *redis.Script) error {
+	if p.cluster != nil {
+		return p.cluster.each(context.Background(), s.Load)
+	}
+
+	conn := p.Conn()
+	defer conn.Close()
+	return s.Load(conn)
+}
+
+// reloadScripts loads every registered script again, concurrent calls
+// share a single reload and wait for it until ctx is done.
+func (p *Pool) reloadScripts(ctx context.Context) error {
+	_, err := p.reloads.do(ctx, "", func() (struct{}, error) {
+		p.scriptsMu.RLock()
+		scripts := make([]*poolScript, 0, len(p.scripts))
+		for _, s := range p.scripts {
+			scripts = append(scripts, s)
+		}
+		p.scriptsMu.RUnlock()
+
+		for _, s := range scripts {
+			if err := p.load(s.Script); err != nil {
+				return struct{}{}, err
+			}
+		}
+		return struct{}{}, nil
+	})
+	return err
+}
+
+func isNoScript(err error) bool {
+	var e redis.Error
+	return errors.As(err, &e) && strings.HasPrefix(string(e), "NOSCRIPT ")
+}
+
+func (s *poolScript) args(spec string, args []interface{}) []interface{} {
+	return append([]interface{}{spec, s.keyCount}, args...)
+}
+
+// eval runs s with EVALSHA, on NOSCRIPT the scripts are loaded again
+// before retrying, then the source is sent with EVAL if that failed.
+func (p *Pool) eval(ctx context.Context, conn redis.Conn, s *poolScript, args []interface{}) (interface{}, error) {
+	start := time.Now()
+	reply, err := do(ctx, conn, "EVALSHA", s.args(s.Hash(), args)...)
+	if isNoScript(err) {
+		atomic.AddUint64(&s.stats.noScript, 1)
+		if p.reloadScripts(ctx) == nil {
+			reply, err = do(ctx, conn, "EVALSHA", s.args(s.Hash(), args)...)
+		}
+		if isNoScript(err) {
+			reply, err = do(ctx, conn, "EVAL", s.args(s.src, args)...)
+		}
+	}
+	s.stats.observe(time.Since(start), err)
+	return reply, err
+}
+
+// bulk runs s once per args in a pipeline, per node in cluster mode, and
+// returns the replies, error replies included.
+func (p *Pool) bulk(ctx context.Context, s *poolScript, args [][]interface{}) ([]interface{}, error) {
+	if p.cluster != nil {
+		// by the key-count convention the first key leads the args, a
+		// script without keys runs on any node
+		cmds := make([]clusterCommand, 0, len(args))
+		for _, arg := range args {
+			var key string
+			if len(arg) != 0 {
+				key = argString(arg[0])
+			}
+			cmds = append(cmds, clusterCommand{key: key, script: s.Script, args: arg})
+		}
+		return p.cluster.pipeline(ctx, cmds)
+	}
+
+	conn, err := p.ConnContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	for _, arg := range args {
+		s.SendHash(conn, arg...)
+	}
+	if err := conn.Flush(); err != nil {
+		return nil, err
+	}
+	var (
+		replies  = make([]interface{}, len(args))
+		firstErr error
+	)
+	for i := range args {
+		replies[i], err = receive(ctx, conn)
+		if e, ok := err.(redis.Error); ok {
+			replies[i] = e
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return replies, firstErr
+}
+
+// WithScriptFS registers the scripts of fsys at Init, see LoadScripts.
+func WithScriptFS(fsys fs.FS) Option {
+	return func(p *Pool) {
+		p.scriptFS = fsys
+	}
+}
+
+// WithScriptReload checks the files of Config.Script every interval and
+// registers again the changed ones.
+func WithScriptReload(interval time.Duration) Option {
+	return func(p *Pool) {
+		p.scriptReload = interval
+	}
+}
+
+// WithScriptLogger sets the logger of the errors of WithScriptReload,
+// default log.Default().
+func WithScriptLogger(logger Logger) Option {
+	return func(p *Pool) {
+		p.scriptLogger = logger
+	}
+}
+
+type scriptFile struct {
+	size    int64
+	modTime time.Time
+}
+
+// scriptFiles returns the script files of dir.
+func scriptFiles(dir string) (map[string]scriptFile, error) {
+	files := make(map[string]scriptFile)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if _, _, ok := scriptName(info.Name()); ok && !info.IsDir() {
+			files[path] = scriptFile{size: info.Size(), modTime: info.ModTime()}
+		}
+		return nil
+	})
+	return files, err
+}
+
+// watchScripts registers again the scripts of dir changed since the last
+// check, every interval until the pool is closed.
+func (p *Pool) watchScripts(dir string, interval time.Duration) {
+	stop := make(chan struct{})
+	p.stopWatch = stop
+
+	logger := p.scriptLogger
+	if logger == nil {
+		logger = log.Default()
+	}
+	files, err := scriptFiles(dir)
+	if err != nil {
+		logger.Printf("redis scripts watch fail, error: %s\n", err.Error())
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			current, err := scriptFiles(dir)
+			if err != nil {
+				logger.Printf("redis scripts watch fail, error: %s\n", err.Error())
+				continue
+			}
+			for path, file := range current {
+				if old, ok := files[path]; ok && old.size == file.size && old.modTime.Equal(file.modTime) {
+					continue
+				}
+				if err := p.loadScript(path); err != nil {
+					logger.Printf("redis script %s reload fail, error: %s\n", path, err.Error())
+					// try again on the next check
+					delete(current, path)
+				}
+			}
+			files = current
+		}
+	}()
+}
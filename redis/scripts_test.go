@@ -0,0 +1,159 @@
+package redis
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// testLogger records the lines it is given.
+type testLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *testLogger) Printf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func (l *testLogger) logged(substr string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, line := range l.lines {
+		if strings.Contains(line, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestScriptNoScript(t *testing.T) {
+	sha := redis.NewScript(1, "return 1").Hash()
+	m := newTestRedis(t)
+	p := newTestPool(m.Addr())
+	defer p.Close()
+
+	// like a failover to a fresh replica
+	flush := func() {
+		t.Helper()
+		if _, err := p.Do("SCRIPT", "FLUSH"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	loadedAgain := func() {
+		t.Helper()
+		if exists, err := redis.Ints(p.Do("SCRIPT", "EXISTS", sha)); err != nil || exists[0] != 1 {
+			t.Fatalf("script not loaded again, %v %v", exists, err)
+		}
+	}
+
+	var loaded []string
+	p.scriptCallback = func(name, sha string) {
+		loaded = append(loaded, name+":"+sha)
+	}
+	fsys := fstest.MapFS{
+		"lua/incr_1.lua": {Data: []byte("return 1")},
+		"lua/README.md":  {Data: []byte("skipped")},
+	}
+	if err := p.LoadScripts(fsys); err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded) != 1 || loaded[0] != "incr_1:"+sha {
+		t.Fatalf("callback got %v", loaded)
+	}
+
+	if err := p.SendScript("incr_1", nil, "a"); err != nil {
+		t.Fatal(err)
+	}
+	flush()
+	if err := p.SendScript("incr_1", nil, "a"); err != nil {
+		t.Fatal(err)
+	}
+	loadedAgain()
+	flush()
+	if err := p.BulkScript("incr_1", [][]interface{}{{"a"}, {"b"}}); err != nil {
+		t.Fatal(err)
+	}
+	loadedAgain()
+
+	stats := p.ScriptStats()
+	if len(stats) != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	s := stats[0]
+	if s.Name != "incr_1" || s.SHA != sha || s.KeyCount != 1 || s.Calls != 3 || s.Errors != 0 || s.NoScript != 2 {
+		t.Fatalf("stats = %+v", s)
+	}
+	if s.Latency <= 0 || s.MaxLatency <= 0 || s.MaxLatency > s.Latency {
+		t.Fatalf("latency = %s, max %s", s.Latency, s.MaxLatency)
+	}
+}
+
+func TestScriptReload(t *testing.T) {
+	m := newTestRedis(t)
+	p := newTestPool(m.Addr())
+	defer p.Close()
+
+	var (
+		mu     sync.Mutex
+		loaded []string
+	)
+	p.scriptCallback = func(name, sha string) {
+		mu.Lock()
+		defer mu.Unlock()
+		loaded = append(loaded, sha)
+	}
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "incr_1.lua")
+	if err := os.WriteFile(file, []byte("return 1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.loadScript(dir); err != nil {
+		t.Fatal(err)
+	}
+	logger := &testLogger{}
+	WithScriptLogger(logger)(p)
+	p.watchScripts(dir, 10*time.Millisecond)
+
+	if err := os.WriteFile(file, []byte("return 2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// the mod time may not change within the resolution of the fs
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	want := redis.NewScript(1, "return 2").Hash()
+	deadline := time.Now().Add(2 * time.Second)
+	for p.script("incr_1").Hash() != want {
+		if time.Now().After(deadline) {
+			t.Fatal("script not reloaded")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := p.SendScript("incr_1", nil, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	if len(loaded) != 2 || loaded[1] != want {
+		t.Fatalf("callback got %v", loaded)
+	}
+	mu.Unlock()
+
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "watch error", func() bool { return logger.logged("redis scripts watch fail") })
+}
@@ -0,0 +1,437 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	defaultStreamBlock         = 2 * time.Second
+	defaultStreamBatch         = 10
+	defaultStreamClaimIdle     = time.Minute
+	defaultStreamClaimInterval = 30 * time.Second
+	defaultStreamRetry         = time.Second
+)
+
+// XAdd appends values, a map or a struct flattened as by HMSet, to
+// stream and returns the ID of the entry.
+func (p *Pool) XAdd(stream string, values interface{}) (string, error) {
+	return p.XAddContext(context.Background(), stream, values)
+}
+
+// XAddContext ...
+func (p *Pool) XAddContext(ctx context.Context, stream string, values interface{}) (string, error) {
+	return p.XAddMaxLenContext(ctx, stream, 0, values)
+}
+
+// XAddMaxLen is like XAdd and trims the stream to about maxLen entries,
+// zero keeps every entry.
+func (p *Pool) XAddMaxLen(stream string, maxLen int64, values interface{}) (string, error) {
+	return p.XAddMaxLenContext(context.Background(), stream, maxLen, values)
+}
+
+// XAddMaxLenContext ...
+func (p *Pool) XAddMaxLenContext(ctx context.Context, stream string, maxLen int64, values interface{}) (string, error) {
+	args := redis.Args{}.Add(stream)
+	if maxLen > 0 {
+		args = args.Add("MAXLEN", "~", maxLen)
+	}
+	args = args.Add("*").AddFlat(values)
+	return redis.String(p.DoContext(ctx, "XADD", args...))
+}
+
+// StreamMessage is an entry of a stream read by a StreamConsumer.
+type StreamMessage struct {
+	Stream     string
+	ID         string
+	Values     map[string]string
+	Deliveries int64 // times the entry was delivered to the group
+}
+
+// StreamHandler handles the messages of a StreamConsumer, a message is
+// acknowledged when Handle returns nil and delivered again after the
+// claim idle time otherwise.
+type StreamHandler interface {
+	Handle(ctx context.Context, msg *StreamMessage) error
+}
+
+// StreamHandlerFunc ...
+type StreamHandlerFunc func(ctx context.Context, msg *StreamMessage) error
+
+// Handle ...
+func (f StreamHandlerFunc) Handle(ctx context.Context, msg *StreamMessage) error {
+	return f(ctx, msg)
+}
+
+type streamOptions struct {
+	block         time.Duration
+	batch         int
+	claimIdle     time.Duration
+	claimInterval time.Duration
+	maxDeliveries int64
+	deadLetter    string
+	startID       string
+	logger        Logger
+}
+
+// StreamOption ...
+type StreamOption func(*streamOptions)
+
+// WithStreamBlock sets how long a read waits for new entries, default
+// 2s. It must be below the read timeout of the pool.
+func WithStreamBlock(block time.Duration) StreamOption {
+	return func(o *streamOptions) {
+		if block > 0 {
+			o.block = block
+		}
+	}
+}
+
+// WithStreamBatch sets the max number of entries per read, default 10.
+func WithStreamBatch(n int) StreamOption {
+	return func(o *streamOptions) {
+		if n > 0 {
+			o.batch = n
+		}
+	}
+}
+
+// WithStreamClaim sets how long an entry stays pending before it is
+// reclaimed with XAUTOCLAIM and how often pending entries are looked
+// for, default 1 minute and 30s.
+func WithStreamClaim(idle, interval time.Duration) StreamOption {
+	return func(o *streamOptions) {
+		if idle > 0 {
+			o.claimIdle = idle
+		}
+		if interval > 0 {
+			o.claimInterval = interval
+		}
+	}
+}
+
+// WithMaxDeliveries moves reclaimed entries delivered more than n times
+// to the deadLetter stream, with their stream and ID in the "stream" and
+// "id" fields, and acknowledges them. An empty deadLetter drops them.
+func WithMaxDeliveries(n int64, deadLetter string) StreamOption {
+	return func(o *streamOptions) {
+		o.maxDeliveries = n
+		o.deadLetter = deadLetter
+	}
+}
+
+// WithStreamStartID sets the ID the group starts from when it is
+// created, default "$", only new entries.
+func WithStreamStartID(id string) StreamOption {
+	return func(o *streamOptions) {
+		o.startID = id
+	}
+}
+
+// WithStreamLogger sets the logger of errors, default log.Default().
+func WithStreamLogger(logger Logger) StreamOption {
+	return func(o *streamOptions) {
+		o.logger = logger
+	}
+}
+
+// StreamConsumer reads streams as a member of a consumer group.
+// In cluster mode the streams must share a hash tag.
+type StreamConsumer struct {
+	pool     *Pool
+	streams  []string
+	group    string
+	consumer string
+	opts     streamOptions
+
+	ctx    context.Context // given to the handler
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewStreamConsumer creates group on streams, and the streams, unless
+// they exist.
+func NewStreamConsumer(pool *Pool, streams []string, group, consumer string, opts ...StreamOption) (*StreamConsumer, error) {
+	return NewStreamConsumerContext(context.Background(), pool, streams, group, consumer, opts...)
+}
+
+// NewStreamConsumerContext ...
+func NewStreamConsumerContext(ctx context.Context, pool *Pool, streams []string, group, consumer string, opts ...StreamOption) (*StreamConsumer, error) {
+	o := streamOptions{
+		block:         defaultStreamBlock,
+		batch:         defaultStreamBatch,
+		claimIdle:     defaultStreamClaimIdle,
+		claimInterval: defaultStreamClaimInterval,
+		startID:       "$",
+		logger:        log.Default(),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	for _, stream := range streams {
+		_, err := pool.DoContext(ctx, "XGROUP", "CREATE", stream, group, o.startID, "MKSTREAM")
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return nil, err
+		}
+	}
+
+	return &StreamConsumer{
+		pool:     pool,
+		streams:  streams,
+		group:    group,
+		consumer: consumer,
+		opts:     o,
+	}, nil
+}
+
+// Run consumes the streams with handler until Close.
+func (c *StreamConsumer) Run(handler StreamHandler) {
+	c.RunContext(context.Background(), handler)
+}
+
+// RunContext consumes the streams with handler until Close or ctx is
+// done. The handler is given ctx, it is not canceled by Close so that
+// the messages read are handled.
+func (c *StreamConsumer) RunContext(ctx context.Context, handler StreamHandler) {
+	if c.cancel != nil {
+		return
+	}
+
+	loopCtx, cancel := context.WithCancel(ctx)
+	c.ctx = ctx
+	c.cancel = cancel
+	c.done = make(chan struct{})
+
+	go c.loop(loopCtx, handler)
+}
+
+// Close stops reading and returns once the messages read are handled.
+func (c *StreamConsumer) Close() {
+	if c.cancel != nil {
+		c.cancel()
+		<-c.done
+		c.cancel = nil
+	}
+}
+
+func (c *StreamConsumer) loop(ctx context.Context, handler StreamHandler) {
+	defer close(c.done)
+
+	var lastClaim time.Time
+	for ctx.Err() == nil {
+		if time.Since(lastClaim) >= c.opts.claimInterval {
+			lastClaim = time.Now()
+			for _, stream := range c.streams {
+				if err := c.claim(ctx, stream, handler); err != nil && ctx.Err() == nil {
+					c.opts.logger.Printf("redis stream claim fail, stream: %s, error: %s\n", stream, err.Error())
+				}
+			}
+		}
+
+		msgs, err := c.read(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			c.opts.logger.Printf("redis stream read fail, error: %s\n", err.Error())
+			select {
+			case <-ctx.Done():
+			case <-time.After(defaultStreamRetry):
+			}
+			continue
+		}
+
+		// messages read are handled even when closing, they would stay
+		// pending until reclaimed otherwise
+		for _, msg := range msgs {
+			c.handle(handler, msg)
+		}
+	}
+}
+
+func (c *StreamConsumer) read(ctx context.Context) ([]*StreamMessage, error) {
+	args := redis.Args{}.Add("GROUP", c.group, c.consumer,
+		"COUNT", c.opts.batch, "BLOCK", c.opts.block.Milliseconds(), "STREAMS").
+		AddFlat(c.streams)
+	for range c.streams {
+		args = args.Add(">")
+	}
+
+	streams, err := redis.Values(c.pool.DoContext(ctx, "XREADGROUP", args...))
+	if err == redis.ErrNil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var msgs []*StreamMessage
+	for _, s := range streams {
+		v, err := redis.Values(s, nil)
+		if err != nil || len(v) != 2 {
+			return nil, errors.New("redis: unexpected XREADGROUP reply")
+		}
+		stream, _ := redis.String(v[0], nil)
+		entries, err := redis.Values(v[1], nil)
+		if err != nil {
+			return nil, err
+		}
+		m, _, err := parseStreamEntries(stream, entries)
+		if err != nil {
+			return nil, err
+		}
+		for _, msg := range m {
+			msg.Deliveries = 1
+		}
+		msgs = append(msgs, m...)
+	}
+	return msgs, nil
+}
+
+// claim takes over the entries of stream pending for the claim idle time
+// and handles or dead-letters them.
+func (c *StreamConsumer) claim(ctx context.Context, stream string, handler StreamHandler) error {
+	start := "0-0"
+	for ctx.Err() == nil {
+		reply, err := redis.Values(c.pool.DoContext(ctx, "XAUTOCLAIM", stream, c.group, c.consumer,
+			c.opts.claimIdle.Milliseconds(), start, "COUNT", c.opts.batch))
+		if err != nil {
+			return err
+		}
+		if len(reply) < 2 {
+			return errors.New("redis: unexpected XAUTOCLAIM reply")
+		}
+		next, _ := redis.String(reply[0], nil)
+		entries, err := redis.Values(reply[1], nil)
+		if err != nil {
+			return err
+		}
+		msgs, deleted, err := parseStreamEntries(stream, entries)
+		if err != nil {
+			return err
+		}
+		for _, id := range deleted {
+			// trimmed while pending, before Redis 7 they stay pending
+			c.ack(&StreamMessage{Stream: stream, ID: id})
+		}
+
+		if len(msgs) != 0 {
+			deliveries, err := c.deliveries(ctx, stream, msgs)
+			if err != nil {
+				return err
+			}
+			for _, msg := range msgs {
+				n, ok := deliveries[msg.ID]
+				if !ok {
+					// acked since it was claimed
+					continue
+				}
+				msg.Deliveries = n
+				if c.opts.maxDeliveries > 0 && msg.Deliveries > c.opts.maxDeliveries {
+					c.deadLetter(msg)
+					continue
+				}
+				c.handle(handler, msg)
+			}
+		}
+
+		if next == "" || next == "0-0" {
+			return nil
+		}
+		start = next
+	}
+	return ctx.Err()
+}
+
+// deliveries returns the delivery counts of the claimed msgs still
+// pending. Each one is looked up by its id, a range would also hold the
+// entries pending for the consumer that were not idle long enough to be
+// claimed.
+func (c *StreamConsumer) deliveries(ctx context.Context, stream string, msgs []*StreamMessage) (map[string]int64, error) {
+	pl := c.pool.Pipeline()
+	cmds := make([]*Cmd, len(msgs))
+	for i, msg := range msgs {
+		cmds[i] = pl.Send("XPENDING", stream, c.group, msg.ID, msg.ID, 1, c.consumer)
+	}
+	if err := pl.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	deliveries := make(map[string]int64, len(msgs))
+	for _, cmd := range cmds {
+		pending, _ := cmd.Values()
+		if len(pending) == 0 {
+			continue
+		}
+		// id, consumer, idle, deliveries
+		v, err := redis.Values(pending[0], nil)
+		if err != nil || len(v) != 4 {
+			return nil, errors.New("redis: unexpected XPENDING reply")
+		}
+		id, _ := redis.String(v[0], nil)
+		deliveries[id], _ = redis.Int64(v[3], nil)
+	}
+	return deliveries, nil
+}
+
+func (c *StreamConsumer) handle(handler StreamHandler, msg *StreamMessage) {
+	if err := handler.Handle(c.ctx, msg); err != nil {
+		c.opts.logger.Printf("redis stream handle fail, stream: %s, id: %s, error: %s\n", msg.Stream, msg.ID, err.Error())
+		return
+	}
+	c.ack(msg)
+}
+
+func (c *StreamConsumer) deadLetter(msg *StreamMessage) {
+	if c.opts.deadLetter != "" {
+		values := make(map[string]string, len(msg.Values)+2)
+		for k, v := range msg.Values {
+			values[k] = v
+		}
+		values["stream"], values["id"] = msg.Stream, msg.ID
+		if _, err := c.pool.XAddContext(c.ctx, c.opts.deadLetter, values); err != nil {
+			c.opts.logger.Printf("redis stream dead letter fail, stream: %s, id: %s, error: %s\n", msg.Stream, msg.ID, err.Error())
+			return
+		}
+	}
+	c.ack(msg)
+}
+
+func (c *StreamConsumer) ack(msg *StreamMessage) {
+	if _, err := c.pool.DoContext(c.ctx, "XACK", msg.Stream, c.group, msg.ID); err != nil {
+		c.opts.logger.Printf("redis stream ack fail, stream: %s, id: %s, error: %s\n", msg.Stream, msg.ID, err.Error())
+	}
+}
+
+// parseStreamEntries parses [id, [field, value...]] entries, the IDs of
+// the deleted ones, without fields, are returned apart.
+func parseStreamEntries(stream string, entries []interface{}) (msgs []*StreamMessage, deleted []string, err error) {
+	msgs = make([]*StreamMessage, 0, len(entries))
+	for _, e := range entries {
+		v, err := redis.Values(e, nil)
+		if err != nil || len(v) != 2 {
+			return nil, nil, fmt.Errorf("redis: unexpected entry of stream %s", stream)
+		}
+		id, err := redis.String(v[0], nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		if v[1] == nil {
+			deleted = append(deleted, id)
+			continue
+		}
+		values, err := redis.StringMap(v[1], nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		msgs = append(msgs, &StreamMessage{Stream: stream, ID: id, Values: values})
+	}
+	return msgs, deleted, nil
+}
@@ -0,0 +1,133 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+func TestStreamConsumer(t *testing.T) {
+	m := newTestRedis(t)
+	p := newTestPool(m.Addr())
+	defer p.Close()
+
+	logger := &testLogger{}
+	consumer, err := NewStreamConsumer(p, []string{"jobs"}, "workers", "w1",
+		WithStreamBlock(50*time.Millisecond),
+		WithStreamClaim(10*time.Millisecond, 20*time.Millisecond),
+		WithMaxDeliveries(2, "jobs-dead"),
+		WithStreamLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"a", "b", "c"} {
+		if _, err := p.XAdd("jobs", map[string]string{"name": name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type ctxKey struct{}
+	var (
+		mu      sync.Mutex
+		handled = make(map[string][]int64)
+		ctx     = context.WithValue(context.Background(), ctxKey{}, "run")
+	)
+	consumer.RunContext(ctx, StreamHandlerFunc(func(ctx context.Context, msg *StreamMessage) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if ctx.Value(ctxKey{}) != "run" {
+			t.Error("handler not given the ctx of RunContext")
+		}
+		name := msg.Values["name"]
+		handled[name] = append(handled[name], msg.Deliveries)
+		if name == "b" {
+			return errors.New("failed")
+		}
+		return nil
+	}))
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !m.Exists("jobs-dead") && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	consumer.Close()
+
+	dead, _ := m.Stream("jobs-dead")
+	if len(dead) != 1 {
+		t.Fatalf("dead letters = %v, want one", dead)
+	}
+	if !logger.logged("redis stream handle fail, stream: jobs") {
+		t.Fatalf("handler error not logged, got %v", logger.lines)
+	}
+	fields := strings.Join(dead[0].Values, ",")
+	if !strings.Contains(fields, "name,b") || !strings.Contains(fields, "stream,jobs") {
+		t.Fatalf("dead letter fields = %s", fields)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(handled["a"]) != 1 || len(handled["c"]) != 1 {
+		t.Fatalf("handled = %v, want a and c once", handled)
+	}
+	if got := fmt.Sprint(handled["b"]); got != "[1 2]" {
+		t.Fatalf("b delivered %s, want [1 2]", got)
+	}
+	summary, err := redis.Values(p.Do("XPENDING", "jobs", "workers"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := redis.Int(summary[0], nil); n != 0 {
+		t.Fatalf("%d entries still pending", n)
+	}
+}
+
+func TestStreamConsumerDeliveries(t *testing.T) {
+	m := newTestRedis(t)
+	p := newTestPool(m.Addr())
+	defer p.Close()
+
+	consumer, err := NewStreamConsumerContext(context.Background(), p, []string{"jobs"}, "workers", "w1",
+		WithStreamStartID("0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for i := 0; i < 4; i++ {
+		id, err := p.XAdd("jobs", map[string]int{"n": i})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+	if _, err := p.Do("XREADGROUP", "GROUP", "workers", "w1", "STREAMS", "jobs", ">"); err != nil {
+		t.Fatal(err)
+	}
+	// the second entry failed recently and is pending but was not claimed,
+	// the last one was acked since it was claimed
+	for id, retries := range map[string]int{ids[0]: 3, ids[2]: 2} {
+		if _, err := p.Do("XCLAIM", "jobs", "workers", "w1", 0, id, "RETRYCOUNT", retries); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := p.Do("XACK", "jobs", "workers", ids[3]); err != nil {
+		t.Fatal(err)
+	}
+
+	claimed := []*StreamMessage{{Stream: "jobs", ID: ids[0]}, {Stream: "jobs", ID: ids[2]}, {Stream: "jobs", ID: ids[3]}}
+	deliveries, err := consumer.deliveries(context.Background(), "jobs", claimed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deliveries[ids[0]] != 3 || deliveries[ids[2]] != 2 || len(deliveries) != 2 {
+		t.Fatalf("deliveries = %v, want %s:3 %s:2", deliveries, ids[0], ids[2])
+	}
+}
@@ -0,0 +1,38 @@
+// Package wsrpclimit limits the calls of a wsrpc server with a
+// redis.RateLimiter.
+package wsrpclimit
+
+import (
+	"context"
+
+	"github.com/hkjojo/go-toolkits/redis"
+	"github.com/hkjojo/go-toolkits/wsrpc"
+)
+
+// WrapHandler limits the calls by the key returned by keyFunc, calls
+// beyond the limit are answered with wsrpc.CodeRateLimited and the retry
+// after in milliseconds as data. Calls are let through when redis fails
+// unless the limiter is fail closed, then they are answered with
+// wsrpc.CodeServerBusy.
+func WrapHandler(l *redis.RateLimiter, keyFunc func(*wsrpc.Conn, *wsrpc.Args) string) wsrpc.WrapHandler {
+	return func(next wsrpc.ServiceHandler) wsrpc.ServiceHandler {
+		return func(conn *wsrpc.Conn, args *wsrpc.Args) (interface{}, error) {
+			ctx := args.Ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+
+			res, err := l.Allow(ctx, keyFunc(conn, args))
+			switch {
+			case err != nil:
+				if l.FailClosed() {
+					return nil, wsrpc.NewError(wsrpc.CodeServerBusy, "rpc: rate limiter unavailable")
+				}
+			case !res.Allowed:
+				return nil, wsrpc.NewError(wsrpc.CodeRateLimited, "rpc: rate limited").
+					WithData(map[string]int64{"retry_after_ms": res.RetryAfter.Milliseconds()})
+			}
+			return next(conn, args)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package wsrpclimit
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/hkjojo/go-toolkits/redis"
+	"github.com/hkjojo/go-toolkits/wsrpc"
+)
+
+func newPool(addr string) *redis.Pool {
+	return redis.New(&redis.Config{MasterAddr: addr, ConnectTimeout: 1, ReadTimeout: 1, WriteTimeout: 1})
+}
+
+func TestWrapHandler(t *testing.T) {
+	m := miniredis.RunT(t)
+	m.SetTime(time.Unix(1700000000, 0))
+	p := newPool(m.Addr())
+	defer p.Close()
+
+	limiter := redis.NewRateLimiter(p, "rpc", redis.PerSecond(1), redis.WithRateAlgorithm(redis.TokenBucket))
+	handler := WrapHandler(limiter, func(conn *wsrpc.Conn, args *wsrpc.Args) string {
+		return args.Method
+	})(func(conn *wsrpc.Conn, args *wsrpc.Args) (interface{}, error) {
+		return "ok", nil
+	})
+
+	args := &wsrpc.Args{Method: "Quote.Get", Ctx: context.Background()}
+	if reply, err := handler(nil, args); err != nil || reply != "ok" {
+		t.Fatalf("call = %v, %v", reply, err)
+	}
+	_, err := handler(nil, args)
+	var e *wsrpc.Error
+	if !errors.As(err, &e) || e.Code != wsrpc.CodeRateLimited {
+		t.Fatalf("err = %v, want code %d", err, wsrpc.CodeRateLimited)
+	}
+	if data, _ := e.Data.(map[string]int64); data["retry_after_ms"] != 1000 {
+		t.Fatalf("data = %v, want a retry after 1000ms", e.Data)
+	}
+
+	// redis errors let the calls through unless fail closed
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+	down := newPool(ln.Addr().String())
+	defer down.Close()
+
+	next := func(*wsrpc.Conn, *wsrpc.Args) (interface{}, error) { return nil, nil }
+	keyFunc := func(*wsrpc.Conn, *wsrpc.Args) string { return "" }
+	open := WrapHandler(redis.NewRateLimiter(down, "rpc", redis.PerSecond(1)), keyFunc)(next)
+	if _, err := open(nil, args); err != nil {
+		t.Fatalf("err = %v, want the call let through", err)
+	}
+	closed := WrapHandler(redis.NewRateLimiter(down, "rpc", redis.PerSecond(1), redis.WithRateFailClosed()), keyFunc)(next)
+	if _, err := closed(nil, args); wsrpc.ErrorCode(err) != wsrpc.CodeServerBusy {
+		t.Fatalf("err = %v, want code %d", err, wsrpc.CodeServerBusy)
+	}
+}
@@ -0,0 +1,194 @@
+package wsrpc
+
+import (
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	errUnauthenticated  = NewError(CodeUnauthenticated, "rpc: unauthenticated")
+	errPermissionDenied = NewError(CodePermissionDenied, "rpc: permission denied")
+	errRateLimited      = NewError(CodeRateLimited, "rpc: rate limited")
+)
+
+// Principal is the identity a conn was authenticated as.
+type Principal struct {
+	ID    string
+	Roles []string
+	Data  interface{} // claims or user record, free to use
+}
+
+// HasRole reports whether the principal holds one of roles.
+func (p *Principal) HasRole(roles ...string) bool {
+	for _, role := range roles {
+		for _, r := range p.Roles {
+			if r == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Authenticator authenticates the upgrade request of a conn. An error
+// refuses the conn, a nil principal keeps it anonymous, then only the
+// methods without roles may be called.
+type Authenticator func(r *http.Request) (*Principal, error)
+
+// SetAuthenticator sets the authenticator run by OnConnect, it must be
+// called before the server starts serving conns.
+func (server *Server) SetAuthenticator(auth Authenticator) {
+	server.authenticator = auth
+}
+
+// authenticate runs the authenticator, on error ws is closed with a
+// policy violation close frame.
+func (server *Server) authenticate(r *http.Request, ws *websocket.Conn) (*Principal, bool) {
+	if server.authenticator == nil {
+		return nil, true
+	}
+
+	principal, err := server.authenticator(r)
+	if err != nil {
+		ws.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.ClosePolicyViolation, err.Error()),
+			time.Now().Add(defaultWriteTimeout))
+		ws.Close()
+		return nil, false
+	}
+	return principal, true
+}
+
+// authorize checks the roles and the rate limit of the method before
+// its params are decoded.
+func (server *Server) authorize(conn *Conn, s *service, mtype *methodType) error {
+	roles := mtype.roles
+	if roles == nil {
+		roles = s.roles
+	}
+	if len(roles) != 0 {
+		principal := conn.Principal()
+		if principal == nil {
+			return errUnauthenticated
+		}
+		if !principal.HasRole(roles...) {
+			return errPermissionDenied
+		}
+	}
+
+	if mtype.rateLimit != nil && !conn.bucket(mtype).allow(time.Now()) {
+		return errRateLimited
+	}
+	return nil
+}
+
+// WithServiceRoles restricts every method of the service without roles
+// of its own to the principals holding one of roles.
+func WithServiceRoles(roles ...string) ServiceOption {
+	return func(s *service) error {
+		s.roles = roles
+		return nil
+	}
+}
+
+// WithRoles restricts the method to the principals holding one of roles,
+// no roles opens it to everyone whatever the roles of the service.
+func WithRoles(roles ...string) MethodOption {
+	return func(m *methodType) {
+		m.roles = append([]string{}, roles...)
+	}
+}
+
+// RateLimit is a token bucket refilled with Rate tokens per second up
+// to Burst tokens, every call takes one.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// WithRateLimit limits the calls of the method per conn, or per client
+// over HTTP, calls beyond the limit are answered with CodeRateLimited.
+func WithRateLimit(rate float64, burst int) MethodOption {
+	return func(m *methodType) {
+		m.rateLimit = &RateLimit{Rate: rate, Burst: burst}
+	}
+}
+
+type tokenBucket struct {
+	limit RateLimit
+
+	mu     sync.Mutex // protects tokens, last
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit RateLimit) *tokenBucket {
+	return &tokenBucket{
+		limit:  limit,
+		tokens: float64(limit.Burst),
+		last:   time.Now(),
+	}
+}
+
+// full reports whether the bucket is refilled up to its burst at now.
+func (b *tokenBucket) full(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate >= float64(b.limit.Burst)
+}
+
+func (b *tokenBucket) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(float64(b.limit.Burst),
+			b.tokens+elapsed.Seconds()*b.limit.Rate)
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// rateBuckets are the rate limit buckets of the methods for a conn, or
+// for an HTTP client across its requests.
+type rateBuckets struct {
+	mu sync.Mutex // protects m
+	m  map[*methodType]*tokenBucket
+}
+
+func (b *rateBuckets) get(mtype *methodType) *tokenBucket {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	tb, ok := b.m[mtype]
+	if !ok {
+		if b.m == nil {
+			b.m = make(map[*methodType]*tokenBucket)
+		}
+		tb = newTokenBucket(*mtype.rateLimit)
+		b.m[mtype] = tb
+	}
+	return tb
+}
+
+// full reports whether every bucket is refilled up to its burst at now.
+func (b *rateBuckets) full(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, tb := range b.m {
+		if !tb.full(now) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,154 @@
+package wsrpc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type Vault int
+
+func (v *Vault) Open(conn *Conn, args int, reply *string) error {
+	*reply = conn.Principal().ID
+	return nil
+}
+
+func (v *Vault) Peek(conn *Conn, args int, reply *int) error {
+	return nil
+}
+
+func (v *Vault) Count(conn *Conn, args int, reply *int) error {
+	return nil
+}
+
+func tokenAuthenticator(r *http.Request) (*Principal, error) {
+	switch strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ") {
+	case "":
+		return nil, nil
+	case "admin":
+		return &Principal{ID: "root", Roles: []string{"admin"}}, nil
+	case "guest":
+		return &Principal{ID: "guest", Roles: []string{"viewer"}}, nil
+	}
+	return nil, errors.New("invalid token")
+}
+
+func newVaultServer(t *testing.T) (func(), string) {
+	server := NewServer()
+	server.SetAuthenticator(tokenAuthenticator)
+	err := server.Register(new(Vault),
+		WithServiceRoles("admin", "viewer"),
+		WithMethod("Open", WithRoles("admin")),
+		WithMethod("Count", WithRoles(), WithRateLimit(0.01, 2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, url := newTestServer(t, server)
+	return ts.Close, url
+}
+
+func dialToken(t *testing.T, url, token string) *Client {
+	header := http.Header{}
+	if token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+	client, err := Dial(context.Background(), url, WithHeader(header))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func expectCode(t *testing.T, err error, code int) {
+	t.Helper()
+	var e *Error
+	if !errors.As(err, &e) || e.Code != code {
+		t.Errorf("expected code %d got %v", code, err)
+	}
+}
+
+func TestAuthRoles(t *testing.T) {
+	closeServer, url := newVaultServer(t)
+	defer closeServer()
+
+	admin := dialToken(t, url, "admin")
+	defer admin.Close()
+	var id string
+	if err := admin.Call(context.Background(), "Vault.Open", 0, &id); err != nil || id != "root" {
+		t.Errorf("expected admin to open the vault got %s %v", id, err)
+	}
+
+	guest := dialToken(t, url, "guest")
+	defer guest.Close()
+	expectCode(t, guest.Call(context.Background(), "Vault.Open", 0, &id), CodePermissionDenied)
+	if err := guest.Call(context.Background(), "Vault.Peek", 0, new(int)); err != nil {
+		t.Errorf("expected viewer to peek got %v", err)
+	}
+
+	anonymous := dialToken(t, url, "")
+	defer anonymous.Close()
+	expectCode(t, anonymous.Call(context.Background(), "Vault.Peek", 0, new(int)), CodeUnauthenticated)
+	// WithRoles() without roles opens the method to everyone
+	if err := anonymous.Call(context.Background(), "Vault.Count", 0, new(int)); err != nil {
+		t.Errorf("expected anonymous to count got %v", err)
+	}
+}
+
+func TestAuthRefused(t *testing.T) {
+	closeServer, url := newVaultServer(t)
+	defer closeServer()
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer forged")
+	ws, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err = ws.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Errorf("expected policy violation got %v", err)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	closeServer, url := newVaultServer(t)
+	defer closeServer()
+
+	client := dialToken(t, url, "guest")
+	defer client.Close()
+	for i := 0; i < 2; i++ {
+		if err := client.Call(context.Background(), "Vault.Count", 0, new(int)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	expectCode(t, client.Call(context.Background(), "Vault.Count", 0, new(int)), CodeRateLimited)
+
+	// buckets are per conn
+	other := dialToken(t, url, "guest")
+	defer other.Close()
+	if err := other.Call(context.Background(), "Vault.Count", 0, new(int)); err != nil {
+		t.Errorf("expected another conn to have its own bucket got %v", err)
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket(RateLimit{Rate: 10, Burst: 1})
+	now := b.last
+	if !b.allow(now) || b.allow(now) {
+		t.Fatal("expected a burst of 1")
+	}
+	if b.allow(now.Add(time.Millisecond * 50)) {
+		t.Error("expected half a token after 50ms")
+	}
+	if !b.allow(now.Add(time.Millisecond * 100)) {
+		t.Error("expected a token after 100ms")
+	}
+}
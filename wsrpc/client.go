@@ -0,0 +1,513 @@
+package wsrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var (
+	// ErrShutdown is returned by calls on a client that has been closed.
+	ErrShutdown = errors.New("wsrpc: client is shut down")
+	// ErrNotConnected is returned by calls issued while the client is
+	// reconnecting to the server.
+	ErrNotConnected = errors.New("wsrpc: client is not connected")
+	// ErrConnLost is returned to pending calls when the connection drops
+	// before the server answered them.
+	ErrConnLost = errors.New("wsrpc: connection lost")
+)
+
+// ServerError represents an error that has been returned from
+// the remote side of the RPC connection.
+type ServerError string
+
+func (e ServerError) Error() string {
+	return string(e)
+}
+
+// Call represents an active RPC.
+type Call struct {
+	ServiceMethod string      // The name of the service and method to call.
+	Args          interface{} // The argument to the function.
+	Reply         interface{} // The reply from the function (*struct).
+	Error         error       // After completion, the error status.
+	Done          chan *Call  // Receives *Call when Go is complete.
+	seq           uint64
+}
+
+func (call *Call) done() {
+	select {
+	case call.Done <- call:
+	default:
+		// We don't want to block here. It is the caller's responsibility to make
+		// sure the channel has enough buffer space. See comment in Go().
+	}
+}
+
+// NotificationHandler handles a notification pushed by the server through
+// Conn.Notify, Conn.NotifyEx or a Notifier.
+type NotificationHandler func(method string, params json.RawMessage)
+
+type clientRequest struct {
+	Version string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+	ID      uint64      `json:"id"`
+}
+
+// clientResponse is wide enough to hold both a response and a
+// notification frame written by the server.
+type clientResponse struct {
+	ID           *json.RawMessage `json:"id"`
+	Result       json.RawMessage  `json:"result"`
+	Error        json.RawMessage  `json:"error"`
+	Method       string           `json:"method"`
+	Notification string           `json:"notification"`
+	Params       json.RawMessage  `json:"params"`
+}
+
+func (r *clientResponse) isNotification() bool {
+	return r.Notification != "" || (r.ID == nil && r.Method != "")
+}
+
+type clientOptions struct {
+	dialer       *websocket.Dialer
+	header       http.Header
+	callTimeout  time.Duration
+	writeTimeout time.Duration
+	reconnect    bool
+	minBackoff   time.Duration
+	maxBackoff   time.Duration
+	onReconnect  func(*Client)
+	logger       Logger
+}
+
+// ClientOption configures a Client.
+type ClientOption func(*clientOptions)
+
+// WithDialer sets the websocket dialer used to (re)connect.
+func WithDialer(dialer *websocket.Dialer) ClientOption {
+	return func(o *clientOptions) {
+		o.dialer = dialer
+	}
+}
+
+// WithHeader sets the http header sent with the handshake request.
+func WithHeader(header http.Header) ClientOption {
+	return func(o *clientOptions) {
+		o.header = header
+	}
+}
+
+// WithCallTimeout sets the timeout applied by Call when its context
+// carries no deadline.
+func WithCallTimeout(d time.Duration) ClientOption {
+	return func(o *clientOptions) {
+		o.callTimeout = d
+	}
+}
+
+// WithWriteTimeout sets the deadline for writing a single frame.
+func WithWriteTimeout(d time.Duration) ClientOption {
+	return func(o *clientOptions) {
+		o.writeTimeout = d
+	}
+}
+
+// WithReconnect redials the server with exponential backoff between
+// min and max after the connection is lost.
+func WithReconnect(min, max time.Duration) ClientOption {
+	return func(o *clientOptions) {
+		o.reconnect = true
+		o.minBackoff = min
+		o.maxBackoff = max
+	}
+}
+
+// WithReconnectHandler is called every time the client has reconnected,
+// typically to log in again or to restore server side subscriptions.
+func WithReconnectHandler(handler func(*Client)) ClientOption {
+	return func(o *clientOptions) {
+		o.onReconnect = handler
+	}
+}
+
+// WithClientLogger ...
+func WithClientLogger(logger Logger) ClientOption {
+	return func(o *clientOptions) {
+		o.logger = logger
+	}
+}
+
+// Client represents a JSON-RPC client over a websocket connection.
+// There may be multiple outstanding Calls associated
+// with a single Client, and a Client may be used by
+// multiple goroutines simultaneously.
+type Client struct {
+	url  string
+	opts clientOptions
+	done chan struct{}
+
+	sending sync.Mutex // serializes writes to ws
+
+	mu      sync.Mutex // protects following
+	ws      *websocket.Conn
+	seq     uint64
+	pending map[uint64]*Call
+	closing bool // user has called Close
+
+	subMu    sync.RWMutex // protects handlers, subSeq
+	handlers map[string]map[uint64]NotificationHandler
+	subSeq   uint64
+}
+
+// Dial connects to a wsrpc server at the specified websocket url.
+func Dial(ctx context.Context, url string, opts ...ClientOption) (*Client, error) {
+	client := &Client{
+		url: url,
+		opts: clientOptions{
+			dialer:     websocket.DefaultDialer,
+			minBackoff: time.Second,
+			maxBackoff: time.Second * 30,
+			logger:     &log{},
+		},
+		done:     make(chan struct{}),
+		pending:  make(map[uint64]*Call),
+		handlers: make(map[string]map[uint64]NotificationHandler),
+	}
+	for _, opt := range opts {
+		opt(&client.opts)
+	}
+
+	ws, err := client.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client.ws = ws
+	go client.readLoop(ws)
+	return client, nil
+}
+
+func (client *Client) dial(ctx context.Context) (*websocket.Conn, error) {
+	ws, _, err := client.opts.dialer.DialContext(ctx, client.url, client.opts.header)
+	return ws, err
+}
+
+// Connected reports whether the client currently holds a live connection.
+func (client *Client) Connected() bool {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	return client.ws != nil
+}
+
+// Go invokes the function asynchronously. It returns the Call structure representing
+// the invocation. The done channel will signal when the call is complete by returning
+// the same Call object. If done is nil, Go will allocate a new channel.
+// If non-nil, done must be buffered or Go will deliberately crash.
+func (client *Client) Go(serviceMethod string, args interface{}, reply interface{}, done chan *Call) *Call {
+	call := new(Call)
+	call.ServiceMethod = serviceMethod
+	call.Args = args
+	call.Reply = reply
+	if done == nil {
+		done = make(chan *Call, 10) // buffered.
+	} else {
+		// If caller passes done != nil, it must arrange that
+		// done has enough buffer for the number of simultaneous
+		// RPCs that will be using that channel. If the channel
+		// is totally unbuffered, it's best not to run at all.
+		if cap(done) == 0 {
+			panic("wsrpc: done channel is unbuffered")
+		}
+	}
+	call.Done = done
+	client.send(call)
+	return call
+}
+
+// Call invokes the named function, waits for it to complete, and returns its error status.
+// The call is abandoned when ctx is done; if ctx has no deadline the client
+// call timeout applies.
+func (client *Client) Call(ctx context.Context, serviceMethod string, args interface{}, reply interface{}) error {
+	if _, ok := ctx.Deadline(); !ok && client.opts.callTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, client.opts.callTimeout)
+		defer cancel()
+	}
+
+	call := client.Go(serviceMethod, args, reply, make(chan *Call, 1))
+	select {
+	case call = <-call.Done:
+		return call.Error
+	case <-ctx.Done():
+		client.abandon(call.seq)
+		return ctx.Err()
+	}
+}
+
+// Subscribe registers handler for the notifications of method. Handlers run
+// on the read goroutine of the client, so they must not block. The returned
+// function removes the handler.
+func (client *Client) Subscribe(method string, handler NotificationHandler) func() {
+	client.subMu.Lock()
+	client.subSeq++
+	id := client.subSeq
+	if client.handlers[method] == nil {
+		client.handlers[method] = make(map[uint64]NotificationHandler)
+	}
+	client.handlers[method][id] = handler
+	client.subMu.Unlock()
+
+	return func() {
+		client.subMu.Lock()
+		defer client.subMu.Unlock()
+
+		delete(client.handlers[method], id)
+		if len(client.handlers[method]) == 0 {
+			delete(client.handlers, method)
+		}
+	}
+}
+
+// Close closes the connection and stops reconnecting. Pending calls
+// fail with ErrShutdown.
+func (client *Client) Close() error {
+	client.mu.Lock()
+	if client.closing {
+		client.mu.Unlock()
+		return ErrShutdown
+	}
+	client.closing = true
+	ws := client.ws
+	client.mu.Unlock()
+	close(client.done)
+
+	if ws == nil {
+		return nil
+	}
+
+	client.sending.Lock()
+	ws.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+		time.Now().Add(time.Second))
+	client.sending.Unlock()
+	return ws.Close()
+}
+
+func (client *Client) send(call *Call) {
+	client.mu.Lock()
+	if client.closing {
+		client.mu.Unlock()
+		call.Error = ErrShutdown
+		call.done()
+		return
+	}
+	ws := client.ws
+	if ws == nil {
+		client.mu.Unlock()
+		call.Error = ErrNotConnected
+		call.done()
+		return
+	}
+	client.seq++
+	seq := client.seq
+	call.seq = seq
+	client.pending[seq] = call
+	client.mu.Unlock()
+
+	params := call.Args
+	if params == nil {
+		params = struct{}{}
+	}
+	err := client.write(ws, &clientRequest{
+		Version: "2.0",
+		Method:  call.ServiceMethod,
+		Params:  params,
+		ID:      seq,
+	})
+	if err != nil {
+		client.mu.Lock()
+		call = client.pending[seq]
+		delete(client.pending, seq)
+		client.mu.Unlock()
+		if call != nil {
+			call.Error = err
+			call.done()
+		}
+	}
+}
+
+func (client *Client) write(ws *websocket.Conn, v interface{}) error {
+	client.sending.Lock()
+	defer client.sending.Unlock()
+
+	if client.opts.writeTimeout > 0 {
+		ws.SetWriteDeadline(time.Now().Add(client.opts.writeTimeout))
+	}
+	return ws.WriteJSON(v)
+}
+
+func (client *Client) abandon(seq uint64) {
+	client.mu.Lock()
+	delete(client.pending, seq)
+	client.mu.Unlock()
+}
+
+func (client *Client) readLoop(ws *websocket.Conn) {
+	var err error
+	for {
+		var data []byte
+		_, data, err = ws.ReadMessage()
+		if err != nil {
+			break
+		}
+		client.dispatch(data)
+	}
+
+	client.mu.Lock()
+	if client.ws == ws {
+		client.ws = nil
+	}
+	pending := client.pending
+	client.pending = make(map[uint64]*Call)
+	closing := client.closing
+	client.mu.Unlock()
+	ws.Close()
+
+	for _, call := range pending {
+		if closing {
+			call.Error = ErrShutdown
+		} else {
+			call.Error = ErrConnLost
+		}
+		call.done()
+	}
+
+	if closing {
+		return
+	}
+	if !client.opts.reconnect {
+		client.opts.logger.Errorf("wsrpc: connection lost:%s\n", err)
+		return
+	}
+	client.reconnect()
+}
+
+func (client *Client) reconnect() {
+	backoff := client.opts.minBackoff
+	for {
+		select {
+		case <-client.done:
+			return
+		case <-time.After(backoff):
+		}
+
+		ws, err := client.dial(context.Background())
+		if err != nil {
+			client.opts.logger.Errorf("wsrpc: reconnect:%s\n", err)
+			backoff *= 2
+			if backoff > client.opts.maxBackoff {
+				backoff = client.opts.maxBackoff
+			}
+			continue
+		}
+
+		client.mu.Lock()
+		if client.closing {
+			client.mu.Unlock()
+			ws.Close()
+			return
+		}
+		client.ws = ws
+		client.mu.Unlock()
+
+		go client.readLoop(ws)
+		if client.opts.onReconnect != nil {
+			client.opts.onReconnect(client)
+		}
+		return
+	}
+}
+
+func (client *Client) dispatch(data []byte) {
+	var resp clientResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		client.opts.logger.Errorf("wsrpc: decode frame:%s\n", err)
+		return
+	}
+
+	if resp.isNotification() {
+		client.notify(&resp)
+		return
+	}
+
+	if resp.ID == nil {
+		client.opts.logger.Errorf("wsrpc: response without id:%s\n", resp.Error)
+		return
+	}
+
+	var seq uint64
+	if err := json.Unmarshal(*resp.ID, &seq); err != nil {
+		client.opts.logger.Errorf("wsrpc: invalid response id:%s\n", *resp.ID)
+		return
+	}
+
+	client.mu.Lock()
+	call := client.pending[seq]
+	delete(client.pending, seq)
+	client.mu.Unlock()
+	if call == nil {
+		// We've got no pending call. That usually means that
+		// the call was abandoned after its context was done.
+		return
+	}
+
+	switch {
+	case len(resp.Error) != 0 && string(resp.Error) != "null":
+		call.Error = decodeServerError(resp.Error)
+	case call.Reply != nil && len(resp.Result) != 0:
+		if err := json.Unmarshal(resp.Result, call.Reply); err != nil {
+			call.Error = errors.New("reading body " + err.Error())
+		}
+	}
+	call.done()
+}
+
+func (client *Client) notify(resp *clientResponse) {
+	method := resp.Notification
+	if method == "" {
+		method = resp.Method
+	}
+
+	client.subMu.RLock()
+	handlers := make([]NotificationHandler, 0, len(client.handlers[method]))
+	for _, handler := range client.handlers[method] {
+		handlers = append(handlers, handler)
+	}
+	client.subMu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(method, resp.Params)
+	}
+}
+
+// decodeServerError returns an *Error for JSON-RPC 2.0 error objects
+// and a ServerError for anything else.
+func decodeServerError(raw json.RawMessage) error {
+	var e Error
+	if err := json.Unmarshal(raw, &e); err == nil && (e.Code != 0 || e.Message != "") {
+		return &e
+	}
+
+	var msg string
+	if err := json.Unmarshal(raw, &msg); err == nil {
+		return ServerError(msg)
+	}
+	return ServerError(raw)
+}
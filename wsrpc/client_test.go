@@ -0,0 +1,213 @@
+package wsrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type ArithArgs struct {
+	A, B int
+}
+
+type ArithQuotient struct {
+	Quo, Rem int
+}
+
+type Arith int
+
+func (t *Arith) Multiply(conn *Conn, args *ArithArgs, reply *int) error {
+	*reply = args.A * args.B
+	return nil
+}
+
+func (t *Arith) Divide(conn *Conn, args *ArithArgs, quo *ArithQuotient) error {
+	if args.B == 0 {
+		return errors.New("divide by zero")
+	}
+	quo.Quo = args.A / args.B
+	quo.Rem = args.A % args.B
+	return nil
+}
+
+func (t *Arith) Echo(conn *Conn, args string, reply *string) error {
+	*reply = args
+	return conn.Notify("Arith.Echoed", args)
+}
+
+func (t *Arith) Sleep(conn *Conn, args time.Duration, reply *int) error {
+	time.Sleep(args)
+	return nil
+}
+
+func (t *Arith) Kick(conn *Conn, args int, reply *int) error {
+	return conn.Close()
+}
+
+func newTestServer(t *testing.T, server *Server) (*httptest.Server, string) {
+	upgrader := websocket.Upgrader{Subprotocols: server.Subprotocols()}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		server.OnConnect(r, ws)
+	}))
+	return ts, "ws" + strings.TrimPrefix(ts.URL, "http")
+}
+
+func newArithServer(t *testing.T) (*httptest.Server, string) {
+	server := NewServer()
+	if err := server.Register(new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	return newTestServer(t, server)
+}
+
+func TestClientCall(t *testing.T) {
+	ts, url := newArithServer(t)
+	defer ts.Close()
+
+	client, err := Dial(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var reply int
+	err = client.Call(context.Background(), "Arith.Multiply", &ArithArgs{7, 8}, &reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != 56 {
+		t.Errorf("Multiply: expected 56 got %d", reply)
+	}
+
+	var quo ArithQuotient
+	err = client.Call(context.Background(), "Arith.Divide", &ArithArgs{7, 0}, &quo)
+	if err == nil || err.Error() != "divide by zero" {
+		t.Errorf("Divide: expected divide by zero error got %v", err)
+	}
+
+	err = client.Call(context.Background(), "Arith.Unknown", &ArithArgs{}, &reply)
+	if err == nil {
+		t.Error("Unknown: expected error")
+	}
+}
+
+func TestClientGo(t *testing.T) {
+	ts, url := newArithServer(t)
+	defer ts.Close()
+
+	client, err := Dial(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	done := make(chan *Call, 10)
+	for i := 0; i < 10; i++ {
+		client.Go("Arith.Multiply", &ArithArgs{i, i}, new(int), done)
+	}
+	for i := 0; i < 10; i++ {
+		call := <-done
+		if call.Error != nil {
+			t.Fatal(call.Error)
+		}
+		args := call.Args.(*ArithArgs)
+		if got := *call.Reply.(*int); got != args.A*args.B {
+			t.Errorf("Multiply: expected %d got %d", args.A*args.B, got)
+		}
+	}
+}
+
+func TestClientTimeout(t *testing.T) {
+	ts, url := newArithServer(t)
+	defer ts.Close()
+
+	client, err := Dial(context.Background(), url, WithCallTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var reply int
+	err = client.Call(context.Background(), "Arith.Sleep", time.Second, &reply)
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected deadline exceeded got %v", err)
+	}
+}
+
+func TestClientSubscribe(t *testing.T) {
+	ts, url := newArithServer(t)
+	defer ts.Close()
+
+	client, err := Dial(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	received := make(chan string, 1)
+	unsubscribe := client.Subscribe("Arith.Echoed", func(method string, params json.RawMessage) {
+		var s string
+		json.Unmarshal(params, &s)
+		received <- s
+	})
+	defer unsubscribe()
+
+	var reply string
+	if err := client.Call(context.Background(), "Arith.Echo", "hello", &reply); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case s := <-received:
+		if s != "hello" {
+			t.Errorf("expected hello got %s", s)
+		}
+	case <-time.After(time.Second):
+		t.Error("notification not received")
+	}
+}
+
+func TestClientReconnect(t *testing.T) {
+	ts, url := newArithServer(t)
+	defer ts.Close()
+
+	reconnected := make(chan struct{}, 1)
+	client, err := Dial(context.Background(), url,
+		WithReconnect(10*time.Millisecond, 100*time.Millisecond),
+		WithReconnectHandler(func(*Client) {
+			reconnected <- struct{}{}
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	client.Go("Arith.Kick", 0, new(int), nil)
+
+	select {
+	case <-reconnected:
+	case <-time.After(time.Second):
+		t.Fatal("client not reconnected")
+	}
+
+	var reply int
+	err = client.Call(context.Background(), "Arith.Multiply", &ArithArgs{2, 3}, &reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply != 6 {
+		t.Errorf("Multiply: expected 6 got %d", reply)
+	}
+}
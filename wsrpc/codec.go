@@ -5,22 +5,28 @@
 package wsrpc
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"io"
 	"sync"
 )
 
-var errMissingParams = errors.New("jsonrpc: request body missing params")
+var (
+	errMissingParams  = errors.New("jsonrpc: request body missing params")
+	errInvalidRequest = NewError(CodeInvalidRequest, "jsonrpc: invalid request")
+)
 
 type serverCodec struct {
 	c       io.Closer
 	dec     *json.Decoder // for reading JSON values
 	enc     *json.Encoder // for writing JSON values
-	pending map[uint64]*json.RawMessage
+	pending map[uint64]*pendingRequest
 
 	// temporary work space
 	req serverRequest
+	// requests of the last batch not yet handed out by ReadRequestHeader
+	queue []*batchRequest
 	// JSON-RPC clients can use arbitrary json values as request IDs.
 	// Package rpc expects uint64 request IDs.
 	// We assign uint64 sequence numbers to incoming requests
@@ -28,7 +34,8 @@ type serverCodec struct {
 	// When rpc responds, we use the sequence number in
 	// the response to find the original request ID.
 	seq   uint64
-	mutex sync.Mutex // protects seq, pending
+	mutex sync.Mutex // protects seq, pending, batch replies
+	encMu sync.Mutex // serializes writes, the codec may answer a batch by itself
 }
 
 // NewServerCodec returns a new ServerCodec using JSON-RPC on conn.
@@ -37,15 +44,36 @@ func NewServerCodec(conn io.ReadWriteCloser) ServerCodec {
 		dec:     json.NewDecoder(conn),
 		enc:     json.NewEncoder(conn),
 		c:       conn,
-		pending: make(map[uint64]*json.RawMessage),
+		pending: make(map[uint64]*pendingRequest),
 	}
 }
 
+type pendingRequest struct {
+	id    *json.RawMessage
+	batch *batchResponse
+	index int
+}
+
+// batchRequest is one element of a JSON-RPC 2.0 batch.
+type batchRequest struct {
+	req   serverRequest
+	batch *batchResponse
+	index int
+}
+
+// batchResponse collects the responses of a batch, it is written as
+// a single array once every request of the batch has been answered.
+type batchResponse struct {
+	replies   []*serverResponse
+	remaining int
+}
+
 type serverRequest struct {
-	Params  *json.RawMessage `json:"params"`
-	ID      *json.RawMessage `json:"id"`
-	Version string           `json:"jsonrpc"`
-	Method  string           `json:"method"`
+	Params  *json.RawMessage  `json:"params"`
+	ID      *json.RawMessage  `json:"id"`
+	Version string            `json:"jsonrpc"`
+	Method  string            `json:"method"`
+	Meta    map[string]string `json:"meta,omitempty"`
 }
 
 type notification struct {
@@ -59,6 +87,7 @@ func (r *serverRequest) reset() {
 	r.Method = ""
 	r.Params = nil
 	r.ID = nil
+	r.Meta = nil
 }
 
 type serverResponse struct {
@@ -69,11 +98,21 @@ type serverResponse struct {
 }
 
 func (c *serverCodec) ReadRequestHeader(r *Request) error {
-	c.req.reset()
-	if err := c.dec.Decode(&c.req); err != nil {
-		return err
+	for len(c.queue) == 0 {
+		var raw json.RawMessage
+		if err := c.dec.Decode(&raw); err != nil {
+			return err
+		}
+		if err := c.readFrame(raw); err != nil {
+			return err
+		}
 	}
 
+	next := c.queue[0]
+	c.queue[0] = nil
+	c.queue = c.queue[1:]
+	c.req = next.req
+
 	r.ServiceMethod = c.GetMethod()
 
 	// JSON request id can be any JSON value;
@@ -81,13 +120,69 @@ func (c *serverCodec) ReadRequestHeader(r *Request) error {
 	// internal uint64 and save JSON on the side.
 	c.mutex.Lock()
 	c.seq++
-	c.pending[c.seq] = c.req.ID
+	c.pending[c.seq] = &pendingRequest{
+		id:    c.req.ID,
+		batch: next.batch,
+		index: next.index,
+	}
 	c.req.ID = nil
 	r.Seq = c.seq
 	c.mutex.Unlock()
 	return nil
 }
 
+// readFrame queues the requests carried by raw, which is either a single
+// request object or a JSON-RPC 2.0 batch array.
+func (c *serverCodec) readFrame(raw json.RawMessage) error {
+	raw = bytes.TrimLeft(raw, " \t\r\n")
+	if len(raw) == 0 || raw[0] != '[' {
+		var req serverRequest
+		if err := json.Unmarshal(raw, &req); err != nil {
+			return err
+		}
+		c.queue = append(c.queue, &batchRequest{req: req})
+		return nil
+	}
+
+	var elems []json.RawMessage
+	if err := json.Unmarshal(raw, &elems); err != nil {
+		return err
+	}
+
+	// an empty batch is answered with a single error, not an array
+	if len(elems) == 0 {
+		return c.encode(&serverResponse{
+			ID:      &null,
+			Version: "2.0",
+			Error:   errInvalidRequest,
+		})
+	}
+
+	batch := &batchResponse{replies: make([]*serverResponse, len(elems))}
+	for i, elem := range elems {
+		var req serverRequest
+		if err := json.Unmarshal(elem, &req); err != nil || req.Method == "" {
+			id := req.ID
+			if id == nil {
+				id = &null
+			}
+			batch.replies[i] = &serverResponse{
+				ID:      id,
+				Version: "2.0",
+				Error:   errInvalidRequest,
+			}
+			continue
+		}
+		batch.remaining++
+		c.queue = append(c.queue, &batchRequest{req: req, batch: batch, index: i})
+	}
+
+	if batch.remaining == 0 {
+		return c.writeBatch(batch)
+	}
+	return nil
+}
+
 func (c *serverCodec) ReadRequestBody(x interface{}) (err error) {
 	if c.req.Params == nil {
 		err = errMissingParams
@@ -115,6 +210,9 @@ func (c *serverCodec) ReadRequestBody(x interface{}) (err error) {
 
 // GetParams ...
 func (c *serverCodec) GetParams() json.RawMessage {
+	if c.req.Params == nil {
+		return nil
+	}
 	return *c.req.Params
 }
 
@@ -123,33 +221,85 @@ func (c *serverCodec) GetMethod() string {
 	return c.req.Method
 }
 
+// GetMeta ...
+func (c *serverCodec) GetMeta() map[string]string {
+	return c.req.Meta
+}
+
 var null = json.RawMessage([]byte("null"))
 
 func (c *serverCodec) WriteResponse(r *Response, x interface{}) error {
 	c.mutex.Lock()
-	b, ok := c.pending[r.Seq]
+	p, ok := c.pending[r.Seq]
 	if !ok {
 		c.mutex.Unlock()
 		return errors.New("invalid sequence number in response")
 	}
 	delete(c.pending, r.Seq)
+
+	if p.batch == nil {
+		c.mutex.Unlock()
+		return c.encode(newServerResponse(p.id, r, x))
+	}
+
+	// requests of a batch without id are notifications,
+	// they have no entry in the batch response
+	if p.id != nil {
+		p.batch.replies[p.index] = newServerResponse(p.id, r, x)
+	}
+	p.batch.remaining--
+	done := p.batch.remaining == 0
 	c.mutex.Unlock()
 
-	if b == nil {
+	if !done {
+		return nil
+	}
+	return c.writeBatch(p.batch)
+}
+
+func newServerResponse(id *json.RawMessage, r *Response, x interface{}) *serverResponse {
+	if id == nil {
 		// Invalid request so no id. Use JSON null.
-		b = &null
+		id = &null
 	}
-	resp := serverResponse{ID: b, Version: "2.0"}
+	resp := &serverResponse{ID: id, Version: "2.0"}
 	if r.Error == "" {
 		resp.Result = x
-	} else {
-		resp.Error = r.Error
+		return resp
+	}
+
+	code := r.Code
+	if code == 0 {
+		code = CodeServerError
 	}
-	return c.enc.Encode(resp)
+	resp.Error = &Error{Code: code, Message: r.Error, Data: r.Data}
+	return resp
+}
+
+// writeBatch writes the replies of a completed batch in request order.
+// Nothing is written when the batch only held notifications.
+func (c *serverCodec) writeBatch(batch *batchResponse) error {
+	replies := make([]*serverResponse, 0, len(batch.replies))
+	for _, reply := range batch.replies {
+		if reply != nil {
+			replies = append(replies, reply)
+		}
+	}
+	if len(replies) == 0 {
+		return nil
+	}
+	return c.encode(replies)
+}
+
+func (c *serverCodec) encode(v interface{}) error {
+	c.encMu.Lock()
+	defer c.encMu.Unlock()
+
+	return c.enc.Encode(v)
 }
 
 func (c *serverCodec) WriteNotificationEx(method string, x interface{}) error {
-	return c.enc.Encode(&notification{
+	return c.encode(&notification{
 		Version:      "2.0",
 		Method:       method,
 		Notification: method,
@@ -158,7 +308,7 @@ func (c *serverCodec) WriteNotificationEx(method string, x interface{}) error {
 }
 
 func (c *serverCodec) WriteNotification(method string, x interface{}) error {
-	return c.enc.Encode(&notification{
+	return c.encode(&notification{
 		Version:      "2.0",
 		Method:       method,
 		Notification: method,
@@ -0,0 +1,303 @@
+package wsrpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"sync"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// binaryFormat is a self-delimiting binary encoding of the JSON-RPC
+// envelope. Struct fields are named after their json tags, so replies
+// keep the same keys as with the JSON codec.
+type binaryFormat interface {
+	// newDecoder returns a func reading the next request from r.
+	newDecoder(r io.Reader) func(*binaryRequest) error
+	marshal(v interface{}) ([]byte, error)
+	unmarshal(data []byte, v interface{}) error
+	// isArray reports whether data holds an array.
+	isArray(data []byte) bool
+}
+
+type binaryRequest struct {
+	Method string
+	Params []byte
+	ID     interface{}
+	Meta   map[string]string
+}
+
+type binaryResponse struct {
+	Result  interface{} `json:"result,omitempty"`
+	Error   *Error      `json:"error,omitempty"`
+	ID      interface{} `json:"id"`
+	Version string      `json:"jsonrpc"`
+}
+
+type binaryCodec struct {
+	format  binaryFormat
+	c       io.ReadWriteCloser
+	decode  func(*binaryRequest) error
+	pending map[uint64]interface{}
+
+	// temporary work space
+	req binaryRequest
+
+	seq   uint64
+	mutex sync.Mutex // protects seq, pending
+	encMu sync.Mutex // serializes writes
+}
+
+// NewMsgpackServerCodec returns a new ServerCodec using JSON-RPC encoded
+// with MessagePack on conn. Every response and notification is written
+// with a single Write, use binary frames for websocket conns.
+func NewMsgpackServerCodec(conn io.ReadWriteCloser) ServerCodec {
+	return newBinaryCodec(conn, msgpackFormat{})
+}
+
+// NewCBORServerCodec returns a new ServerCodec using JSON-RPC encoded
+// with CBOR on conn. Every response and notification is written with
+// a single Write, use binary frames for websocket conns.
+func NewCBORServerCodec(conn io.ReadWriteCloser) ServerCodec {
+	return newBinaryCodec(conn, defaultCBORFormat)
+}
+
+func newBinaryCodec(conn io.ReadWriteCloser, format binaryFormat) *binaryCodec {
+	return &binaryCodec{
+		format:  format,
+		c:       conn,
+		decode:  format.newDecoder(conn),
+		pending: make(map[uint64]interface{}),
+	}
+}
+
+func (c *binaryCodec) ReadRequestHeader(r *Request) error {
+	c.req = binaryRequest{}
+	if err := c.decode(&c.req); err != nil {
+		return err
+	}
+
+	r.ServiceMethod = c.req.Method
+
+	c.mutex.Lock()
+	c.seq++
+	c.pending[c.seq] = c.req.ID
+	c.req.ID = nil
+	r.Seq = c.seq
+	c.mutex.Unlock()
+	return nil
+}
+
+func (c *binaryCodec) ReadRequestBody(x interface{}) error {
+	if c.req.Params == nil {
+		return errMissingParams
+	}
+	if x == nil {
+		return nil
+	}
+
+	if c.format.isArray(c.req.Params) {
+		// params given by position, the first one is the argument
+		var params []interface{}
+		if err := c.format.unmarshal(c.req.Params, &params); err != nil {
+			return err
+		}
+		if len(params) == 0 {
+			return errMissingParams
+		}
+		data, err := c.format.marshal(params[0])
+		if err != nil {
+			return err
+		}
+		return c.format.unmarshal(data, x)
+	}
+	return c.format.unmarshal(c.req.Params, x)
+}
+
+// GetParams returns the params transcoded to JSON, for MissingMethodFunc.
+func (c *binaryCodec) GetParams() json.RawMessage {
+	if c.req.Params == nil {
+		return nil
+	}
+
+	var v interface{}
+	if err := c.format.unmarshal(c.req.Params, &v); err != nil {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// GetMethod ...
+func (c *binaryCodec) GetMethod() string {
+	return c.req.Method
+}
+
+// GetMeta ...
+func (c *binaryCodec) GetMeta() map[string]string {
+	return c.req.Meta
+}
+
+func (c *binaryCodec) WriteResponse(r *Response, x interface{}) error {
+	c.mutex.Lock()
+	id, ok := c.pending[r.Seq]
+	if !ok {
+		c.mutex.Unlock()
+		return errors.New("invalid sequence number in response")
+	}
+	delete(c.pending, r.Seq)
+	c.mutex.Unlock()
+
+	resp := binaryResponse{ID: id, Version: "2.0"}
+	if r.Error == "" {
+		resp.Result = x
+	} else {
+		code := r.Code
+		if code == 0 {
+			code = CodeServerError
+		}
+		resp.Error = &Error{Code: code, Message: r.Error, Data: r.Data}
+	}
+	return c.write(&resp)
+}
+
+func (c *binaryCodec) WriteNotificationEx(method string, x interface{}) error {
+	return c.write(&notification{
+		Version:      "2.0",
+		Method:       method,
+		Notification: method,
+		Params:       []interface{}{x},
+	})
+}
+
+func (c *binaryCodec) WriteNotification(method string, x interface{}) error {
+	return c.write(&notification{
+		Version:      "2.0",
+		Method:       method,
+		Notification: method,
+		Params:       x,
+	})
+}
+
+func (c *binaryCodec) write(v interface{}) error {
+	data, err := c.format.marshal(v)
+	if err != nil {
+		return err
+	}
+
+	c.encMu.Lock()
+	defer c.encMu.Unlock()
+
+	_, err = c.c.Write(data)
+	return err
+}
+
+func (c *binaryCodec) Close() error {
+	return c.c.Close()
+}
+
+type msgpackRequest struct {
+	Method string             `msgpack:"method"`
+	Params msgpack.RawMessage `msgpack:"params"`
+	ID     interface{}        `msgpack:"id"`
+	Meta   map[string]string  `msgpack:"meta"`
+}
+
+type msgpackFormat struct{}
+
+func (msgpackFormat) newDecoder(r io.Reader) func(*binaryRequest) error {
+	dec := msgpack.NewDecoder(r)
+	return func(req *binaryRequest) error {
+		var m msgpackRequest
+		if err := dec.Decode(&m); err != nil {
+			return err
+		}
+		req.Method, req.Params, req.ID, req.Meta = m.Method, m.Params, m.ID, m.Meta
+		return nil
+	}
+}
+
+func (msgpackFormat) marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackFormat) unmarshal(data []byte, v interface{}) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+func (msgpackFormat) isArray(data []byte) bool {
+	if len(data) == 0 {
+		return false
+	}
+	c := data[0]
+	return (c >= 0x90 && c <= 0x9f) || c == 0xdc || c == 0xdd
+}
+
+type cborRequest struct {
+	Method string            `cbor:"method"`
+	Params cbor.RawMessage   `cbor:"params"`
+	ID     interface{}       `cbor:"id"`
+	Meta   map[string]string `cbor:"meta"`
+}
+
+var defaultCBORFormat = newCBORFormat()
+
+type cborFormat struct {
+	enc cbor.EncMode
+	dec cbor.DecMode
+}
+
+func newCBORFormat() *cborFormat {
+	enc, err := cbor.EncOptions{}.EncMode()
+	if err != nil {
+		panic(err)
+	}
+	// decode maps with string keys so params can be transcoded to JSON
+	dec, err := cbor.DecOptions{
+		DefaultMapType: reflect.TypeOf(map[string]interface{}(nil)),
+	}.DecMode()
+	if err != nil {
+		panic(err)
+	}
+	return &cborFormat{enc: enc, dec: dec}
+}
+
+func (f *cborFormat) newDecoder(r io.Reader) func(*binaryRequest) error {
+	dec := f.dec.NewDecoder(r)
+	return func(req *binaryRequest) error {
+		var m cborRequest
+		if err := dec.Decode(&m); err != nil {
+			return err
+		}
+		req.Method, req.Params, req.ID, req.Meta = m.Method, m.Params, m.ID, m.Meta
+		return nil
+	}
+}
+
+func (f *cborFormat) marshal(v interface{}) ([]byte, error) {
+	return f.enc.Marshal(v)
+}
+
+func (f *cborFormat) unmarshal(data []byte, v interface{}) error {
+	return f.dec.Unmarshal(data, v)
+}
+
+func (f *cborFormat) isArray(data []byte) bool {
+	// major type 4
+	return len(data) != 0 && data[0]>>5 == 4
+}
@@ -0,0 +1,124 @@
+package wsrpc
+
+import (
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/gorilla/websocket"
+)
+
+type binaryTestResponse struct {
+	ID           interface{} `json:"id"`
+	Result       interface{} `json:"result"`
+	Error        *Error      `json:"error"`
+	Method       string      `json:"method"`
+	Notification string      `json:"notification"`
+	Params       interface{} `json:"params"`
+}
+
+func TestBinaryCodec(t *testing.T) {
+	cases := []struct {
+		encoding  Encoding
+		marshal   func(interface{}) ([]byte, error)
+		unmarshal func([]byte, interface{}) error
+	}{
+		{MsgpackEncoding, msgpackFormat{}.marshal, msgpackFormat{}.unmarshal},
+		{CBOREncoding, cbor.Marshal, defaultCBORFormat.unmarshal},
+	}
+
+	ts, url := newArithServer(t)
+	defer ts.Close()
+
+	for _, c := range cases {
+		dialer := websocket.Dialer{Subprotocols: []string{c.encoding.Subprotocol}}
+		ws, _, err := dialer.Dial(url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ws.Subprotocol() != c.encoding.Subprotocol {
+			t.Fatalf("expected subprotocol %s got %s", c.encoding.Subprotocol, ws.Subprotocol())
+		}
+
+		requests := []map[string]interface{}{
+			{"jsonrpc": "2.0", "method": "Arith.Divide", "params": ArithArgs{7, 2}, "id": 1},
+			{"jsonrpc": "2.0", "method": "Arith.Divide", "params": []interface{}{ArithArgs{9, 4}}, "id": 2},
+			{"jsonrpc": "2.0", "method": "Arith.Divide", "params": ArithArgs{1, 0}, "id": 3},
+		}
+		expected := []map[string]interface{}{
+			{"Quo": 3, "Rem": 1},
+			{"Quo": 2, "Rem": 1},
+			nil,
+		}
+		for i, req := range requests {
+			data, err := c.marshal(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := ws.WriteMessage(websocket.BinaryMessage, data); err != nil {
+				t.Fatal(err)
+			}
+
+			typ, data, err := ws.ReadMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if typ != websocket.BinaryMessage {
+				t.Errorf("%s: expected binary frame got %d", c.encoding.Subprotocol, typ)
+			}
+			var resp binaryTestResponse
+			if err := c.unmarshal(data, &resp); err != nil {
+				t.Fatal(err)
+			}
+
+			if expected[i] == nil {
+				if resp.Error == nil || resp.Error.Message != "divide by zero" {
+					t.Errorf("%s: expected divide by zero got %+v", c.encoding.Subprotocol, resp.Error)
+				}
+				continue
+			}
+			if resp.Error != nil {
+				t.Errorf("%s: unexpected error %v", c.encoding.Subprotocol, resp.Error)
+				continue
+			}
+			result, _ := resp.Result.(map[string]interface{})
+			for k, v := range expected[i] {
+				if toInt(result[k]) != v {
+					t.Errorf("%s: expected %s=%v got %v", c.encoding.Subprotocol, k, v, result[k])
+				}
+			}
+		}
+
+		data, _ := c.marshal(map[string]interface{}{
+			"jsonrpc": "2.0", "method": "Arith.Echo", "params": "hello", "id": 4,
+		})
+		ws.WriteMessage(websocket.BinaryMessage, data)
+		for i := 0; i < 2; i++ {
+			_, data, err := ws.ReadMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var resp binaryTestResponse
+			if err := c.unmarshal(data, &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Notification != "" && resp.Params != "hello" {
+				t.Errorf("%s: expected notification hello got %v", c.encoding.Subprotocol, resp.Params)
+			}
+		}
+		ws.Close()
+	}
+}
+
+func toInt(v interface{}) int {
+	switch v := v.(type) {
+	case int8:
+		return int(v)
+	case uint8:
+		return int(v)
+	case int64:
+		return int(v)
+	case uint64:
+		return int(v)
+	}
+	return -1
+}
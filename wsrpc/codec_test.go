@@ -0,0 +1,136 @@
+package wsrpc
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type testResponse struct {
+	ID     *json.RawMessage `json:"id"`
+	Result json.RawMessage  `json:"result"`
+	Error  json.RawMessage  `json:"error"`
+}
+
+func dialRaw(t *testing.T, url string) *websocket.Conn {
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	return ws
+}
+
+func TestBatchRequest(t *testing.T) {
+	ts, url := newArithServer(t)
+	defer ts.Close()
+
+	ws := dialRaw(t, url)
+	defer ws.Close()
+
+	err := ws.WriteMessage(websocket.TextMessage, []byte(`[
+		{"jsonrpc":"2.0","method":"Arith.Multiply","params":{"A":2,"B":3},"id":"a"},
+		{"jsonrpc":"2.0","method":"Arith.Multiply","params":{"A":4,"B":5}},
+		{"jsonrpc":"2.0","method":"Arith.Unknown","params":{},"id":"b"},
+		1,
+		{"jsonrpc":"2.0","method":"Arith.Multiply","params":{"A":6,"B":7},"id":"c"}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var resps []testResponse
+	if err := ws.ReadJSON(&resps); err != nil {
+		t.Fatal(err)
+	}
+
+	// the notification has no entry, the others keep request order
+	expected := []struct {
+		id     string
+		result string
+		isErr  bool
+	}{
+		{`"a"`, `6`, false},
+		{`"b"`, ``, true},
+		{`null`, ``, true},
+		{`"c"`, `42`, false},
+	}
+	if len(resps) != len(expected) {
+		t.Fatalf("expected %d responses got %d", len(expected), len(resps))
+	}
+	for i, e := range expected {
+		var id = "null"
+		if resps[i].ID != nil {
+			id = string(*resps[i].ID)
+		}
+		if id != e.id {
+			t.Errorf("response %d: expected id %s got %s", i, e.id, id)
+		}
+		if e.isErr != (len(resps[i].Error) != 0) {
+			t.Errorf("response %d: unexpected error %s", i, resps[i].Error)
+		}
+		if !e.isErr && string(resps[i].Result) != e.result {
+			t.Errorf("response %d: expected result %s got %s", i, e.result, resps[i].Result)
+		}
+	}
+}
+
+func TestBatchRequestEmpty(t *testing.T) {
+	ts, url := newArithServer(t)
+	defer ts.Close()
+
+	ws := dialRaw(t, url)
+	defer ws.Close()
+
+	if err := ws.WriteMessage(websocket.TextMessage, []byte(`[]`)); err != nil {
+		t.Fatal(err)
+	}
+
+	var resp testResponse
+	if err := ws.ReadJSON(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Error) == 0 {
+		t.Error("expected invalid request error")
+	}
+
+	// the connection keeps serving after an invalid batch
+	err := ws.WriteJSON(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  "Arith.Multiply",
+		"params":  ArithArgs{3, 3},
+		"id":      1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.ReadJSON(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if string(resp.Result) != "9" {
+		t.Errorf("expected 9 got %s", resp.Result)
+	}
+}
+
+func TestBatchRequestNotifications(t *testing.T) {
+	ts, url := newArithServer(t)
+	defer ts.Close()
+
+	ws := dialRaw(t, url)
+	defer ws.Close()
+
+	err := ws.WriteMessage(websocket.TextMessage, []byte(`[
+		{"jsonrpc":"2.0","method":"Arith.Multiply","params":{"A":2,"B":3}},
+		{"jsonrpc":"2.0","method":"Arith.Multiply","params":{"A":4,"B":5}}
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ws.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, data, err := ws.ReadMessage(); err == nil {
+		t.Errorf("expected no response got %s", data)
+	}
+}
@@ -1,6 +1,7 @@
 package wsrpc
 
 import (
+	"context"
 	"sync"
 
 	"net/http"
@@ -11,32 +12,64 @@ type ConnCloseHandler func()
 
 // Conn ...
 type Conn struct {
-	codec   ServerCodec
-	Request *http.Request
-	// rwc           *ReadWriteCloser
+	stats         stats // accessed atomically, keep 64-bit aligned
+	id            uint64
+	registry      *registry
+	codec         ServerCodec
+	Request       *http.Request
+	rwc           *ReadWriteCloser // nil if not served over a websocket
 	sending       *sync.Mutex
 	extraData     map[string]interface{}
 	closeHandlers []ConnCloseHandler
 	mu            sync.RWMutex
 	closed        bool
+	ctx           context.Context
+	cancel        context.CancelFunc
+	inFlight      chan struct{} // conn slots, nil if unlimited
+	principal     *Principal
+	buckets       *rateBuckets
+	calls         sync.WaitGroup // dispatched requests
+	noNotify      bool           // set for the conns of http requests
 }
 
 // NewConn ...
 func NewConn(req *http.Request, sending *sync.Mutex, codec ServerCodec) *Conn {
+	ctx := context.Background()
+	if req != nil {
+		ctx = req.Context()
+	}
+
 	conn := &Conn{
 		Request:   req,
 		sending:   sending,
 		codec:     codec,
 		extraData: make(map[string]interface{}),
 	}
+	conn.ctx, conn.cancel = context.WithCancel(ctx)
 
 	return conn
 }
 
+// ID returns the id of the conn, unique within its server.
+func (c *Conn) ID() uint64 {
+	return c.id
+}
+
+// Stats returns the request stats of the conn.
+func (c *Conn) Stats() Stats {
+	return c.stats.load()
+}
+
+// Context returns a ctx that is cancelled when the conn terminates.
+func (c *Conn) Context() context.Context {
+	return c.ctx
+}
+
 func (c *Conn) ternimating() {
 	c.mu.Lock()
 	c.closed = true
 	c.mu.Unlock()
+	c.cancel()
 
 	for _, handler := range c.closeHandlers {
 		handler()
@@ -47,6 +80,9 @@ func (c *Conn) ternimating() {
 
 // Notify ...
 func (c *Conn) Notify(method string, params interface{}) error {
+	if c.noNotify {
+		return ErrNotifyUnsupported
+	}
 	c.sending.Lock()
 	defer c.sending.Unlock()
 
@@ -55,6 +91,9 @@ func (c *Conn) Notify(method string, params interface{}) error {
 
 // NotifyEx ...
 func (c *Conn) NotifyEx(method string, params interface{}) error {
+	if c.noNotify {
+		return ErrNotifyUnsupported
+	}
 	c.sending.Lock()
 	defer c.sending.Unlock()
 
@@ -77,6 +116,35 @@ func (c *Conn) OnClose(f ConnCloseHandler) {
 	c.closeHandlers = append(c.closeHandlers, f)
 }
 
+// Principal returns the principal the conn was authenticated as, nil
+// if it is anonymous.
+func (c *Conn) Principal() *Principal {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.principal
+}
+
+// SetPrincipal sets the principal of the conn, such as after a login call.
+func (c *Conn) SetPrincipal(p *Principal) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.principal = p
+}
+
+// bucket returns the rate limit bucket of the method for the conn.
+func (c *Conn) bucket(mtype *methodType) *tokenBucket {
+	c.mu.Lock()
+	if c.buckets == nil {
+		c.buckets = &rateBuckets{}
+	}
+	buckets := c.buckets
+	c.mu.Unlock()
+
+	return buckets.get(mtype)
+}
+
 // GetData ...
 func (c *Conn) GetData(key string) interface{} {
 	c.mu.RLock()
@@ -85,18 +153,25 @@ func (c *Conn) GetData(key string) interface{} {
 	return c.extraData[key]
 }
 
-// SetData ...
+// SetData sets the value of key, the conn is indexed by it when the
+// server indexes key.
 func (c *Conn) SetData(key string, value interface{}) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
-
 	c.extraData[key] = value
+	c.mu.Unlock()
+
+	if c.registry != nil {
+		c.registry.reindex(c, key)
+	}
 }
 
 // DelData ...
 func (c *Conn) DelData(key string) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
-
 	delete(c.extraData, key)
+	c.mu.Unlock()
+
+	if c.registry != nil {
+		c.registry.reindex(c, key)
+	}
 }
@@ -10,25 +10,32 @@ package wsrpc
 */
 
 import (
+	"encoding/json"
 	"fmt"
 	"html/template"
 	"net/http"
 	"sort"
+	"strings"
 )
 
 const debugText = `<html>
 	<body>
 	<title>Services</title>
-	{{range .}}
+	Conns {{.Conns}}, requests in flight {{.Requests.InFlight}}, queued {{.Requests.Queued}}, rejected {{.Requests.Rejected}}
+	{{range .Services}}
 	<hr>
 	Service {{.Name}}
 	<hr>
 		<table>
-		<th align=center>Method</th><th align=center>Calls</th>
+		<th align=center>Method</th><th align=center>Calls</th><th align=center>Errors</th><th align=center>In flight</th><th align=center>Mean</th><th align=center>Latency</th>
 		{{range .Method}}
 			<tr>
 			<td align=left font=fixed>{{.Name}}({{.Type.ArgType}}, {{.Type.ReplyType}}) error</td>
-			<td align=center>{{.Type.NumCalls}}</td>
+			<td align=center>{{.Stats.Calls}}</td>
+			<td align=center>{{.Stats.Errors}}</td>
+			<td align=center>{{.Stats.InFlight}}</td>
+			<td align=center>{{.Stats.Latency.Mean}}</td>
+			<td align=left font=fixed>{{range .Stats.Latency.Buckets}}{{if .Count}}&le;{{.Bound}}:{{.Count}} {{end}}{{end}}</td>
 			</tr>
 		{{end}}
 		</table>
@@ -42,20 +49,29 @@ var debug = template.Must(template.New("RPC debug").Parse(debugText))
 var debugLog = false
 
 type debugMethod struct {
-	Type *methodType
-	Name string
+	Type  *methodType `json:"-"`
+	Name  string      `json:"name"`
+	Arg   string      `json:"arg"`
+	Reply string      `json:"reply"`
+	Stats MethodStats `json:"stats"`
 }
 
 type methodArray []debugMethod
 
 type debugService struct {
-	Service *service
-	Name    string
-	Method  methodArray
+	Service *service    `json:"-"`
+	Name    string      `json:"name"`
+	Method  methodArray `json:"methods"`
 }
 
 type serviceArray []debugService
 
+type debugInfo struct {
+	Conns    int          `json:"conns"`
+	Requests Stats        `json:"requests"`
+	Services serviceArray `json:"services"`
+}
+
 func (s serviceArray) Len() int           { return len(s) }
 func (s serviceArray) Less(i, j int) bool { return s[i].Name < s[j].Name }
 func (s serviceArray) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -68,25 +84,59 @@ type debugHTTP struct {
 	*Server
 }
 
+// DebugHandler returns the handler of the debug page, it serves JSON
+// when the request has format=json or accepts application/json.
+func (server *Server) DebugHandler() http.Handler {
+	return debugHTTP{server}
+}
+
+// HandleDebugHTTP registers the debug handler on debugPath, usually
+// DefaultDebugPath, with http.DefaultServeMux.
+func (server *Server) HandleDebugHTTP(debugPath string) {
+	http.Handle(debugPath, server.DebugHandler())
+}
+
 // Runs at /debug/rpc
 func (server debugHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	// Build a sorted version of the data.
+	server.mu.RLock()
 	var services = make(serviceArray, len(server.serviceMap))
 	i := 0
-	server.mu.Lock()
 	for sname, service := range server.serviceMap {
 		services[i] = debugService{service, sname, make(methodArray, len(service.method))}
 		j := 0
 		for mname, method := range service.method {
-			services[i].Method[j] = debugMethod{method, mname}
+			services[i].Method[j] = debugMethod{
+				Type:  method,
+				Name:  mname,
+				Arg:   method.ArgType.String(),
+				Reply: method.ReplyType.String(),
+				Stats: method.stats.load(),
+			}
 			j++
 		}
 		sort.Sort(services[i].Method)
 		i++
 	}
-	server.mu.Unlock()
+	server.mu.RUnlock()
 	sort.Sort(services)
-	err := debug.Execute(w, services)
+
+	info := debugInfo{
+		Conns:    server.ConnCount(),
+		Requests: server.Stats(),
+		Services: services,
+	}
+
+	if req.URL.Query().Get("format") == "json" ||
+		strings.Contains(req.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(info); err != nil {
+			fmt.Fprintln(w, "rpc: error encoding json:", err.Error())
+		}
+		return
+	}
+
+	err := debug.Execute(w, info)
 	if err != nil {
 		fmt.Fprintln(w, "rpc: error executing template:", err.Error())
 	}
@@ -0,0 +1,170 @@
+package wsrpc
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestDebugStats(t *testing.T) {
+	server := NewServer()
+	if err := server.Register(new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	ts, url := newTestServer(t, server)
+	defer ts.Close()
+
+	client, err := Dial(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	for _, b := range []int{1, 2, 0} {
+		client.Call(context.Background(), "Arith.Divide", &ArithArgs{A: 4, B: b}, new(ArithQuotient))
+	}
+	client.Call(context.Background(), "Arith.Sleep", time.Millisecond*30, new(int))
+
+	stats := server.MethodStats()
+	divide := stats["Arith.Divide"]
+	if divide.Calls != 3 || divide.Errors != 1 || divide.InFlight != 0 {
+		t.Errorf("unexpected Arith.Divide stats %+v", divide)
+	}
+	sleep := stats["Arith.Sleep"].Latency
+	if sleep.Count != 1 || sleep.Mean() < time.Millisecond*30 {
+		t.Errorf("unexpected Arith.Sleep latency %+v", sleep)
+	}
+	for _, bucket := range sleep.Buckets {
+		expected := int64(0)
+		if bucket.Bound() >= time.Millisecond*50 {
+			expected = 1
+		}
+		if bucket.Count != expected {
+			t.Errorf("expected %d calls within %s got %d", expected, bucket.Bound(), bucket.Count)
+		}
+	}
+
+	debugTS := httptest.NewServer(server.DebugHandler())
+	defer debugTS.Close()
+
+	resp, err := http.Get(debugTS.URL + "?format=json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var info debugInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Conns != 1 || len(info.Services) != 1 || info.Services[0].Name != "Arith" {
+		t.Fatalf("unexpected debug info %+v", info)
+	}
+	for _, method := range info.Services[0].Method {
+		if method.Name == "Divide" && method.Stats.Calls != 3 {
+			t.Errorf("expected 3 calls of Divide got %d", method.Stats.Calls)
+		}
+	}
+
+	resp, err = http.Get(debugTS.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	page, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(page), "Divide") || strings.Contains(string(page), "error executing template") {
+		t.Errorf("unexpected debug page %s", page)
+	}
+}
+
+type Catalog int
+
+type Item struct {
+	ID    int64    `json:"id"`
+	Name  string   `json:"name,omitempty"`
+	Tags  []string `json:"tags"`
+	Price *float64 `json:"price"`
+	Next  *Item    `json:"next,omitempty"`
+	skip  bool
+}
+
+func (c *Catalog) Get(conn *Conn, id int64, reply *Item) error {
+	return nil
+}
+
+func TestDiscover(t *testing.T) {
+	server := NewServer()
+	if err := server.Register(new(Catalog)); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.RegisterDiscover(); err != nil {
+		t.Fatal(err)
+	}
+	ts, url := newTestServer(t, server)
+	defer ts.Close()
+
+	client, err := Dial(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var discovery struct {
+		Methods []struct {
+			Name   string                 `json:"name"`
+			Params map[string]interface{} `json:"params"`
+			Result map[string]interface{} `json:"result"`
+		} `json:"methods"`
+	}
+	if err := client.Call(context.Background(), "rpc.discover", []interface{}{}, &discovery); err != nil {
+		t.Fatal(err)
+	}
+	if len(discovery.Methods) != 1 || discovery.Methods[0].Name != "Catalog.Get" {
+		t.Fatalf("unexpected methods %+v", discovery.Methods)
+	}
+
+	// the usual discovery call leaves params out
+	ws := dialRaw(t, url)
+	defer ws.Close()
+	if err := ws.WriteMessage(websocket.TextMessage, []byte(`{"jsonrpc":"2.0","id":1,"method":"rpc.discover"}`)); err != nil {
+		t.Fatal(err)
+	}
+	var resp testResponse
+	if err := ws.ReadJSON(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Error != nil || !strings.Contains(string(resp.Result), `"Catalog.Get"`) {
+		t.Fatalf("unexpected response %s %s", resp.Result, resp.Error)
+	}
+
+	method := discovery.Methods[0]
+	if method.Params["type"] != "integer" {
+		t.Errorf("expected integer params got %v", method.Params)
+	}
+	properties, _ := method.Result["properties"].(map[string]interface{})
+	var names []string
+	for name := range properties {
+		names = append(names, name)
+	}
+	if len(names) != 5 {
+		t.Errorf("expected 5 properties got %v", names)
+	}
+	required, _ := method.Result["required"].([]interface{})
+	if !reflect.DeepEqual(required, []interface{}{"id", "tags"}) {
+		t.Errorf("expected id and tags to be required got %v", required)
+	}
+	next, _ := properties["next"].(map[string]interface{})
+	if next["type"] != "object" {
+		t.Errorf("expected recursive object got %v", next)
+	}
+}
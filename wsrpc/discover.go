@@ -0,0 +1,189 @@
+package wsrpc
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DiscoverService is the service name of the discover method.
+const DiscoverService = "rpc"
+
+var (
+	typeOfTime          = reflect.TypeOf(time.Time{})
+	typeOfDuration      = reflect.TypeOf(time.Duration(0))
+	typeOfRawMessage    = reflect.TypeOf(json.RawMessage{})
+	typeOfJSONMarshaler = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	typeOfTextMarshaler = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// Schema is a JSON schema.
+type Schema map[string]interface{}
+
+// MethodDescription describes a registered method with the JSON schemas
+// of its argument and reply.
+type MethodDescription struct {
+	Name   string `json:"name"`
+	Params Schema `json:"params"`
+	Result Schema `json:"result"`
+}
+
+// Discovery is the reply of rpc.discover.
+type Discovery struct {
+	Methods []MethodDescription `json:"methods"`
+}
+
+// Describe returns the registered methods sorted by name. The discover
+// method itself is left out.
+func (server *Server) Describe() []MethodDescription {
+	server.mu.RLock()
+	defer server.mu.RUnlock()
+
+	var methods []MethodDescription
+	for sname, service := range server.serviceMap {
+		if _, ok := service.rcvr.Interface().(*discoverService); ok {
+			continue
+		}
+		for mname, mtype := range service.method {
+			methods = append(methods, MethodDescription{
+				Name:   sname + "." + mname,
+				Params: jsonSchema(mtype.ArgType, nil),
+				Result: jsonSchema(mtype.ReplyType, nil),
+			})
+		}
+	}
+	sort.Slice(methods, func(i, j int) bool {
+		return methods[i].Name < methods[j].Name
+	})
+	return methods
+}
+
+type discoverService struct {
+	server *Server
+}
+
+func (d *discoverService) Discover(conn *Conn, args interface{}, reply *Discovery) error {
+	reply.Methods = d.server.Describe()
+	return nil
+}
+
+// RegisterDiscover registers the rpc.discover method, it replies with
+// the Discovery of the server. Params are ignored and may be left out.
+func (server *Server) RegisterDiscover(opts ...ServiceOption) error {
+	err := server.register(&discoverService{server: server}, DiscoverService, true, opts)
+	if err != nil {
+		return err
+	}
+
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	// lower case, as the method name is derived from the Go method
+	s := server.serviceMap[DiscoverService]
+	s.method["discover"] = s.method["Discover"]
+	s.method["discover"].noParams = true
+	delete(s.method, "Discover")
+	return nil
+}
+
+// jsonSchema derives the schema of the JSON encoding of t, seen holds
+// the struct types being described to break recursion.
+func jsonSchema(t reflect.Type, seen map[reflect.Type]bool) Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == typeOfTime:
+		return Schema{"type": "string", "format": "date-time"}
+	case t == typeOfRawMessage:
+		return Schema{}
+	case t.Implements(typeOfJSONMarshaler) || reflect.PtrTo(t).Implements(typeOfJSONMarshaler):
+		return Schema{}
+	case t.Implements(typeOfTextMarshaler) || reflect.PtrTo(t).Implements(typeOfTextMarshaler):
+		return Schema{"type": "string"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return Schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if t == typeOfDuration {
+			return Schema{"type": "integer", "description": "nanoseconds"}
+		}
+		return Schema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return Schema{"type": "number"}
+	case reflect.String:
+		return Schema{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			return Schema{"type": "string", "contentEncoding": "base64"}
+		}
+		return Schema{"type": "array", "items": jsonSchema(t.Elem(), seen)}
+	case reflect.Map:
+		return Schema{"type": "object", "additionalProperties": jsonSchema(t.Elem(), seen)}
+	case reflect.Struct:
+		if seen[t] {
+			// recursive type
+			return Schema{"type": "object"}
+		}
+		if seen == nil {
+			seen = make(map[reflect.Type]bool)
+		}
+		seen[t] = true
+		defer delete(seen, t)
+
+		properties := Schema{}
+		var required []string
+		structFields(t, seen, properties, &required)
+		schema := Schema{"type": "object", "properties": properties}
+		if len(required) != 0 {
+			schema["required"] = required
+		}
+		return schema
+	}
+	// interface{} and the kinds json can't encode
+	return Schema{}
+}
+
+// structFields adds the properties of the fields of t, embedded structs
+// without a json name are inlined as encoding/json does.
+func structFields(t reflect.Type, seen map[reflect.Type]bool, properties Schema, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if i := strings.Index(tag, ","); i >= 0 {
+			name, opts = tag[:i], tag[i+1:]
+		}
+
+		ft := field.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if field.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			structFields(ft, seen, properties, required)
+			continue
+		}
+		if field.PkgPath != "" {
+			// unexported
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		properties[name] = jsonSchema(field.Type, seen)
+		if !strings.Contains(opts, "omitempty") && field.Type.Kind() != reflect.Ptr {
+			*required = append(*required, name)
+		}
+	}
+}
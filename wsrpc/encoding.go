@@ -0,0 +1,58 @@
+package wsrpc
+
+import (
+	"io"
+	"sort"
+)
+
+// Encoding binds a ServerCodec to the websocket subprotocol a client
+// negotiates to use it.
+type Encoding struct {
+	// Subprotocol is the Sec-WebSocket-Protocol value selecting the codec.
+	Subprotocol string
+	// Binary writes the codec output in binary frames.
+	Binary   bool
+	NewCodec func(io.ReadWriteCloser) ServerCodec
+}
+
+// Encodings served out of the box, JSON is used when the client
+// negotiated no subprotocol.
+var (
+	JSONEncoding    = Encoding{Subprotocol: "jsonrpc", NewCodec: NewServerCodec}
+	MsgpackEncoding = Encoding{Subprotocol: "jsonrpc.msgpack", Binary: true, NewCodec: NewMsgpackServerCodec}
+	CBOREncoding    = Encoding{Subprotocol: "jsonrpc.cbor", Binary: true, NewCodec: NewCBORServerCodec}
+)
+
+// RegisterEncoding adds or replaces the encoding of a subprotocol.
+func (server *Server) RegisterEncoding(encoding Encoding) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	server.encodings[encoding.Subprotocol] = encoding
+}
+
+// Subprotocols returns the subprotocols of the registered encodings, for
+// websocket.Upgrader.Subprotocols.
+func (server *Server) Subprotocols() []string {
+	server.mu.RLock()
+	defer server.mu.RUnlock()
+
+	subprotocols := []string{JSONEncoding.Subprotocol}
+	for subprotocol := range server.encodings {
+		if subprotocol != JSONEncoding.Subprotocol {
+			subprotocols = append(subprotocols, subprotocol)
+		}
+	}
+	sort.Strings(subprotocols[1:])
+	return subprotocols
+}
+
+func (server *Server) encoding(subprotocol string) Encoding {
+	server.mu.RLock()
+	defer server.mu.RUnlock()
+
+	if encoding, ok := server.encodings[subprotocol]; ok {
+		return encoding
+	}
+	return JSONEncoding
+}
@@ -0,0 +1,85 @@
+package wsrpc
+
+import (
+	"errors"
+	"fmt"
+
+	terrors "github.com/hkjojo/go-toolkits/errors"
+)
+
+// Error codes defined by the JSON-RPC 2.0 specification.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+	// CodeServerError is used for handler errors that carry no code.
+	CodeServerError = -32000
+	// CodeServerBusy is used for requests beyond the in-flight limits.
+	CodeServerBusy = -32001
+	// CodeUnauthenticated is used for calls of a conn without principal
+	// to methods requiring roles.
+	CodeUnauthenticated = -32002
+	// CodePermissionDenied is used for calls of a principal lacking the
+	// roles of the method.
+	CodePermissionDenied = -32003
+	// CodeRateLimited is used for calls beyond the rate limit of the method.
+	CodeRateLimited = -32004
+)
+
+// Error is a JSON-RPC 2.0 error object. Handlers may return an *Error to
+// control the code and data of the error response.
+type Error struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// NewError ...
+func NewError(code int, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// WithData returns a copy of the error carrying data.
+func (e *Error) WithData(data interface{}) *Error {
+	return &Error{Code: e.Code, Message: e.Message, Data: data}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// String ...
+func (e *Error) String() string {
+	return fmt.Sprintf("code:%d message:%s", e.Code, e.Message)
+}
+
+// toError converts a handler error into a JSON-RPC error object. gRPC
+// status errors, including the marshaled form produced by the errors
+// package, keep their code and details.
+func toError(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+
+	if s, ok := terrors.Parse(err); ok && s.Code() != 0 {
+		e = &Error{Code: int(s.Code()), Message: s.Message()}
+		if details := s.Details(); len(details) != 0 {
+			e.Data = details
+		}
+		return e
+	}
+
+	return &Error{Code: CodeServerError, Message: err.Error()}
+}
+
+// ErrorCode returns the code of the error response sent for err, zero
+// for a nil err.
+func ErrorCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	return toError(err).Code
+}
@@ -0,0 +1,76 @@
+package wsrpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	terrors "github.com/hkjojo/go-toolkits/errors"
+)
+
+type Fail int
+
+func (f *Fail) Typed(conn *Conn, args int, reply *int) error {
+	return NewError(4001, "typed failure").WithData(map[string]int{"args": args})
+}
+
+func (f *Fail) Status(conn *Conn, args int, reply *int) error {
+	return terrors.DefaultErr.EscapePool(true).Add(1001, "status failure")
+}
+
+func (f *Fail) Marshaled(conn *Conn, args int, reply *int) error {
+	return terrors.From(0).BuiltIn(false).EscapePool(true).Add(1002, "marshaled failure")
+}
+
+func (f *Fail) Plain(conn *Conn, args int, reply *int) error {
+	return errors.New("plain failure")
+}
+
+func TestErrorObject(t *testing.T) {
+	server := NewServer()
+	if err := server.Register(new(Fail)); err != nil {
+		t.Fatal(err)
+	}
+	ts, url := newTestServer(t, server)
+	defer ts.Close()
+
+	client, err := Dial(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	cases := []struct {
+		method  string
+		args    interface{}
+		code    int
+		message string
+	}{
+		{"Fail.Typed", 7, 4001, "typed failure"},
+		{"Fail.Status", 0, 1001, "status failure"},
+		{"Fail.Marshaled", 0, 1002, "marshaled failure"},
+		{"Fail.Plain", 0, CodeServerError, "plain failure"},
+		{"Fail.Unknown", 0, CodeMethodNotFound, "rpc: can't find service method Fail.Unknown"},
+		{"Fail.Typed", "string", CodeInvalidParams, ""},
+	}
+	for _, c := range cases {
+		err := client.Call(context.Background(), c.method, c.args, new(int))
+		var e *Error
+		if !errors.As(err, &e) {
+			t.Errorf("%s: expected *Error got %#v", c.method, err)
+			continue
+		}
+		if e.Code != c.code {
+			t.Errorf("%s: expected code %d got %d", c.method, c.code, e.Code)
+		}
+		if c.message != "" && e.Message != c.message {
+			t.Errorf("%s: expected message %q got %q", c.method, c.message, e.Message)
+		}
+	}
+
+	err = client.Call(context.Background(), "Fail.Typed", 7, new(int))
+	data, _ := err.(*Error).Data.(map[string]interface{})
+	if data["args"] != float64(7) {
+		t.Errorf("expected data args 7 got %v", err.(*Error).Data)
+	}
+}
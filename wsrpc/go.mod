@@ -1,5 +1,20 @@
 module github.com/hkjojo/go-toolkits/wsrpc
 
-go 1.14
+go 1.20
 
-require github.com/gorilla/websocket v1.4.2
+require (
+	github.com/fxamacker/cbor/v2 v2.9.4
+	github.com/gorilla/websocket v1.4.2
+	github.com/hkjojo/go-toolkits/errors v0.0.0-00010101000000-000000000000
+	github.com/vmihailenco/msgpack/v5 v5.4.1
+)
+
+require (
+	github.com/golang/protobuf v1.3.2 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+	github.com/x448/float16 v0.8.4 // indirect
+	google.golang.org/genproto v0.0.0-20190819201941-24fa4b261c55 // indirect
+	google.golang.org/grpc v1.26.0 // indirect
+)
+
+replace github.com/hkjojo/go-toolkits/errors => ../errors
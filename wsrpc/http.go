@@ -0,0 +1,236 @@
+package wsrpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// httpLimitsSweep is how often the idle HTTP clients are forgotten.
+const httpLimitsSweep = time.Minute
+
+// ErrNotifyUnsupported is returned when notifying the conn of an HTTP
+// request, it has no way to push notifications.
+var ErrNotifyUnsupported = errors.New("rpc: notifications are not supported over http")
+
+// ServeHTTP serves JSON-RPC 2.0 over HTTP POST, a single request or a
+// batch per body. Requests go through the same services, missing method
+// handler and middlewares as the websocket conns, with a Conn carrying
+// the HTTP request. Notifications are ignored, a body holding only
+// notifications is answered with 204 No Content. The rate limits of the
+// methods apply per principal, or per remote address when the request
+// is not authenticated, rather than per conn.
+func (server *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "rpc: POST only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var principal *Principal
+	if server.authenticator != nil {
+		var err error
+		if principal, err = server.authenticator(r); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+	}
+
+	var opts connOptions
+	for _, opt := range server.connOpts {
+		opt(&opts)
+	}
+	body := r.Body
+	if opts.maxMessageSize > 0 {
+		body = http.MaxBytesReader(w, body, opts.maxMessageSize)
+	}
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	data, ok, err := dropNotifications(data)
+	if err != nil {
+		writeHTTP(w, &serverResponse{
+			ID:      &null,
+			Version: "2.0",
+			Error:   NewError(CodeParseError, "jsonrpc: "+err.Error()),
+		})
+		return
+	}
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if data[0] != '{' && data[0] != '[' {
+		writeHTTP(w, &serverResponse{
+			ID:      &null,
+			Version: "2.0",
+			Error:   errInvalidRequest,
+		})
+		return
+	}
+
+	var (
+		out     bytes.Buffer
+		sending = new(sync.Mutex)
+		codec   = NewServerCodec(&httpReadWriter{Reader: bytes.NewReader(data), w: &out})
+	)
+	if server.wrapCodec != nil {
+		codec = server.wrapCodec(codec)
+	}
+	conn := NewConn(r, sending, codec)
+	conn.principal = principal
+	conn.noNotify = true
+	conn.buckets = server.httpLimits.buckets(httpClient(r, principal), time.Now())
+	if server.limits.ConnInFlight > 0 {
+		conn.inFlight = make(chan struct{}, server.limits.ConnInFlight)
+	}
+
+	var invalid bool
+	for {
+		req, err := server.read(codec)
+		if err != nil {
+			// the body is valid JSON, so its fields failed to decode
+			invalid = err != io.EOF
+			server.freeRequest(req)
+			break
+		}
+		server.serveRequest(conn, sending, codec, req)
+	}
+	conn.calls.Wait()
+	conn.ternimating()
+
+	if invalid {
+		json.NewEncoder(&out).Encode(&serverResponse{
+			ID:      &null,
+			Version: "2.0",
+			Error:   errInvalidRequest,
+		})
+	}
+
+	if out.Len() == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out.Bytes())
+}
+
+// httpClient returns the key of the rate limit buckets of an HTTP
+// request, the id of its principal or else its remote address.
+func httpClient(r *http.Request, principal *Principal) string {
+	if principal != nil && principal.ID != "" {
+		return "principal:" + principal.ID
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "addr:" + host
+}
+
+// httpLimits keeps the rate limit buckets of the HTTP clients across
+// their requests, each request being served on a conn of its own.
+type httpLimits struct {
+	mu      sync.Mutex // protects clients, swept
+	clients map[string]*rateBuckets
+	swept   time.Time
+}
+
+// buckets returns the buckets of client. The clients whose buckets are
+// full again are forgotten once per httpLimitsSweep.
+func (l *httpLimits) buckets(client string, now time.Time) *rateBuckets {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.swept) >= httpLimitsSweep {
+		l.swept = now
+		for key, b := range l.clients {
+			if b.full(now) {
+				delete(l.clients, key)
+			}
+		}
+	}
+
+	b, ok := l.clients[client]
+	if !ok {
+		if l.clients == nil {
+			l.clients = make(map[string]*rateBuckets)
+		}
+		b = &rateBuckets{}
+		l.clients[client] = b
+	}
+	return b
+}
+
+func writeHTTP(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// httpReadWriter reads the body and buffers the responses of an HTTP
+// request, closing it is a no-op.
+type httpReadWriter struct {
+	io.Reader
+	w *bytes.Buffer
+}
+
+func (rw *httpReadWriter) Write(p []byte) (int, error) {
+	return rw.w.Write(p)
+}
+
+func (rw *httpReadWriter) Close() error {
+	return nil
+}
+
+// dropNotifications removes the notifications from a request or a batch,
+// it reports false when nothing is left to answer.
+func dropNotifications(data []byte) ([]byte, bool, error) {
+	data = bytes.TrimSpace(data)
+	if !json.Valid(data) {
+		return nil, false, errors.New("invalid json")
+	}
+	if len(data) == 0 || data[0] != '[' {
+		return data, !isNotification(data), nil
+	}
+
+	var elems []json.RawMessage
+	if err := json.Unmarshal(data, &elems); err != nil {
+		return nil, false, err
+	}
+	if len(elems) == 0 {
+		// answered with an invalid request error
+		return data, true, nil
+	}
+
+	requests := elems[:0]
+	for _, elem := range elems {
+		if !isNotification(elem) {
+			requests = append(requests, elem)
+		}
+	}
+	if len(requests) == 0 {
+		return nil, false, nil
+	}
+	data, err := json.Marshal(requests)
+	return data, err == nil, err
+}
+
+// isNotification reports whether raw is a request object without id.
+func isNotification(raw json.RawMessage) bool {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return false
+	}
+	_, hasID := fields["id"]
+	_, hasMethod := fields["method"]
+	return hasMethod && !hasID
+}
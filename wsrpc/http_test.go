@@ -0,0 +1,193 @@
+package wsrpc
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func postRPC(t *testing.T, url, body string) (*http.Response, []byte) {
+	t.Helper()
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, data
+}
+
+func TestServeHTTP(t *testing.T) {
+	var wrapped int32
+	server := NewServer()
+	server.Use(func(next ServiceHandler) ServiceHandler {
+		return func(conn *Conn, args *Args) (interface{}, error) {
+			atomic.AddInt32(&wrapped, 1)
+			if conn.Request == nil || conn.Request.Method != http.MethodPost {
+				t.Error("expected the conn to carry the http request")
+			}
+			return next(conn, args)
+		}
+	})
+	server.OnMissingMethod(func(conn *Conn, method string, params json.RawMessage) (interface{}, error) {
+		return method, nil
+	})
+	if err := server.Register(new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	resp, data := postRPC(t, ts.URL, `{"jsonrpc":"2.0","id":1,"method":"Arith.Multiply","params":[{"A":7,"B":8}]}`)
+	var single testResponse
+	if err := json.Unmarshal(data, &single); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Get("Content-Type") != "application/json" || string(single.Result) != "56" {
+		t.Errorf("unexpected response %s", data)
+	}
+
+	_, data = postRPC(t, ts.URL, `[
+		{"jsonrpc":"2.0","id":1,"method":"Arith.Multiply","params":[{"A":2,"B":3}]},
+		{"jsonrpc":"2.0","method":"Arith.Multiply","params":[{"A":1,"B":1}]},
+		{"jsonrpc":"2.0","id":2,"method":"Backend.Call","params":[]},
+		{"jsonrpc":"2.0","id":3,"method":"Arith.Echo","params":["hi"]}
+	]`)
+	var batch []testResponse
+	if err := json.Unmarshal(data, &batch); err != nil {
+		t.Fatal(err)
+	}
+	if len(batch) != 3 {
+		t.Fatalf("expected 3 responses got %s", data)
+	}
+	if string(batch[0].Result) != "6" || string(batch[1].Result) != `"Backend.Call"` {
+		t.Errorf("unexpected batch %s", data)
+	}
+	if !strings.Contains(string(batch[2].Error), "not supported over http") {
+		t.Errorf("expected notify to fail got %s", data)
+	}
+	if n := atomic.LoadInt32(&wrapped); n != 4 {
+		t.Errorf("expected 4 wrapped calls, the notification ignored, got %d", n)
+	}
+
+	resp, data = postRPC(t, ts.URL, `{"jsonrpc":"2.0","method":"Arith.Multiply","params":[{"A":1,"B":1}]}`)
+	if resp.StatusCode != http.StatusNoContent || len(data) != 0 {
+		t.Errorf("expected no content for a notification got %d %s", resp.StatusCode, data)
+	}
+
+	_, data = postRPC(t, ts.URL, `{"jsonrpc":"2.0","id":1,`)
+	var parseError struct {
+		Error *Error `json:"error"`
+	}
+	if err := json.Unmarshal(data, &parseError); err != nil {
+		t.Fatal(err)
+	}
+	if parseError.Error == nil || parseError.Error.Code != CodeParseError {
+		t.Errorf("expected parse error got %s", data)
+	}
+
+	for _, body := range []string{`"foo"`, `1`, `null`, `{"jsonrpc":"2.0","id":1,"method":5}`} {
+		_, data = postRPC(t, ts.URL, body)
+		var invalid struct {
+			ID    json.RawMessage `json:"id"`
+			Error *Error          `json:"error"`
+		}
+		if err := json.Unmarshal(data, &invalid); err != nil {
+			t.Fatal(err)
+		}
+		if invalid.Error == nil || invalid.Error.Code != CodeInvalidRequest || string(invalid.ID) != "null" {
+			t.Errorf("expected invalid request for %s got %s", body, data)
+		}
+	}
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("expected method not allowed got %d", resp.StatusCode)
+	}
+}
+
+func TestServeHTTPRateLimit(t *testing.T) {
+	server := NewServer()
+	server.SetAuthenticator(tokenAuthenticator)
+	err := server.Register(new(Vault),
+		WithServiceRoles("admin", "viewer"),
+		WithMethod("Count", WithRoles(), WithRateLimit(0.01, 2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(server)
+	defer ts.Close()
+
+	count := func(token string) int {
+		req, err := http.NewRequest(http.MethodPost, ts.URL,
+			strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"Vault.Count","params":[0]}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		var res struct {
+			Error *Error `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		if res.Error == nil {
+			return 0
+		}
+		return res.Error.Code
+	}
+
+	// every request has a conn of its own, the buckets are kept by client
+	for _, token := range []string{"", "admin"} {
+		for i := 0; i < 2; i++ {
+			if code := count(token); code != 0 {
+				t.Fatalf("call %d of %q failed with code %d", i, token, code)
+			}
+		}
+		if code := count(token); code != CodeRateLimited {
+			t.Errorf("expected %q to be rate limited got code %d", token, code)
+		}
+	}
+}
+
+func TestHTTPLimitsSweep(t *testing.T) {
+	var (
+		limits httpLimits
+		mtype  = &methodType{rateLimit: &RateLimit{Rate: 1, Burst: 1}}
+		now    = time.Now()
+	)
+	if !limits.buckets("a", now).get(mtype).allow(now) {
+		t.Fatal("expected the first call to be allowed")
+	}
+	if limits.buckets("a", now).get(mtype).allow(now) {
+		t.Fatal("expected the bucket to be kept across requests")
+	}
+
+	// a bucket refilled after a second is forgotten by the next sweep
+	later := now.Add(httpLimitsSweep)
+	limits.buckets("b", later)
+	if _, ok := limits.clients["a"]; ok || len(limits.clients) != 1 {
+		t.Errorf("expected a to be forgotten got %v", limits.clients)
+	}
+}
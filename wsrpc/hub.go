@@ -0,0 +1,209 @@
+package wsrpc
+
+import (
+	"sync"
+)
+
+// EvictHandler is called when a slow conn is evicted from a Hub.
+type EvictHandler func(conn *Conn, err error)
+
+// HubOption ...
+type HubOption func(*Hub)
+
+// WithEvictHandler sets the handler called after a conn was evicted
+// because its Notifier could not take more notifications, it may for
+// instance close the conn. Only the DropNewest and Disconnect overflow
+// policies report a full queue.
+func WithEvictHandler(handler EvictHandler) HubOption {
+	return func(h *Hub) {
+		h.onEvict = handler
+	}
+}
+
+// WithNotifierOptions sets the options of the Notifier the hub creates
+// for every subscribed conn.
+func WithNotifierOptions(opts ...NotifierOption) HubOption {
+	return func(h *Hub) {
+		h.notifierOpts = opts
+	}
+}
+
+type subscriber struct {
+	conn     *Conn
+	notifier *Notifier
+	topics   map[string]struct{}
+}
+
+// Hub fans out notifications of named topics to the conns subscribed
+// to them. The notification method is the topic name.
+type Hub struct {
+	onEvict      EvictHandler
+	notifierOpts []NotifierOption
+
+	mu     sync.RWMutex // protects topics, conns, hooked
+	topics map[string]map[*Conn]*subscriber
+	conns  map[*Conn]*subscriber
+	hooked map[*Conn]struct{} // conns with a close handler, kept when evicted
+}
+
+// NewHub ...
+func NewHub(opts ...HubOption) *Hub {
+	h := &Hub{
+		topics: make(map[string]map[*Conn]*subscriber),
+		conns:  make(map[*Conn]*subscriber),
+		hooked: make(map[*Conn]struct{}),
+	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+// Subscribe adds conn to the subscribers of topics. The conn is
+// unsubscribed from every topic once it closes.
+func (h *Hub) Subscribe(conn *Conn, topics ...string) {
+	h.mu.Lock()
+	sub, ok := h.conns[conn]
+	if !ok {
+		sub = &subscriber{
+			conn:     conn,
+			notifier: newNotifier(conn, h.notifierOpts...),
+			topics:   make(map[string]struct{}),
+		}
+		h.conns[conn] = sub
+	}
+	for _, topic := range topics {
+		sub.topics[topic] = struct{}{}
+		if h.topics[topic] == nil {
+			h.topics[topic] = make(map[*Conn]*subscriber)
+		}
+		h.topics[topic][conn] = sub
+	}
+	_, hooked := h.hooked[conn]
+	h.hooked[conn] = struct{}{}
+	h.mu.Unlock()
+
+	if !hooked {
+		conn.OnClose(func() {
+			h.closed(conn)
+		})
+	}
+}
+
+// Unsubscribe removes conn from the subscribers of topics.
+func (h *Hub) Unsubscribe(conn *Conn, topics ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	sub, ok := h.conns[conn]
+	if !ok {
+		return
+	}
+	for _, topic := range topics {
+		delete(sub.topics, topic)
+		h.unsubscribeLocked(topic, conn)
+	}
+}
+
+// Publish notifies every subscriber of topic with payload and returns
+// the number of conns it was queued for.
+func (h *Hub) Publish(topic string, payload interface{}) int {
+	return h.publish(topic, payload, false)
+}
+
+// PublishArr is like Publish but sends payload wrapped in an array,
+// as Notifier.NotifyArr does.
+func (h *Hub) PublishArr(topic string, payload interface{}) int {
+	return h.publish(topic, payload, true)
+}
+
+func (h *Hub) publish(topic string, payload interface{}, isArr bool) int {
+	h.mu.RLock()
+	subs := make([]*subscriber, 0, len(h.topics[topic]))
+	for _, sub := range h.topics[topic] {
+		subs = append(subs, sub)
+	}
+	h.mu.RUnlock()
+
+	var sent int
+	for _, sub := range subs {
+		var err error
+		if isArr {
+			err = sub.notifier.NotifyArr(topic, payload)
+		} else {
+			err = sub.notifier.Notify(topic, payload)
+		}
+		if err != nil {
+			h.evict(sub, err)
+			continue
+		}
+		sent++
+	}
+	return sent
+}
+
+// Subscribers returns the number of conns subscribed to topic.
+func (h *Hub) Subscribers(topic string) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return len(h.topics[topic])
+}
+
+// Topics returns the subscriber count of every topic.
+func (h *Hub) Topics() map[string]int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	topics := make(map[string]int, len(h.topics))
+	for topic, subs := range h.topics {
+		topics[topic] = len(subs)
+	}
+	return topics
+}
+
+func (h *Hub) evict(sub *subscriber, err error) {
+	if !h.remove(sub.conn) {
+		// already evicted by a concurrent publish
+		return
+	}
+	if h.onEvict != nil {
+		h.onEvict(sub.conn, err)
+	}
+}
+
+// closed drops conn once it closes. The close handler is forgotten
+// first, so a Subscribe racing with it registers a new one.
+func (h *Hub) closed(conn *Conn) {
+	h.mu.Lock()
+	delete(h.hooked, conn)
+	h.mu.Unlock()
+
+	h.remove(conn)
+}
+
+// remove drops every subscription of conn, it reports whether conn
+// was subscribed.
+func (h *Hub) remove(conn *Conn) bool {
+	h.mu.Lock()
+	sub, ok := h.conns[conn]
+	if ok {
+		delete(h.conns, conn)
+		for topic := range sub.topics {
+			h.unsubscribeLocked(topic, conn)
+		}
+	}
+	h.mu.Unlock()
+
+	if ok {
+		sub.notifier.Close()
+	}
+	return ok
+}
+
+func (h *Hub) unsubscribeLocked(topic string, conn *Conn) {
+	delete(h.topics[topic], conn)
+	if len(h.topics[topic]) == 0 {
+		delete(h.topics, topic)
+	}
+}
@@ -0,0 +1,107 @@
+package wsrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type Topics struct {
+	hub *Hub
+}
+
+func (t *Topics) Join(conn *Conn, topic string, reply *int) error {
+	t.hub.Subscribe(conn, topic)
+	*reply = t.hub.Subscribers(topic)
+	return nil
+}
+
+func (t *Topics) Leave(conn *Conn, topic string, reply *int) error {
+	t.hub.Unsubscribe(conn, topic)
+	*reply = t.hub.Subscribers(topic)
+	return nil
+}
+
+func TestHubPublish(t *testing.T) {
+	hub := NewHub()
+	server := NewServer()
+	if err := server.Register(&Topics{hub: hub}); err != nil {
+		t.Fatal(err)
+	}
+	ts, url := newTestServer(t, server)
+	defer ts.Close()
+
+	received := make(chan string, 10)
+	clients := make([]*Client, 3)
+	for i := range clients {
+		client, err := Dial(context.Background(), url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		client.Subscribe("ticks", func(method string, params json.RawMessage) {
+			var s string
+			json.Unmarshal(params, &s)
+			received <- s
+		})
+		if err := client.Call(context.Background(), "Topics.Join", "ticks", new(int)); err != nil {
+			t.Fatal(err)
+		}
+		clients[i] = client
+	}
+
+	if n := hub.Subscribers("ticks"); n != 3 {
+		t.Fatalf("expected 3 subscribers got %d", n)
+	}
+	if n := hub.Publish("ticks", "tick"); n != 3 {
+		t.Errorf("expected publish to 3 conns got %d", n)
+	}
+	for i := 0; i < 3; i++ {
+		select {
+		case s := <-received:
+			if s != "tick" {
+				t.Errorf("expected tick got %s", s)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("notification not received")
+		}
+	}
+
+	var left int
+	if err := clients[0].Call(context.Background(), "Topics.Leave", "ticks", &left); err != nil {
+		t.Fatal(err)
+	}
+	if left != 2 {
+		t.Errorf("expected 2 subscribers after leave got %d", left)
+	}
+
+	clients[1].Close()
+	time.Sleep(50 * time.Millisecond)
+	if topics := hub.Topics(); topics["ticks"] != 1 {
+		t.Errorf("expected 1 subscriber after close got %v", topics)
+	}
+}
+
+func TestHubCloseHandlers(t *testing.T) {
+	hub := NewHub()
+	conn := NewConn(nil, new(sync.Mutex), nil)
+
+	hub.Subscribe(conn, "ticks")
+	hub.evict(hub.conns[conn], errors.New("full"))
+	hub.Subscribe(conn, "ticks")
+	hub.Subscribe(conn, "quotes")
+	if n := len(conn.closeHandlers); n != 1 {
+		t.Fatalf("expected 1 close handler after resubscribing got %d", n)
+	}
+
+	conn.ternimating()
+	if topics := hub.Topics(); len(topics) != 0 {
+		t.Errorf("expected no topics after close got %v", topics)
+	}
+	if len(hub.conns) != 0 || len(hub.hooked) != 0 {
+		t.Errorf("expected the conn to be forgotten")
+	}
+}
@@ -0,0 +1,156 @@
+package wsrpc
+
+import (
+	"sync/atomic"
+)
+
+var errServerBusy = NewError(CodeServerBusy, "rpc: server busy")
+
+// Limits bounds the number of requests handled concurrently. A zero
+// value means no limit.
+type Limits struct {
+	// ConnInFlight is the max number of requests handled at once per conn.
+	ConnInFlight int
+	// ServerInFlight is the max number of requests handled at once by the server.
+	ServerInFlight int
+	// QueueSize is the max number of requests per conn waiting for a free
+	// slot. Requests beyond it are answered with CodeServerBusy right away,
+	// so zero rejects every request that exceeds the in-flight limits.
+	QueueSize int
+}
+
+// Stats reports the requests being handled and waiting for a slot.
+type Stats struct {
+	InFlight int64 `json:"in_flight"` // requests being handled
+	Queued   int64 `json:"queued"`    // requests waiting for a slot
+	Rejected int64 `json:"rejected"`  // requests answered with CodeServerBusy
+}
+
+type stats struct {
+	inFlight int64
+	queued   int64
+	rejected int64
+}
+
+func (s *stats) load() Stats {
+	return Stats{
+		InFlight: atomic.LoadInt64(&s.inFlight),
+		Queued:   atomic.LoadInt64(&s.queued),
+		Rejected: atomic.LoadInt64(&s.rejected),
+	}
+}
+
+// SetLimits sets the concurrency limits, it must be called before the
+// server starts serving conns.
+func (server *Server) SetLimits(limits Limits) {
+	server.limits = limits
+	server.inFlight = nil
+	if limits.ServerInFlight > 0 {
+		server.inFlight = make(chan struct{}, limits.ServerInFlight)
+	}
+}
+
+// Stats returns the server wide request stats.
+func (server *Server) Stats() Stats {
+	return server.stats.load()
+}
+
+// dispatch runs handle on a new goroutine once the request got a slot.
+// It reports false when the request has to be rejected as busy.
+func (server *Server) dispatch(conn *Conn, handle func()) bool {
+	if server.acquire(conn) {
+		conn.calls.Add(1)
+		go func() {
+			defer conn.calls.Done()
+			defer server.release(conn)
+			handle()
+		}()
+		return true
+	}
+
+	if atomic.LoadInt64(&conn.stats.queued) >= int64(server.limits.QueueSize) {
+		atomic.AddInt64(&conn.stats.rejected, 1)
+		atomic.AddInt64(&server.stats.rejected, 1)
+		return false
+	}
+
+	atomic.AddInt64(&conn.stats.queued, 1)
+	atomic.AddInt64(&server.stats.queued, 1)
+	conn.calls.Add(1)
+	go func() {
+		defer conn.calls.Done()
+		ok := server.wait(conn)
+		atomic.AddInt64(&conn.stats.queued, -1)
+		atomic.AddInt64(&server.stats.queued, -1)
+		if !ok {
+			// the conn terminated while the request was waiting
+			return
+		}
+		defer server.release(conn)
+		handle()
+	}()
+	return true
+}
+
+// acquire takes a conn and a server slot without blocking.
+func (server *Server) acquire(conn *Conn) bool {
+	if conn.inFlight != nil {
+		select {
+		case conn.inFlight <- struct{}{}:
+		default:
+			return false
+		}
+	}
+	if server.inFlight != nil {
+		select {
+		case server.inFlight <- struct{}{}:
+		default:
+			if conn.inFlight != nil {
+				<-conn.inFlight
+			}
+			return false
+		}
+	}
+	server.inc(conn)
+	return true
+}
+
+// wait blocks until it takes a conn and a server slot or the conn terminates.
+func (server *Server) wait(conn *Conn) bool {
+	done := conn.Context().Done()
+	if conn.inFlight != nil {
+		select {
+		case conn.inFlight <- struct{}{}:
+		case <-done:
+			return false
+		}
+	}
+	if server.inFlight != nil {
+		select {
+		case server.inFlight <- struct{}{}:
+		case <-done:
+			if conn.inFlight != nil {
+				<-conn.inFlight
+			}
+			return false
+		}
+	}
+	server.inc(conn)
+	return true
+}
+
+func (server *Server) inc(conn *Conn) {
+	atomic.AddInt64(&conn.stats.inFlight, 1)
+	atomic.AddInt64(&server.stats.inFlight, 1)
+}
+
+func (server *Server) release(conn *Conn) {
+	atomic.AddInt64(&conn.stats.inFlight, -1)
+	atomic.AddInt64(&server.stats.inFlight, -1)
+	if server.inFlight != nil {
+		<-server.inFlight
+	}
+	if conn.inFlight != nil {
+		<-conn.inFlight
+	}
+}
@@ -0,0 +1,74 @@
+package wsrpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newLimitedClient(t *testing.T, limits Limits) (*Server, *Client, func()) {
+	server := NewServer()
+	server.SetLimits(limits)
+	if err := server.Register(new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	ts, url := newTestServer(t, server)
+
+	client, err := Dial(context.Background(), url)
+	if err != nil {
+		ts.Close()
+		t.Fatal(err)
+	}
+	return server, client, func() {
+		client.Close()
+		ts.Close()
+	}
+}
+
+func TestLimitServerBusy(t *testing.T) {
+	server, client, closer := newLimitedClient(t, Limits{ConnInFlight: 1})
+	defer closer()
+
+	first := client.Go("Arith.Sleep", 100*time.Millisecond, new(int), nil)
+	time.Sleep(20 * time.Millisecond)
+
+	err := client.Call(context.Background(), "Arith.Sleep", time.Duration(0), new(int))
+	var e *Error
+	if !errors.As(err, &e) || e.Code != CodeServerBusy {
+		t.Errorf("expected server busy got %v", err)
+	}
+	if call := <-first.Done; call.Error != nil {
+		t.Fatal(call.Error)
+	}
+	if stats := server.Stats(); stats.Rejected != 1 {
+		t.Errorf("expected 1 rejected got %d", stats.Rejected)
+	}
+}
+
+func TestLimitQueue(t *testing.T) {
+	server, client, closer := newLimitedClient(t, Limits{ServerInFlight: 1, QueueSize: 2})
+	defer closer()
+
+	done := make(chan *Call, 3)
+	for i := 0; i < 3; i++ {
+		client.Go("Arith.Sleep", 50*time.Millisecond, new(int), done)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	stats := server.Stats()
+	if stats.InFlight != 1 || stats.Queued != 2 {
+		t.Errorf("expected 1 in flight and 2 queued got %+v", stats)
+	}
+
+	for i := 0; i < 3; i++ {
+		if call := <-done; call.Error != nil {
+			t.Fatal(call.Error)
+		}
+	}
+	// slots are released right after the responses are written
+	time.Sleep(10 * time.Millisecond)
+	if stats := server.Stats(); stats.InFlight != 0 || stats.Queued != 0 {
+		t.Errorf("expected no pending request got %+v", stats)
+	}
+}
@@ -11,3 +11,13 @@ type log struct{}
 func (l *log) Errorf(template string, args ...interface{}) {
 	fmt.Printf(template, args...)
 }
+
+func (l *log) Infof(template string, args ...interface{}) {
+	fmt.Printf(template, args...)
+}
+
+// infoLogger is implemented by loggers that also log at info level,
+// AccessLog uses it for successful calls.
+type infoLogger interface {
+	Infof(template string, args ...interface{})
+}
@@ -0,0 +1,121 @@
+package wsrpc
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets are the upper bounds of the latency histogram buckets.
+var latencyBuckets = [...]time.Duration{
+	time.Millisecond,
+	time.Millisecond * 5,
+	time.Millisecond * 10,
+	time.Millisecond * 25,
+	time.Millisecond * 50,
+	time.Millisecond * 100,
+	time.Millisecond * 250,
+	time.Millisecond * 500,
+	time.Second,
+	time.Millisecond * 2500,
+	time.Second * 5,
+	time.Second * 10,
+}
+
+// MethodStats reports the calls of a method.
+type MethodStats struct {
+	Calls    int64     `json:"calls"`
+	Errors   int64     `json:"errors"`
+	InFlight int64     `json:"in_flight"`
+	Latency  Histogram `json:"latency"`
+}
+
+// Histogram is a cumulative latency histogram, the +Inf bucket is Count.
+type Histogram struct {
+	Buckets []Bucket `json:"buckets"`
+	Count   int64    `json:"count"`
+	Sum     float64  `json:"sum"` // seconds
+}
+
+// Mean returns the mean latency.
+func (h Histogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return time.Duration(h.Sum / float64(h.Count) * float64(time.Second))
+}
+
+// Bucket counts the calls that took at most LE seconds.
+type Bucket struct {
+	LE    float64 `json:"le"`
+	Count int64   `json:"count"`
+}
+
+// Bound returns the upper bound of the bucket.
+func (b Bucket) Bound() time.Duration {
+	return time.Duration(b.LE * float64(time.Second))
+}
+
+// methodStats is updated atomically, keep it 64-bit aligned.
+type methodStats struct {
+	calls    int64
+	errors   int64
+	inFlight int64
+	sum      int64 // nanoseconds
+	buckets  [len(latencyBuckets) + 1]int64
+}
+
+func (s *methodStats) start() time.Time {
+	atomic.AddInt64(&s.inFlight, 1)
+	return time.Now()
+}
+
+func (s *methodStats) end(start time.Time, err error) {
+	elapsed := time.Since(start)
+	atomic.AddInt64(&s.inFlight, -1)
+	atomic.AddInt64(&s.calls, 1)
+	if err != nil {
+		atomic.AddInt64(&s.errors, 1)
+	}
+	atomic.AddInt64(&s.sum, int64(elapsed))
+
+	i := 0
+	for i < len(latencyBuckets) && elapsed > latencyBuckets[i] {
+		i++
+	}
+	atomic.AddInt64(&s.buckets[i], 1)
+}
+
+func (s *methodStats) load() MethodStats {
+	stats := MethodStats{
+		Calls:    atomic.LoadInt64(&s.calls),
+		Errors:   atomic.LoadInt64(&s.errors),
+		InFlight: atomic.LoadInt64(&s.inFlight),
+		Latency: Histogram{
+			Buckets: make([]Bucket, len(latencyBuckets)),
+			Sum:     time.Duration(atomic.LoadInt64(&s.sum)).Seconds(),
+		},
+	}
+
+	var count int64
+	for i, bound := range latencyBuckets {
+		count += atomic.LoadInt64(&s.buckets[i])
+		stats.Latency.Buckets[i] = Bucket{LE: bound.Seconds(), Count: count}
+	}
+	stats.Latency.Count = count + atomic.LoadInt64(&s.buckets[len(latencyBuckets)])
+	return stats
+}
+
+// MethodStats returns the stats of every registered method by
+// "Service.Method" name.
+func (server *Server) MethodStats() map[string]MethodStats {
+	server.mu.RLock()
+	defer server.mu.RUnlock()
+
+	stats := make(map[string]MethodStats)
+	for sname, service := range server.serviceMap {
+		for mname, mtype := range service.method {
+			stats[sname+"."+mname] = mtype.stats.load()
+		}
+	}
+	return stats
+}
@@ -0,0 +1,64 @@
+package wsrpc
+
+import (
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// Recovery turns a panic of the handler into a CodeInternalError
+// response and logs the stack through logger.
+func Recovery(logger Logger) WrapHandler {
+	return func(next ServiceHandler) ServiceHandler {
+		return func(conn *Conn, args *Args) (reply interface{}, err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					logger.Errorf("rpc: panic in %s:%v\n%s\n", args.Method, r, stack())
+					reply = nil
+					err = NewError(CodeInternalError, fmt.Sprintf("rpc: internal error in %s", args.Method))
+				}
+			}()
+			return next(conn, args)
+		}
+	}
+}
+
+// AccessLog logs every call with its duration. Failed calls are logged
+// with Errorf, successful ones with Infof when logger implements it.
+func AccessLog(logger Logger) WrapHandler {
+	info, _ := logger.(infoLogger)
+	return func(next ServiceHandler) ServiceHandler {
+		return func(conn *Conn, args *Args) (interface{}, error) {
+			start := time.Now()
+			reply, err := next(conn, args)
+			elapsed := time.Since(start)
+			switch {
+			case err != nil:
+				logger.Errorf("rpc: method:%s duration:%s error:%s\n", args.Method, elapsed, err)
+			case info != nil:
+				info.Infof("rpc: method:%s duration:%s\n", args.Method, elapsed)
+			}
+			return reply, err
+		}
+	}
+}
+
+// DurationFunc receives the duration and the result of a call.
+type DurationFunc func(method string, elapsed time.Duration, err error)
+
+// Duration reports the duration of every call to observe.
+func Duration(observe DurationFunc) WrapHandler {
+	return func(next ServiceHandler) ServiceHandler {
+		return func(conn *Conn, args *Args) (interface{}, error) {
+			start := time.Now()
+			reply, err := next(conn, args)
+			observe(args.Method, time.Since(start), err)
+			return reply, err
+		}
+	}
+}
+
+func stack() []byte {
+	buf := make([]byte, 64<<10)
+	return buf[:runtime.Stack(buf, false)]
+}
@@ -0,0 +1,162 @@
+package wsrpc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type Panicker int
+
+func (p *Panicker) Panic(conn *Conn, args int, reply *int) error {
+	panic("boom")
+}
+
+func (p *Panicker) Fine(conn *Conn, args int, reply *int) error {
+	*reply = args
+	return nil
+}
+
+type recordLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *recordLogger) Errorf(template string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, "error:"+template)
+}
+
+func (l *recordLogger) Infof(template string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, "info:"+template)
+}
+
+func TestMiddlewareChain(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		order []string
+	)
+	record := func(name string) WrapHandler {
+		return func(next ServiceHandler) ServiceHandler {
+			return func(conn *Conn, args *Args) (interface{}, error) {
+				mu.Lock()
+				order = append(order, name)
+				mu.Unlock()
+				return next(conn, args)
+			}
+		}
+	}
+
+	var observed []string
+	server := NewServer()
+	server.Use(record("server1"), record("server2"))
+	server.Use(Duration(func(method string, elapsed time.Duration, err error) {
+		observed = append(observed, method)
+	}))
+	err := server.Register(new(Panicker),
+		WithServiceMiddleware(record("service")),
+		WithMethod("Fine", WithMiddleware(record("method"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, url := newTestServer(t, server)
+	defer ts.Close()
+
+	client, err := Dial(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var reply int
+	if err := client.Call(context.Background(), "Panicker.Fine", 3, &reply); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "server1,server2,service,method"
+	if got := strings.Join(order, ","); got != expected {
+		t.Errorf("expected order %s got %s", expected, got)
+	}
+	if len(observed) != 1 || observed[0] != "Panicker.Fine" {
+		t.Errorf("expected duration of Panicker.Fine got %v", observed)
+	}
+}
+
+func TestMiddlewareMissingMethod(t *testing.T) {
+	logger := &recordLogger{}
+	var observed []string
+	server := NewServer()
+	server.Use(Recovery(logger), Duration(func(method string, elapsed time.Duration, err error) {
+		observed = append(observed, method)
+	}))
+	server.OnMissingMethod(func(conn *Conn, method string, params json.RawMessage) (interface{}, error) {
+		if method == "Backend.Panic" {
+			panic("boom")
+		}
+		return method, nil
+	})
+	ts, url := newTestServer(t, server)
+	defer ts.Close()
+
+	client, err := Dial(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var reply string
+	if err := client.Call(context.Background(), "Backend.Call", 1, &reply); err != nil || reply != "Backend.Call" {
+		t.Fatalf("expected Backend.Call got %q %v", reply, err)
+	}
+	err = client.Call(context.Background(), "Backend.Panic", 1, &reply)
+	var e *Error
+	if !errors.As(err, &e) || e.Code != CodeInternalError {
+		t.Errorf("expected internal error got %v", err)
+	}
+	if strings.Join(observed, ",") != "Backend.Call" {
+		t.Errorf("expected duration of Backend.Call got %v", observed)
+	}
+}
+
+func TestMiddlewareRecovery(t *testing.T) {
+	logger := &recordLogger{}
+	server := NewServer()
+	server.Use(AccessLog(logger), Recovery(logger))
+	if err := server.Register(new(Panicker)); err != nil {
+		t.Fatal(err)
+	}
+	ts, url := newTestServer(t, server)
+	defer ts.Close()
+
+	client, err := Dial(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	err = client.Call(context.Background(), "Panicker.Panic", 0, new(int))
+	var e *Error
+	if !errors.As(err, &e) || e.Code != CodeInternalError {
+		t.Errorf("expected internal error got %v", err)
+	}
+
+	if err := client.Call(context.Background(), "Panicker.Fine", 1, new(int)); err != nil {
+		t.Fatal(err)
+	}
+
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	if len(logger.lines) != 3 {
+		t.Fatalf("expected panic, error and info lines got %v", logger.lines)
+	}
+	if !strings.HasPrefix(logger.lines[2], "info:") {
+		t.Errorf("expected info line for successful call got %s", logger.lines[2])
+	}
+}
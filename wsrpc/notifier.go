@@ -3,34 +3,111 @@ package wsrpc
 import (
 	"errors"
 	"sync"
+	"sync/atomic"
 )
 
+var (
+	// ErrNotifierClosed is returned when notifying through a closed Notifier.
+	ErrNotifierClosed = errors.New("notifier closed")
+	// ErrNotifierFull is returned when the queue of the Notifier is full
+	// and the overflow policy drops the new notification or disconnects.
+	ErrNotifierFull = errors.New("notifier queue is full")
+)
+
+// OverflowPolicy decides what a Notifier does with a notification that
+// does not fit in its queue.
+type OverflowPolicy int
+
+const (
+	// DropNewest rejects the new notification with ErrNotifierFull.
+	DropNewest OverflowPolicy = iota
+	// DropOldest drops the oldest queued notification to make room.
+	DropOldest
+	// Coalesce replaces a queued notification of the same method with
+	// the new one, so only the latest payload per method is sent. It does
+	// so even before the queue is full; when the queue is full of other
+	// methods the oldest notification is dropped.
+	Coalesce
+	// Disconnect closes the conn and returns ErrNotifierFull.
+	Disconnect
+)
+
+const defaultNotifierBuffer = 1e3
+
+type notifierOptions struct {
+	bufferSize int
+	policy     OverflowPolicy
+}
+
+// NotifierOption ...
+type NotifierOption func(*notifierOptions)
+
+// WithBufferSize sets the max number of queued notifications, default 1000.
+func WithBufferSize(size int) NotifierOption {
+	return func(o *notifierOptions) {
+		if size > 0 {
+			o.bufferSize = size
+		}
+	}
+}
+
+// WithOverflowPolicy sets the policy applied when the queue is full,
+// default DropNewest.
+func WithOverflowPolicy(policy OverflowPolicy) NotifierOption {
+	return func(o *notifierOptions) {
+		o.policy = policy
+	}
+}
+
+// NotifierStats ...
+type NotifierStats struct {
+	Queued  int    // notifications waiting to be sent
+	Dropped uint64 // notifications dropped or replaced
+}
+
 type notify struct {
 	data   interface{}
 	method string
 	isArr  bool
 }
 
-// Notifier ...
+// Notifier queues notifications of a conn and sends them in order
+// from its own goroutine.
 type Notifier struct {
+	dropped uint64 // accessed atomically, keep 64-bit aligned
 	conn    *Conn
-	sending chan *notify
-	mux     sync.Mutex
-	isopen  bool
+	opts    notifierOptions
+	wake    chan struct{}
+
+	mux    sync.Mutex // protects queue, isopen
+	queue  []*notify
+	isopen bool
 }
 
 // NewNotifier ...
-func NewNotifier(conn *Conn) *Notifier {
-	sending := make(chan *notify, 1e3)
-
-	notifier := &Notifier{
-		conn:    conn,
-		sending: sending,
-		isopen:  true,
-	}
+func NewNotifier(conn *Conn, opts ...NotifierOption) *Notifier {
+	notifier := newNotifier(conn, opts...)
 	conn.OnClose(func() {
 		notifier.Close()
 	})
+	return notifier
+}
+
+// newNotifier is NewNotifier without the close handler, for the Hub
+// which closes its notifiers itself.
+func newNotifier(conn *Conn, opts ...NotifierOption) *Notifier {
+	notifier := &Notifier{
+		conn: conn,
+		opts: notifierOptions{
+			bufferSize: defaultNotifierBuffer,
+			policy:     DropNewest,
+		},
+		wake:   make(chan struct{}, 1),
+		isopen: true,
+	}
+	for _, opt := range opts {
+		opt(&notifier.opts)
+	}
 
 	go notifier.loop()
 
@@ -38,16 +115,23 @@ func NewNotifier(conn *Conn) *Notifier {
 }
 
 func (n *Notifier) loop() {
-	for n.isopen {
-		notify, ok := <-n.sending
-		if !ok {
-			break
-		}
-
-		if notify.isArr {
-			n.conn.NotifyEx(notify.method, notify.data)
-		} else {
-			n.conn.Notify(notify.method, notify.data)
+	for range n.wake {
+		for {
+			n.mux.Lock()
+			if !n.isopen || len(n.queue) == 0 {
+				n.mux.Unlock()
+				break
+			}
+			notify := n.queue[0]
+			n.queue[0] = nil
+			n.queue = n.queue[1:]
+			n.mux.Unlock()
+
+			if notify.isArr {
+				n.conn.NotifyEx(notify.method, notify.data)
+			} else {
+				n.conn.Notify(notify.method, notify.data)
+			}
 		}
 	}
 }
@@ -58,69 +142,101 @@ func (n *Notifier) Close() {
 	defer n.mux.Unlock()
 
 	n.closeLocked()
-
 }
 
 func (n *Notifier) closeLocked() {
 	if n.isopen {
-		close(n.sending)
 		n.isopen = false
+		n.queue = nil
+		close(n.wake)
 	}
 }
 
 // Notify ...
 func (n *Notifier) Notify(method string, data interface{}) error {
-	if !n.isopen {
-		return errors.New("notifier closed")
-	}
-
-	select {
-	case n.sending <- &notify{
+	return n.push(&notify{
 		method: method,
 		data:   data,
-	}:
-	default:
-		return errors.New("sending channel is full, conn close")
-	}
-
-	return nil
+	})
 }
 
 // NotifyArr ...
 func (n *Notifier) NotifyArr(method string, data interface{}) error {
+	return n.push(&notify{
+		method: method,
+		data:   data,
+		isArr:  true,
+	})
+}
+
+func (n *Notifier) push(notify *notify) error {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
 	if !n.isopen {
-		return errors.New("notifier closed")
+		return ErrNotifierClosed
 	}
 
+	if n.opts.policy == Coalesce && n.replaceLocked(notify) {
+		return nil
+	}
+
+	if len(n.queue) >= n.opts.bufferSize {
+		switch n.opts.policy {
+		case DropOldest, Coalesce:
+			n.queue[0] = nil
+			n.queue = n.queue[1:]
+			atomic.AddUint64(&n.dropped, 1)
+		case Disconnect:
+			atomic.AddUint64(&n.dropped, 1)
+			n.closeLocked()
+			go n.conn.Close()
+			return ErrNotifierFull
+		default:
+			atomic.AddUint64(&n.dropped, 1)
+			return ErrNotifierFull
+		}
+	}
+
+	n.queue = append(n.queue, notify)
 	select {
-	case n.sending <- &notify{
-		method: method,
-		data:   data,
-		isArr:  true,
-	}:
+	case n.wake <- struct{}{}:
 	default:
-		return errors.New("sending channel is full, conn close")
 	}
-
 	return nil
 }
 
-// Clean ...
-func (n *Notifier) Clean() {
-	l := len(n.sending)
-	for n.isopen {
-		if l <= 1 {
-			return
+// replaceLocked replaces the queued notification of the same method.
+func (n *Notifier) replaceLocked(notify *notify) bool {
+	for i := len(n.queue) - 1; i >= 0; i-- {
+		if n.queue[i].method == notify.method {
+			n.queue[i] = notify
+			atomic.AddUint64(&n.dropped, 1)
+			return true
 		}
+	}
+	return false
+}
 
-		select {
-		case _, ok := <-n.sending:
-			if !ok {
-				return
-			}
-			l--
-		default:
-			return
-		}
+// Stats ...
+func (n *Notifier) Stats() NotifierStats {
+	n.mux.Lock()
+	queued := len(n.queue)
+	n.mux.Unlock()
+
+	return NotifierStats{
+		Queued:  queued,
+		Dropped: atomic.LoadUint64(&n.dropped),
+	}
+}
+
+// Clean drops every queued notification but the latest one.
+func (n *Notifier) Clean() {
+	n.mux.Lock()
+	defer n.mux.Unlock()
+
+	if l := len(n.queue); l > 1 {
+		atomic.AddUint64(&n.dropped, uint64(l-1))
+		n.queue = n.queue[l-1:]
 	}
 }
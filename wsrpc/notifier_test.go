@@ -0,0 +1,109 @@
+package wsrpc
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingCodec records notifications, writes block until release is closed.
+type blockingCodec struct {
+	release chan struct{}
+	mu      sync.Mutex
+	sent    []interface{}
+	closed  bool
+}
+
+func newBlockingCodec() *blockingCodec {
+	return &blockingCodec{release: make(chan struct{})}
+}
+
+func (c *blockingCodec) ReadRequestHeader(*Request) error           { return nil }
+func (c *blockingCodec) ReadRequestBody(interface{}) error          { return nil }
+func (c *blockingCodec) WriteResponse(*Response, interface{}) error { return nil }
+func (c *blockingCodec) GetParams() json.RawMessage                 { return nil }
+func (c *blockingCodec) GetMethod() string                          { return "" }
+
+func (c *blockingCodec) WriteNotification(method string, x interface{}) error {
+	<-c.release
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sent = append(c.sent, x)
+	return nil
+}
+
+func (c *blockingCodec) WriteNotificationEx(method string, x interface{}) error {
+	return c.WriteNotification(method, x)
+}
+
+func (c *blockingCodec) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+func (c *blockingCodec) result() []interface{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]interface{}(nil), c.sent...)
+}
+
+func TestNotifierOverflow(t *testing.T) {
+	cases := []struct {
+		policy   OverflowPolicy
+		notifies []string
+		errs     int
+		sent     []interface{}
+		dropped  uint64
+	}{
+		{DropNewest, []string{"a", "b", "c", "d"}, 1, []interface{}{0, 1, 2}, 1},
+		{DropOldest, []string{"a", "b", "c", "d"}, 0, []interface{}{0, 2, 3}, 1},
+		{Coalesce, []string{"a", "b", "b", "c", "d"}, 0, []interface{}{0, 3, 4}, 2},
+		{Disconnect, []string{"a", "b", "c", "d"}, 1, []interface{}{0}, 1},
+	}
+	for _, c := range cases {
+		codec := newBlockingCodec()
+		conn := NewConn(nil, new(sync.Mutex), codec)
+		notifier := NewNotifier(conn, WithBufferSize(2), WithOverflowPolicy(c.policy))
+
+		var errs int
+		for i, method := range c.notifies {
+			if err := notifier.Notify(method, i); err != nil {
+				errs++
+			}
+			if i == 0 {
+				// let the loop take the first one, it blocks in the codec
+				time.Sleep(20 * time.Millisecond)
+			}
+		}
+		if errs != c.errs {
+			t.Errorf("policy %d: expected %d errors got %d", c.policy, c.errs, errs)
+		}
+		if stats := notifier.Stats(); stats.Dropped != c.dropped {
+			t.Errorf("policy %d: expected %d dropped got %d", c.policy, c.dropped, stats.Dropped)
+		}
+
+		close(codec.release)
+		time.Sleep(20 * time.Millisecond)
+		sent := codec.result()
+		if len(sent) != len(c.sent) {
+			t.Errorf("policy %d: expected %v sent got %v", c.policy, c.sent, sent)
+			continue
+		}
+		for i := range sent {
+			if sent[i] != c.sent[i] {
+				t.Errorf("policy %d: expected %v sent got %v", c.policy, c.sent, sent)
+				break
+			}
+		}
+		codec.mu.Lock()
+		closed := codec.closed
+		codec.mu.Unlock()
+		if c.policy == Disconnect && !closed {
+			t.Error("expected conn closed by the disconnect policy")
+		}
+		notifier.Close()
+	}
+}
@@ -0,0 +1,60 @@
+package wsrpc
+
+import (
+	"errors"
+	"time"
+)
+
+// ServiceOption configures a service when it is registered.
+type ServiceOption func(*service) error
+
+// MethodOption configures a single method of a service.
+type MethodOption func(*methodType)
+
+// WithMethod applies opts to the named method of the service.
+func WithMethod(name string, opts ...MethodOption) ServiceOption {
+	return func(s *service) error {
+		mtype := s.method[name]
+		if mtype == nil {
+			return errors.New("rpc.Register: service " + s.name + " has no method " + name)
+		}
+		for _, opt := range opts {
+			opt(mtype)
+		}
+		return nil
+	}
+}
+
+// WithServiceTimeout sets the timeout of every context-aware method of
+// the service that has no timeout of its own.
+func WithServiceTimeout(d time.Duration) ServiceOption {
+	return func(s *service) error {
+		s.timeout = d
+		return nil
+	}
+}
+
+// WithServiceMiddleware wraps every method of the service with mw,
+// inside the middlewares of the server.
+func WithServiceMiddleware(mw ...WrapHandler) ServiceOption {
+	return func(s *service) error {
+		s.middlewares = append(s.middlewares, mw...)
+		return nil
+	}
+}
+
+// WithTimeout cancels the ctx passed to a context-aware method once d
+// has elapsed since the method started.
+func WithTimeout(d time.Duration) MethodOption {
+	return func(m *methodType) {
+		m.timeout = d
+	}
+}
+
+// WithMiddleware wraps the method with mw, inside the middlewares of
+// the server and of the service.
+func WithMiddleware(mw ...WrapHandler) MethodOption {
+	return func(m *methodType) {
+		m.middlewares = append(m.middlewares, mw...)
+	}
+}
@@ -0,0 +1,120 @@
+// Package otelwsrpc instruments a wsrpc server with OpenTelemetry spans
+// and request metrics.
+package otelwsrpc
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hkjojo/go-toolkits/metric"
+	"github.com/hkjojo/go-toolkits/wsrpc"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/hkjojo/go-toolkits/wsrpc/otelwsrpc"
+
+// Option is instrumentation option.
+type Option func(*options)
+
+// WithTracerProvider with the tracer provider of the spans, the global
+// one by default.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(o *options) {
+		o.tracerProvider = tp
+	}
+}
+
+// WithPropagators with the propagators extracting the trace context of
+// the request meta, the global ones by default.
+func WithPropagators(p propagation.TextMapPropagator) Option {
+	return func(o *options) {
+		o.propagators = p
+	}
+}
+
+// WithRequests with requests counter, labeled by method and code.
+func WithRequests(c metric.Counter) Option {
+	return func(o *options) {
+		o.requests = c
+	}
+}
+
+// WithSeconds with seconds histogram, labeled by method.
+func WithSeconds(ob metric.Observer) Option {
+	return func(o *options) {
+		o.seconds = ob
+	}
+}
+
+type options struct {
+	tracerProvider trace.TracerProvider
+	propagators    propagation.TextMapPropagator
+	// counter: <namespace>_requests_code_total{method, code}
+	requests metric.Counter
+	// histogram: <namespace>_requests_seconds_bucket{method}
+	seconds metric.Observer
+}
+
+// Server is a wrap handler creating a server span for every call. The
+// parent span is extracted from the meta field of the request envelope,
+// for instance {"meta":{"traceparent":"00-..."}}.
+func Server(opts ...Option) wsrpc.WrapHandler {
+	op := options{}
+	for _, o := range opts {
+		o(&op)
+	}
+	if op.tracerProvider == nil {
+		op.tracerProvider = otel.GetTracerProvider()
+	}
+	if op.propagators == nil {
+		op.propagators = otel.GetTextMapPropagator()
+	}
+	tracer := op.tracerProvider.Tracer(instrumentationName)
+
+	return func(next wsrpc.ServiceHandler) wsrpc.ServiceHandler {
+		return func(conn *wsrpc.Conn, args *wsrpc.Args) (interface{}, error) {
+			startTime := time.Now()
+			ctx := op.propagators.Extract(args.Ctx, propagation.MapCarrier(args.Meta))
+			ctx, span := tracer.Start(ctx, args.Method,
+				trace.WithSpanKind(trace.SpanKindServer),
+				trace.WithAttributes(methodAttributes(args.Method)...),
+			)
+			defer span.End()
+			args.Ctx = ctx
+
+			reply, err := next(conn, args)
+
+			code := wsrpc.ErrorCode(err)
+			if err != nil {
+				span.SetAttributes(attribute.Int("rpc.jsonrpc.error_code", code))
+				span.SetStatus(codes.Error, err.Error())
+			}
+			if op.requests != nil {
+				op.requests.With(args.Method, strconv.Itoa(code)).Inc()
+			}
+			if op.seconds != nil {
+				op.seconds.With(args.Method).Observe(time.Since(startTime).Seconds())
+			}
+			return reply, err
+		}
+	}
+}
+
+func methodAttributes(method string) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attribute.String("rpc.system", "jsonrpc"),
+		attribute.String("rpc.jsonrpc.version", "2.0"),
+	}
+	if dot := strings.LastIndex(method, "."); dot >= 0 {
+		return append(attrs,
+			attribute.String("rpc.service", method[:dot]),
+			attribute.String("rpc.method", method[dot+1:]))
+	}
+	return append(attrs, attribute.String("rpc.method", method))
+}
@@ -0,0 +1,223 @@
+package otelwsrpc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/hkjojo/go-toolkits/metric"
+	"github.com/hkjojo/go-toolkits/wsrpc"
+	"github.com/vmihailenco/msgpack/v5"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type Echo int
+
+func (e *Echo) Say(ctx context.Context, conn *wsrpc.Conn, args string, reply *string) error {
+	if args == "" {
+		return wsrpc.NewError(-32010, "empty")
+	}
+	*reply = trace.SpanContextFromContext(ctx).TraceID().String()
+	return nil
+}
+
+type recorder struct {
+	mu     sync.Mutex
+	labels []string
+}
+
+func (r *recorder) With(lvs ...string) metric.Counter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.labels = append(r.labels, strings.Join(lvs, ","))
+	return r
+}
+
+func (r *recorder) Inc()              {}
+func (r *recorder) Add(delta float64) {}
+
+type observer struct {
+	mu      sync.Mutex
+	methods []string
+}
+
+func (o *observer) With(lvs ...string) metric.Observer {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.methods = append(o.methods, lvs...)
+	return o
+}
+
+func (o *observer) Observe(float64) {}
+
+func TestServer(t *testing.T) {
+	var (
+		spans    = tracetest.NewSpanRecorder()
+		provider = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+		requests = &recorder{}
+		seconds  = &observer{}
+	)
+
+	server := wsrpc.NewServer()
+	server.Use(Server(
+		WithTracerProvider(provider),
+		WithPropagators(propagation.TraceContext{}),
+		WithRequests(requests),
+		WithSeconds(seconds),
+	))
+	if err := server.Register(new(Echo)); err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		server.OnConnect(r, ws)
+	}))
+	defer ts.Close()
+
+	client, err := wsrpc.Dial(context.Background(), "ws"+strings.TrimPrefix(ts.URL, "http"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// the client has no meta support, send the envelope by hand
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := `{"jsonrpc":"2.0","id":1,"method":"Echo.Say","params":["hi"],` +
+		`"meta":{"traceparent":"00-` + traceID + `-00f067aa0ba902b7-01"}}`
+	if err := ws.WriteMessage(websocket.TextMessage, []byte(req)); err != nil {
+		t.Fatal(err)
+	}
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	_, data, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), traceID) {
+		t.Errorf("expected the method ctx to carry trace %s got %s", traceID, data)
+	}
+
+	err = client.Call(context.Background(), "Echo.Say", "", new(string))
+	var e *wsrpc.Error
+	if !errors.As(err, &e) || e.Code != -32010 {
+		t.Fatalf("expected code -32010 got %v", err)
+	}
+
+	ended := spans.Ended()
+	if len(ended) != 2 {
+		t.Fatalf("expected 2 spans got %d", len(ended))
+	}
+	if got := ended[0].Parent().TraceID().String(); got != traceID || !ended[0].Parent().IsRemote() {
+		t.Errorf("expected remote parent of trace %s got %s", traceID, got)
+	}
+	if ended[0].Name() != "Echo.Say" || ended[0].SpanKind() != trace.SpanKindServer {
+		t.Errorf("unexpected span %s %s", ended[0].Name(), ended[0].SpanKind())
+	}
+	if ended[1].Status().Code != codes.Error {
+		t.Errorf("expected error status got %v", ended[1].Status())
+	}
+	var found bool
+	for _, attr := range ended[1].Attributes() {
+		if attr.Key == "rpc.jsonrpc.error_code" && attr.Value.AsInt64() == -32010 {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected error code attribute got %v", ended[1].Attributes())
+	}
+
+	expected := "Echo.Say,0;Echo.Say,-32010"
+	if got := strings.Join(requests.labels, ";"); got != expected {
+		t.Errorf("expected requests %s got %s", expected, got)
+	}
+	if len(seconds.methods) != 2 {
+		t.Errorf("expected 2 durations got %v", seconds.methods)
+	}
+}
+
+func TestServerBinary(t *testing.T) {
+	var (
+		spans    = tracetest.NewSpanRecorder()
+		provider = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+	)
+
+	server := wsrpc.NewServer()
+	server.Use(Server(
+		WithTracerProvider(provider),
+		WithPropagators(propagation.TraceContext{}),
+	))
+	if err := server.Register(new(Echo)); err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upgrader := websocket.Upgrader{Subprotocols: server.Subprotocols()}
+		ws, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		server.OnConnect(r, ws)
+	}))
+	defer ts.Close()
+
+	dialer := websocket.Dialer{Subprotocols: []string{wsrpc.MsgpackEncoding.Subprotocol}}
+	ws, _, err := dialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	req, err := msgpack.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0", "id": 1, "method": "Echo.Say", "params": []string{"hi"},
+		"meta": map[string]string{"traceparent": "00-" + traceID + "-00f067aa0ba902b7-01"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.WriteMessage(websocket.BinaryMessage, req); err != nil {
+		t.Fatal(err)
+	}
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	_, data, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp struct {
+		Result string `msgpack:"result"`
+	}
+	if err := msgpack.Unmarshal(data, &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Result != traceID {
+		t.Errorf("expected the method ctx to carry trace %s got %s", traceID, resp.Result)
+	}
+
+	ended := spans.Ended()
+	if len(ended) != 1 {
+		t.Fatalf("expected 1 span got %d", len(ended))
+	}
+	if got := ended[0].Parent().TraceID().String(); got != traceID || !ended[0].Parent().IsRemote() {
+		t.Errorf("expected remote parent of trace %s got %s", traceID, got)
+	}
+}
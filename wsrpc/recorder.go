@@ -0,0 +1,183 @@
+package wsrpc
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Kinds of Record.
+const (
+	RecordRequest      = "request"
+	RecordResponse     = "response"
+	RecordNotification = "notification"
+)
+
+// Record is a line of a recording, a request read from a conn or a
+// response or notification written to it.
+type Record struct {
+	Time   time.Time         `json:"time"`
+	Conn   uint64            `json:"conn"` // recorded conn, in order of wrapping
+	Kind   string            `json:"kind"`
+	Seq    uint64            `json:"seq,omitempty"` // pairs a response with its request
+	Method string            `json:"method"`
+	Params json.RawMessage   `json:"params,omitempty"`
+	Meta   map[string]string `json:"meta,omitempty"`
+	Result json.RawMessage   `json:"result,omitempty"`
+	Error  *Error            `json:"error,omitempty"`
+}
+
+// CodecWrapper wraps the codecs the server creates for its conns.
+type CodecWrapper func(ServerCodec) ServerCodec
+
+// SetCodecWrapper sets the wrapper applied to the codecs created by
+// OnConnect and ServeHTTP, such as Recorder.Wrap.
+func (server *Server) SetCodecWrapper(wrap CodecWrapper) {
+	server.wrapCodec = wrap
+}
+
+// Recorder writes the traffic of the codecs it wraps as JSON lines.
+type Recorder struct {
+	conns uint64 // accessed atomically
+
+	mu  sync.Mutex // serializes writes of enc
+	enc *json.Encoder
+	err error // first write error, recording stops after it
+}
+
+// NewRecorder returns a recorder writing to w, usually a file.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{enc: json.NewEncoder(w)}
+}
+
+// Err returns the first error writing a record.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.err
+}
+
+// Wrap returns codec recording its requests, responses and notifications.
+func (r *Recorder) Wrap(codec ServerCodec) ServerCodec {
+	return &recordingCodec{
+		ServerCodec: codec,
+		recorder:    r,
+		conn:        atomic.AddUint64(&r.conns, 1),
+	}
+}
+
+func (r *Recorder) write(record *Record) {
+	record.Time = time.Now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err == nil {
+		r.err = r.enc.Encode(record)
+	}
+}
+
+type recordingCodec struct {
+	ServerCodec
+	recorder *Recorder
+	conn     uint64
+}
+
+func (c *recordingCodec) ReadRequestHeader(r *Request) error {
+	if err := c.ServerCodec.ReadRequestHeader(r); err != nil {
+		return err
+	}
+
+	record := &Record{
+		Conn:   c.conn,
+		Kind:   RecordRequest,
+		Seq:    r.Seq,
+		Method: r.ServiceMethod,
+		Params: c.GetParams(),
+	}
+	if mc, ok := c.ServerCodec.(MetaCodec); ok {
+		record.Meta = mc.GetMeta()
+	}
+	c.recorder.write(record)
+	return nil
+}
+
+func (c *recordingCodec) WriteResponse(r *Response, x interface{}) error {
+	record := &Record{
+		Conn:   c.conn,
+		Kind:   RecordResponse,
+		Seq:    r.Seq,
+		Method: r.ServiceMethod,
+	}
+	if r.Error == "" {
+		record.Result = marshalRecord(x)
+	} else {
+		code := r.Code
+		if code == 0 {
+			code = CodeServerError
+		}
+		record.Error = &Error{Code: code, Message: r.Error, Data: r.Data}
+	}
+	c.recorder.write(record)
+
+	return c.ServerCodec.WriteResponse(r, x)
+}
+
+func (c *recordingCodec) WriteNotification(method string, x interface{}) error {
+	c.recorder.write(&Record{
+		Conn:   c.conn,
+		Kind:   RecordNotification,
+		Method: method,
+		Params: marshalRecord(x),
+	})
+	return c.ServerCodec.WriteNotification(method, x)
+}
+
+func (c *recordingCodec) WriteNotificationEx(method string, x interface{}) error {
+	c.recorder.write(&Record{
+		Conn:   c.conn,
+		Kind:   RecordNotification,
+		Method: method,
+		Params: marshalRecord([]interface{}{x}),
+	})
+	return c.ServerCodec.WriteNotificationEx(method, x)
+}
+
+// GetMeta ...
+func (c *recordingCodec) GetMeta() map[string]string {
+	if mc, ok := c.ServerCodec.(MetaCodec); ok {
+		return mc.GetMeta()
+	}
+	return nil
+}
+
+func marshalRecord(x interface{}) json.RawMessage {
+	data, err := json.Marshal(x)
+	if err != nil {
+		// keep the record, the value is not JSON
+		data, _ = json.Marshal(err.Error())
+	}
+	return data
+}
+
+// ReadRecords reads a recording written by a Recorder.
+func ReadRecords(r io.Reader) ([]Record, error) {
+	var records []Record
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 64<<20)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return records, err
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
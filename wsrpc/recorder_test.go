@@ -0,0 +1,59 @@
+package wsrpc
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	var buf bytes.Buffer
+	recorder := NewRecorder(&buf)
+
+	server := NewServer()
+	server.SetCodecWrapper(recorder.Wrap)
+	if err := server.Register(new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	ts, url := newTestServer(t, server)
+
+	client, err := Dial(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var echoed string
+	if err := client.Call(context.Background(), "Arith.Echo", "hi", &echoed); err != nil {
+		t.Fatal(err)
+	}
+	client.Call(context.Background(), "Arith.Divide", &ArithArgs{A: 1}, new(ArithQuotient))
+	client.Close()
+	ts.Close()
+
+	if err := recorder.Err(); err != nil {
+		t.Fatal(err)
+	}
+	records, err := ReadRecords(strings.NewReader(buf.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var kinds []string
+	for _, record := range records {
+		kinds = append(kinds, record.Kind+":"+record.Method)
+		if record.Conn != 1 || record.Time.IsZero() {
+			t.Errorf("unexpected record %+v", record)
+		}
+	}
+	expected := "request:Arith.Echo,notification:Arith.Echoed,response:Arith.Echo," +
+		"request:Arith.Divide,response:Arith.Divide"
+	if got := strings.Join(kinds, ","); got != expected {
+		t.Fatalf("expected %s got %s", expected, got)
+	}
+	if string(records[0].Params) != `"hi"` || string(records[2].Result) != `"hi"` || records[0].Seq != records[2].Seq {
+		t.Errorf("unexpected echo records %+v %+v", records[0], records[2])
+	}
+	if records[4].Error == nil || records[4].Error.Message != "divide by zero" {
+		t.Errorf("expected divide error got %+v", records[4])
+	}
+}
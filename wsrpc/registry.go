@@ -0,0 +1,231 @@
+package wsrpc
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var errShuttingDown = NewError(CodeServerBusy, "rpc: server is shutting down")
+
+// shutdownPollInterval is how often Shutdown checks that the in-flight
+// requests are done and the conns are gone.
+const shutdownPollInterval = time.Millisecond * 10
+
+// registry tracks the live conns of a server by id and by the values of
+// the indexed data keys.
+type registry struct {
+	seq      uint64 // accessed atomically, keep 64-bit aligned
+	shutdown int32  // accessed atomically
+
+	mu      sync.RWMutex // protects conns, keys, values, indexes
+	conns   map[uint64]*Conn
+	keys    map[string]struct{}
+	values  map[uint64]map[string]interface{} // indexed values per conn
+	indexes map[string]map[interface{}]map[uint64]*Conn
+}
+
+func newRegistry() *registry {
+	return &registry{
+		conns:   make(map[uint64]*Conn),
+		keys:    make(map[string]struct{}),
+		values:  make(map[uint64]map[string]interface{}),
+		indexes: make(map[string]map[interface{}]map[uint64]*Conn),
+	}
+}
+
+func (r *registry) add(conn *Conn) {
+	conn.id = atomic.AddUint64(&r.seq, 1)
+	conn.registry = r
+
+	r.mu.Lock()
+	r.conns[conn.id] = conn
+	r.mu.Unlock()
+}
+
+func (r *registry) remove(conn *Conn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.conns, conn.id)
+	for key, value := range r.values[conn.id] {
+		r.unindexLocked(key, value, conn)
+	}
+	delete(r.values, conn.id)
+}
+
+// reindex updates the index of key with the value conn currently holds.
+func (r *registry) reindex(conn *Conn, key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.keys[key]; !ok {
+		return
+	}
+	if _, ok := r.conns[conn.id]; !ok {
+		return
+	}
+	r.reindexLocked(conn, key)
+}
+
+func (r *registry) reindexLocked(conn *Conn, key string) {
+	value := conn.GetData(key)
+	if old, ok := r.values[conn.id][key]; ok {
+		if old == value {
+			return
+		}
+		r.unindexLocked(key, old, conn)
+		delete(r.values[conn.id], key)
+	}
+	if value == nil {
+		return
+	}
+
+	if r.values[conn.id] == nil {
+		r.values[conn.id] = make(map[string]interface{})
+	}
+	r.values[conn.id][key] = value
+	r.indexLocked(key, value, conn)
+}
+
+func (r *registry) indexLocked(key string, value interface{}, conn *Conn) {
+	index := r.indexes[key]
+	if index == nil {
+		index = make(map[interface{}]map[uint64]*Conn)
+		r.indexes[key] = index
+	}
+	if index[value] == nil {
+		index[value] = make(map[uint64]*Conn)
+	}
+	index[value][conn.id] = conn
+}
+
+func (r *registry) unindexLocked(key string, value interface{}, conn *Conn) {
+	index := r.indexes[key]
+	delete(index[value], conn.id)
+	if len(index[value]) == 0 {
+		delete(index, value)
+	}
+}
+
+func (r *registry) isShutdown() bool {
+	return atomic.LoadInt32(&r.shutdown) == 1
+}
+
+func (r *registry) len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return len(r.conns)
+}
+
+// IndexBy indexes the live conns by the values they hold for the data
+// keys, so they can be found with ConnsBy. Values must be comparable,
+// they are set with Conn.SetData.
+func (server *Server) IndexBy(keys ...string) {
+	r := server.registry
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, key := range keys {
+		if _, ok := r.keys[key]; ok {
+			continue
+		}
+		r.keys[key] = struct{}{}
+		for _, conn := range r.conns {
+			r.reindexLocked(conn, key)
+		}
+	}
+}
+
+// Conn returns the live conn with id, nil if there is none.
+func (server *Server) Conn(id uint64) *Conn {
+	server.registry.mu.RLock()
+	defer server.registry.mu.RUnlock()
+
+	return server.registry.conns[id]
+}
+
+// ConnsBy returns the live conns holding value for the indexed data key.
+func (server *Server) ConnsBy(key string, value interface{}) []*Conn {
+	server.registry.mu.RLock()
+	defer server.registry.mu.RUnlock()
+
+	index := server.registry.indexes[key][value]
+	conns := make([]*Conn, 0, len(index))
+	for _, conn := range index {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+// Conns returns every live conn.
+func (server *Server) Conns() []*Conn {
+	server.registry.mu.RLock()
+	defer server.registry.mu.RUnlock()
+
+	conns := make([]*Conn, 0, len(server.registry.conns))
+	for _, conn := range server.registry.conns {
+		conns = append(conns, conn)
+	}
+	return conns
+}
+
+// ConnCount returns the number of live conns.
+func (server *Server) ConnCount() int {
+	return server.registry.len()
+}
+
+// Shutdown gracefully stops the server: new conns are closed right away
+// and new requests are answered with CodeServerBusy, then it waits for
+// the in-flight requests, sends a close frame to every conn and waits
+// for them to terminate. When ctx is done first the conns are closed
+// anyway and the ctx error is returned.
+func (server *Server) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&server.registry.shutdown, 1)
+
+	err := poll(ctx, func() bool {
+		stats := server.stats.load()
+		return stats.InFlight == 0 && stats.Queued == 0
+	})
+
+	for _, conn := range server.Conns() {
+		conn.shutdown()
+	}
+	if err != nil {
+		return err
+	}
+
+	return poll(ctx, func() bool {
+		return server.registry.len() == 0
+	})
+}
+
+// poll calls done until it reports true or ctx is done.
+func poll(ctx context.Context, done func() bool) error {
+	t := time.NewTicker(shutdownPollInterval)
+	defer t.Stop()
+
+	for !done() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+	return nil
+}
+
+// shutdown sends a going away close frame when the conn is a websocket,
+// then closes it.
+func (c *Conn) shutdown() {
+	if c.rwc != nil {
+		c.rwc.WS.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutdown"),
+			time.Now().Add(c.rwc.controlTimeout()))
+	}
+	c.Close()
+}
@@ -0,0 +1,141 @@
+package wsrpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type Users int
+
+func (u *Users) Login(conn *Conn, uid string, reply *uint64) error {
+	conn.SetData("uid", uid)
+	*reply = conn.ID()
+	return nil
+}
+
+func (u *Users) Logout(conn *Conn, args int, reply *int) error {
+	conn.DelData("uid")
+	return nil
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+}
+
+func TestConnRegistry(t *testing.T) {
+	server := NewServer()
+	server.IndexBy("uid")
+	if err := server.Register(new(Users)); err != nil {
+		t.Fatal(err)
+	}
+	ts, url := newTestServer(t, server)
+	defer ts.Close()
+
+	var (
+		clients []*Client
+		ids     []uint64
+	)
+	for _, uid := range []string{"alice", "alice", "bob"} {
+		client, err := Dial(context.Background(), url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		var id uint64
+		if err := client.Call(context.Background(), "Users.Login", uid, &id); err != nil {
+			t.Fatal(err)
+		}
+		clients = append(clients, client)
+		ids = append(ids, id)
+	}
+
+	if n := server.ConnCount(); n != 3 {
+		t.Errorf("expected 3 conns got %d", n)
+	}
+	if ids[0] == ids[1] || ids[1] == ids[2] {
+		t.Errorf("expected unique conn ids got %v", ids)
+	}
+	if conn := server.Conn(ids[2]); conn == nil || conn.GetData("uid") != "bob" {
+		t.Errorf("expected conn %d of bob", ids[2])
+	}
+	if conns := server.ConnsBy("uid", "alice"); len(conns) != 2 {
+		t.Errorf("expected 2 conns of alice got %d", len(conns))
+	}
+
+	if err := clients[1].Call(context.Background(), "Users.Logout", 0, new(int)); err != nil {
+		t.Fatal(err)
+	}
+	if conns := server.ConnsBy("uid", "alice"); len(conns) != 1 || conns[0].ID() != ids[0] {
+		t.Errorf("expected conn %d of alice after logout got %v", ids[0], conns)
+	}
+
+	clients[0].Close()
+	waitFor(t, func() bool { return server.ConnCount() == 2 })
+	if conns := server.ConnsBy("uid", "alice"); len(conns) != 0 {
+		t.Errorf("expected no conn of alice got %d", len(conns))
+	}
+	if server.Conn(ids[0]) != nil {
+		t.Errorf("expected conn %d to be gone", ids[0])
+	}
+}
+
+func TestServerShutdown(t *testing.T) {
+	server := NewServer()
+	if err := server.Register(new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	ts, url := newTestServer(t, server)
+	defer ts.Close()
+
+	client, err := Dial(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	call := client.Go("Arith.Sleep", time.Millisecond*100, new(int), nil)
+	waitFor(t, func() bool { return server.Stats().InFlight == 1 })
+
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Shutdown(context.Background())
+	}()
+
+	// requests read during the shutdown are rejected
+	waitFor(t, func() bool { return server.registry.isShutdown() })
+	err = client.Call(context.Background(), "Arith.Multiply", &ArithArgs{A: 1, B: 2}, new(int))
+	if e, ok := err.(*Error); !ok || e.Code != CodeServerBusy {
+		t.Errorf("expected server busy got %v", err)
+	}
+
+	if err := (<-call.Done).Error; err != nil {
+		t.Errorf("expected in-flight call to complete got %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if n := server.ConnCount(); n != 0 {
+		t.Errorf("expected no conn after shutdown got %d", n)
+	}
+
+	// new conns are closed right away
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil {
+		defer ws.Close()
+		ws.SetReadDeadline(time.Now().Add(time.Second))
+		if _, _, err := ws.ReadMessage(); err == nil {
+			t.Error("expected the conn to be closed")
+		}
+	}
+}
@@ -2,30 +2,125 @@ package wsrpc
 
 import (
 	"io"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+const (
+	defaultPingInterval = time.Second * 5
+	defaultPongTimeout  = time.Second * 10
+	defaultWriteTimeout = time.Second * 5
+)
+
+type connOptions struct {
+	pingInterval   time.Duration
+	pongTimeout    time.Duration
+	writeTimeout   time.Duration
+	idleTimeout    time.Duration
+	maxMessageSize int64
+}
+
+// ConnOption configures the websocket conn of a ReadWriteCloser.
+type ConnOption func(*connOptions)
+
+// WithPingInterval sets the interval of the pings sent to the peer,
+// default 5s.
+func WithPingInterval(d time.Duration) ConnOption {
+	return func(o *connOptions) {
+		if d > 0 {
+			o.pingInterval = d
+		}
+	}
+}
+
+// WithPongTimeout sets how long the peer may take to answer a ping,
+// default 10s. A read fails once no pong came for the ping interval
+// plus d, zero disables the read deadline.
+func WithPongTimeout(d time.Duration) ConnOption {
+	return func(o *connOptions) {
+		o.pongTimeout = d
+	}
+}
+
+// WithConnWriteTimeout sets the deadline of every write, default 5s,
+// zero disables it.
+func WithConnWriteTimeout(d time.Duration) ConnOption {
+	return func(o *connOptions) {
+		o.writeTimeout = d
+	}
+}
+
+// WithIdleTimeout closes the conn when no message was read for d,
+// pongs do not count. Disabled by default.
+func WithIdleTimeout(d time.Duration) ConnOption {
+	return func(o *connOptions) {
+		o.idleTimeout = d
+	}
+}
+
+// WithMaxMessageSize sets the max size in bytes of a message read from
+// the peer, the conn is closed when it is exceeded. Unlimited by default.
+func WithMaxMessageSize(size int64) ConnOption {
+	return func(o *connOptions) {
+		o.maxMessageSize = size
+	}
+}
+
 // ReadWriteCloser ...
 type ReadWriteCloser struct {
-	WS   *websocket.Conn
-	r    io.Reader
-	done chan struct{}
+	lastRead int64 // unix nano of the last message, accessed atomically
+
+	WS *websocket.Conn
+	// MessageType is the frame type used by Write,
+	// websocket.TextMessage by default.
+	MessageType int
+
+	opts      connOptions
+	r         io.Reader
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
 // NewReadWriteCloser ...
-func NewReadWriteCloser(ws *websocket.Conn) *ReadWriteCloser {
+func NewReadWriteCloser(ws *websocket.Conn, opts ...ConnOption) *ReadWriteCloser {
 	rwc := &ReadWriteCloser{
-		WS:   ws,
+		lastRead:    time.Now().UnixNano(),
+		WS:          ws,
+		MessageType: websocket.TextMessage,
+		opts: connOptions{
+			pingInterval: defaultPingInterval,
+			pongTimeout:  defaultPongTimeout,
+			writeTimeout: defaultWriteTimeout,
+		},
 		done: make(chan struct{}),
 	}
+	for _, opt := range opts {
+		opt(&rwc.opts)
+	}
+
+	if rwc.opts.maxMessageSize > 0 {
+		ws.SetReadLimit(rwc.opts.maxMessageSize)
+	}
+	if rwc.opts.pongTimeout > 0 {
+		ws.SetReadDeadline(rwc.readDeadline())
+		ws.SetPongHandler(func(string) error {
+			return ws.SetReadDeadline(rwc.readDeadline())
+		})
+	}
+
 	go rwc.ping()
 	return rwc
 }
 
+func (rwc *ReadWriteCloser) readDeadline() time.Time {
+	return time.Now().Add(rwc.opts.pingInterval + rwc.opts.pongTimeout)
+}
+
 func (rwc *ReadWriteCloser) ping() {
-	t := time.NewTicker(time.Second * 5)
+	t := time.NewTicker(rwc.opts.pingInterval)
 	defer t.Stop()
 
 	for {
@@ -33,8 +128,15 @@ func (rwc *ReadWriteCloser) ping() {
 		case <-rwc.done:
 			return
 		case <-t.C:
+			if rwc.idle() {
+				rwc.WS.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseGoingAway, "idle timeout"),
+					time.Now().Add(rwc.controlTimeout()))
+				rwc.Close()
+				return
+			}
 			if err := rwc.WS.WriteControl(websocket.PingMessage,
-				[]byte{}, time.Now().Add(time.Second*5),
+				[]byte{}, time.Now().Add(rwc.controlTimeout()),
 			); err != nil {
 				rwc.WS.Close()
 				return
@@ -43,23 +145,44 @@ func (rwc *ReadWriteCloser) ping() {
 	}
 }
 
-func (rwc *ReadWriteCloser) Read(p []byte) (n int, err error) {
-	if rwc.r == nil {
-		_, rwc.r, err = rwc.WS.NextReader()
-		if err != nil {
-			return 0, err
-		}
+func (rwc *ReadWriteCloser) idle() bool {
+	if rwc.opts.idleTimeout <= 0 {
+		return false
 	}
-	for n = 0; n < len(p); {
-		var m int
-		m, err = rwc.r.Read(p[n:])
-		n += m
-		if err == io.EOF {
-			rwc.r = nil
-			break
+	last := time.Unix(0, atomic.LoadInt64(&rwc.lastRead))
+	return time.Since(last) >= rwc.opts.idleTimeout
+}
+
+func (rwc *ReadWriteCloser) controlTimeout() time.Duration {
+	if rwc.opts.writeTimeout > 0 {
+		return rwc.opts.writeTimeout
+	}
+	return defaultWriteTimeout
+}
+
+func (rwc *ReadWriteCloser) Read(p []byte) (n int, err error) {
+	for n == 0 && len(p) > 0 {
+		if rwc.r == nil {
+			_, rwc.r, err = rwc.WS.NextReader()
+			if err != nil {
+				return 0, err
+			}
+			atomic.StoreInt64(&rwc.lastRead, time.Now().UnixNano())
 		}
-		if err != nil {
-			break
+		for n < len(p) {
+			var m int
+			m, err = rwc.r.Read(p[n:])
+			n += m
+			if err == io.EOF {
+				// end of this message, move on to the next one
+				// rather than reporting EOF to the decoder
+				rwc.r = nil
+				err = nil
+				break
+			}
+			if err != nil {
+				return
+			}
 		}
 	}
 
@@ -67,29 +190,35 @@ func (rwc *ReadWriteCloser) Read(p []byte) (n int, err error) {
 }
 
 func (rwc *ReadWriteCloser) Write(p []byte) (n int, err error) {
+	if rwc.opts.writeTimeout > 0 {
+		rwc.WS.SetWriteDeadline(time.Now().Add(rwc.opts.writeTimeout))
+	}
+
 	var w io.WriteCloser
-	w, err = rwc.WS.NextWriter(websocket.TextMessage)
+	w, err = rwc.WS.NextWriter(rwc.MessageType)
 	if err != nil {
 		return 0, err
 	}
 
 	for n = 0; n < len(p); {
 		var m int
-		m, err = w.Write(p)
+		m, err = w.Write(p[n:])
 		n += m
 		if err != nil {
-			err = rwc.Close()
+			rwc.Close()
 			return
 		}
 	}
 
-	w.Close()
+	err = w.Close()
 	return
 }
 
 // Close ...
 func (rwc *ReadWriteCloser) Close() (err error) {
-	close(rwc.done)
+	rwc.closeOnce.Do(func() {
+		close(rwc.done)
+	})
 	err = rwc.WS.Close()
 	return
 }
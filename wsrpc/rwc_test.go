@@ -0,0 +1,91 @@
+package wsrpc
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func dialWithConnOptions(t *testing.T, opts ...ConnOption) (*websocket.Conn, func()) {
+	server := NewServer()
+	if err := server.Register(new(Arith)); err != nil {
+		t.Fatal(err)
+	}
+	server.SetConnOptions(opts...)
+	ts, url := newTestServer(t, server)
+
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		ts.Close()
+		t.Fatal(err)
+	}
+	return ws, func() {
+		ws.Close()
+		ts.Close()
+	}
+}
+
+func TestConnPongTimeout(t *testing.T) {
+	ws, closeAll := dialWithConnOptions(t,
+		WithPingInterval(time.Millisecond*20),
+		WithPongTimeout(time.Millisecond*20))
+	defer closeAll()
+
+	// no pong is sent while the client is not reading
+	time.Sleep(time.Millisecond * 200)
+
+	ws.SetReadDeadline(time.Now().Add(time.Second * 2))
+	for {
+		_, _, err := ws.ReadMessage()
+		if err == nil {
+			continue
+		}
+		if e, ok := err.(net.Error); ok && e.Timeout() {
+			t.Fatal("expected the server to close the conn")
+		}
+		return
+	}
+}
+
+func TestConnIdleTimeout(t *testing.T) {
+	ws, closeAll := dialWithConnOptions(t,
+		WithPingInterval(time.Millisecond*10),
+		WithIdleTimeout(time.Millisecond*100))
+	defer closeAll()
+
+	start := time.Now()
+	ws.SetReadDeadline(time.Now().Add(time.Second * 2))
+	_, _, err := ws.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Fatalf("expected going away got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Millisecond*100 {
+		t.Errorf("closed after %s, before the idle timeout", elapsed)
+	}
+}
+
+func TestConnMaxMessageSize(t *testing.T) {
+	ws, closeAll := dialWithConnOptions(t, WithMaxMessageSize(128))
+	defer closeAll()
+
+	req := `{"jsonrpc":"2.0","id":1,"method":"Arith.Multiply","params":[{"A":7,"B":8}]}`
+	if err := ws.WriteMessage(websocket.TextMessage, []byte(req)); err != nil {
+		t.Fatal(err)
+	}
+	ws.SetReadDeadline(time.Now().Add(time.Second * 2))
+	if _, _, err := ws.ReadMessage(); err != nil {
+		t.Fatalf("expected a reply under the limit got %v", err)
+	}
+
+	big := `{"jsonrpc":"2.0","id":2,"method":"Arith.Echo","params":["` + strings.Repeat("x", 1024) + `"]}`
+	if err := ws.WriteMessage(websocket.TextMessage, []byte(big)); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err := ws.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+		t.Fatalf("expected message too big got %v", err)
+	}
+}
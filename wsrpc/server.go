@@ -127,12 +127,15 @@
 package wsrpc
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"io"
 	"net/http"
 	"reflect"
 	"strings"
 	"sync"
+	"time"
 	"unicode"
 	"unicode/utf8"
 
@@ -149,18 +152,30 @@ const (
 // because Typeof takes an empty interface value. This is annoying.
 var typeOfError = reflect.TypeOf((*error)(nil)).Elem()
 var typeOfConn = reflect.TypeOf(&Conn{})
+var typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
 
 type methodType struct {
-	ArgType   reflect.Type
-	ReplyType reflect.Type
-	method    reflect.Method
+	stats       methodStats // accessed atomically, keep 64-bit aligned
+	ArgType     reflect.Type
+	ReplyType   reflect.Type
+	method      reflect.Method
+	withContext bool          // method takes a context.Context first
+	timeout     time.Duration // cancels the ctx of the method, if any
+	middlewares []WrapHandler // wrap the method only
+	roles       []string      // required roles, nil to use the service ones
+	rateLimit   *RateLimit    // calls per conn, nil if unlimited
+	noParams    bool          // a missing params member is accepted
 }
 
 type service struct {
-	typ    reflect.Type           // type of the receiver
-	method map[string]*methodType // registered methods
-	name   string                 // name of service
-	rcvr   reflect.Value          // receiver of methods for the service
+	typ     reflect.Type           // type of the receiver
+	method  map[string]*methodType // registered methods
+	name    string                 // name of service
+	rcvr    reflect.Value          // receiver of methods for the service
+	timeout time.Duration          // default timeout of the methods
+
+	middlewares []WrapHandler // wrap every method of the service
+	roles       []string      // required roles of every method
 }
 
 // Args for Call
@@ -170,6 +185,13 @@ type Args struct {
 	RawReq json.RawMessage
 	Arg    reflect.Value
 	Reply  reflect.Value
+	// Ctx is passed to context-aware methods, it is cancelled when the
+	// conn terminates or the method timeout expires. Wrap handlers may
+	// replace it to carry values down to the method.
+	Ctx context.Context
+	// Meta is the optional meta field of the request envelope, such as
+	// a W3C trace context, when the codec implements MetaCodec.
+	Meta map[string]string
 }
 
 // Request is a header written before every RPC call. It is used internally
@@ -185,10 +207,12 @@ type Request struct {
 // but documented here as an aid to debugging, such as when analyzing
 // network traffic.
 type Response struct {
-	next          *Response // for free list in Server
-	ServiceMethod string    // echoes that of the Request
-	Error         string    // error, if any.
-	Seq           uint64    // echoes that of the request
+	next          *Response   // for free list in Server
+	ServiceMethod string      // echoes that of the Request
+	Error         string      // error, if any.
+	Code          int         // JSON-RPC error code, if Error is set.
+	Data          interface{} // additional error data, if any.
+	Seq           uint64      // echoes that of the request
 }
 
 // InitHandler ...
@@ -202,14 +226,23 @@ type WrapHandler func(ServiceHandler) ServiceHandler
 
 // Server represents an RPC Server.
 type Server struct {
+	stats           stats // accessed atomically, keep 64-bit aligned
 	onMissingMethod MissingMethodFunc
-	onWrap          WrapHandler
+	middlewares     []WrapHandler
 	serviceMap      map[string]*service
 	freeReq         *Request
 	freeResp        *Response
 	logger          Logger
-
-	mu       sync.RWMutex // protects the serviceMap
+	limits          Limits
+	inFlight        chan struct{} // server wide slots, nil if unlimited
+	encodings       map[string]Encoding
+	connOpts        []ConnOption
+	authenticator   Authenticator
+	wrapCodec       CodecWrapper
+	registry        *registry
+	httpLimits      httpLimits
+
+	mu       sync.RWMutex // protects the serviceMap, middlewares
 	reqLock  sync.Mutex   // protects freeReq
 	respLock sync.Mutex   // protects freeResp
 }
@@ -231,6 +264,11 @@ func NewServer() *Server {
 	return &Server{
 		serviceMap: make(map[string]*service),
 		logger:     &log{},
+		registry:   newRegistry(),
+		encodings: map[string]Encoding{
+			MsgpackEncoding.Subprotocol: MsgpackEncoding,
+			CBOREncoding.Subprotocol:    CBOREncoding,
+		},
 	}
 }
 
@@ -243,7 +281,9 @@ func isExported(name string) bool {
 	return unicode.IsUpper(rune)
 }
 
-// OnMissingMethod ...
+// OnMissingMethod sets the handler of the calls to unregistered methods.
+// They go through the middlewares of the server, but not through the
+// service and method ones, with Args holding no Arg and Reply.
 func (server *Server) OnMissingMethod(handler MissingMethodFunc) {
 	server.onMissingMethod = handler
 }
@@ -256,9 +296,21 @@ func (server *Server) SetLogger(logger Logger) {
 	server.logger = logger
 }
 
-// OnWrap ...
+// OnWrap is kept for compatibility, it appends handler to the
+// middleware chain like Use.
 func (server *Server) OnWrap(handler WrapHandler) {
-	server.onWrap = handler
+	server.Use(handler)
+}
+
+// Use appends mw to the middleware chain wrapping every registered
+// method and the missing method handler, the first middleware added is
+// the outermost one. Service and method middlewares set at registration
+// run inside the server chain.
+func (server *Server) Use(mw ...WrapHandler) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	server.middlewares = append(server.middlewares, mw...)
 }
 
 // Is this type exported or a builtin?
@@ -279,21 +331,24 @@ func isConnType(t reflect.Type) bool {
 // Register publishes in the server the set of methods of the
 // receiver value that satisfy the following conditions:
 //	- exported method of exported type
-//	- two arguments, both of exported type
-//	- the second argument is a pointer
+//	- an optional context.Context, a *Conn, then two arguments,
+//	  both of exported type
+//	- the last argument is a pointer
 //	- one return value, of type error
+// That is func(*Conn, Arg, *Reply) error or
+// func(context.Context, *Conn, Arg, *Reply) error.
 // It returns an error if the receiver is not an exported type or has
 // no suitable methods. It also logs the error using package log.
 // The client accesses each method using a string of the form "Type.Method",
 // where Type is the receiver's concrete type.
-func (server *Server) Register(rcvr interface{}) error {
-	return server.register(rcvr, "", false)
+func (server *Server) Register(rcvr interface{}, opts ...ServiceOption) error {
+	return server.register(rcvr, "", false, opts)
 }
 
 // RegisterName is like Register but uses the provided name for the type
 // instead of the receiver's concrete type.
-func (server *Server) RegisterName(name string, rcvr interface{}) error {
-	return server.register(rcvr, name, true)
+func (server *Server) RegisterName(name string, rcvr interface{}, opts ...ServiceOption) error {
+	return server.register(rcvr, name, true, opts)
 }
 
 // ListMethods ...
@@ -309,7 +364,7 @@ func (server *Server) ListMethods() []string {
 	return list
 }
 
-func (server *Server) register(rcvr interface{}, name string, useName bool) error {
+func (server *Server) register(rcvr interface{}, name string, useName bool, opts []ServiceOption) error {
 	server.mu.Lock()
 	defer server.mu.Unlock()
 	if server.serviceMap == nil {
@@ -350,6 +405,11 @@ func (server *Server) register(rcvr interface{}, name string, useName bool) erro
 		}
 		return errors.New(str)
 	}
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return err
+		}
+	}
 	server.serviceMap[s.name] = s
 	return nil
 }
@@ -366,15 +426,26 @@ func (server *Server) suitableMethods(typ reflect.Type, isLog bool) map[string]*
 		if method.PkgPath != "" {
 			continue
 		}
-		// Method needs three ins: receiver, *args, *reply.
-		if mtype.NumIn() != 4 {
+		// Method needs four ins: receiver, *Conn, *args, *reply,
+		// or five with a leading context.Context.
+		if mtype.NumIn() != 4 && mtype.NumIn() != 5 {
 			if isLog {
 				server.logger.Errorf("method:%s has wrong number of ins:%d\n", mname, mtype.NumIn())
 			}
 			continue
 		}
-		// Second arg need not be a pointer.
-		argType := mtype.In(1)
+		in := 1
+		withContext := mtype.NumIn() == 5
+		if withContext {
+			if mtype.In(in) != typeOfContext {
+				if isLog {
+					server.logger.Errorf("%s first argument is not context.Context\n", mname)
+				}
+				continue
+			}
+			in++
+		}
+		argType := mtype.In(in)
 		if !isConnType(argType) {
 			if isLog {
 				server.logger.Errorf("%s first argument is not Conn\n", mname)
@@ -382,7 +453,7 @@ func (server *Server) suitableMethods(typ reflect.Type, isLog bool) map[string]*
 			continue
 		}
 		// Second arg need not be a pointer.
-		argType = mtype.In(2)
+		argType = mtype.In(in + 1)
 		if !isExportedOrBuiltinType(argType) {
 			if isLog {
 				server.logger.Errorf("method:%s argument type not exported:%v\n", mname, argType)
@@ -390,7 +461,7 @@ func (server *Server) suitableMethods(typ reflect.Type, isLog bool) map[string]*
 			continue
 		}
 		// Third arg must be a pointer.
-		replyType := mtype.In(3)
+		replyType := mtype.In(in + 2)
 		if replyType.Kind() != reflect.Ptr {
 			if isLog {
 				server.logger.Errorf("method:%s reply type not a pointer:%v\n", mname, replyType)
@@ -418,7 +489,7 @@ func (server *Server) suitableMethods(typ reflect.Type, isLog bool) map[string]*
 			}
 			continue
 		}
-		methods[mname] = &methodType{method: method, ArgType: argType, ReplyType: replyType}
+		methods[mname] = &methodType{method: method, ArgType: argType, ReplyType: replyType, withContext: withContext}
 	}
 	return methods
 }
@@ -433,7 +504,13 @@ func (server *Server) sendResponse(sending *sync.Mutex, req *Request, reply inte
 	// Encode the response header
 	resp.ServiceMethod = req.ServiceMethod
 	if errMsg != nil {
-		resp.Error = errMsg.Error()
+		e := toError(errMsg)
+		resp.Error = e.Message
+		if resp.Error == "" {
+			resp.Error = errMsg.Error()
+		}
+		resp.Code = e.Code
+		resp.Data = e.Data
 		reply = invalidRequest
 	}
 	resp.Seq = req.Seq
@@ -449,8 +526,19 @@ func (server *Server) sendResponse(sending *sync.Mutex, req *Request, reply inte
 func (s *service) call(conn *Conn, args *Args) (resp interface{}, err error) {
 	function := args.mType.method.Func
 	// Invoke the method, providing a new value for the reply.
-	returnValues := function.Call([]reflect.Value{s.rcvr,
-		reflect.ValueOf(conn), args.Arg, args.Reply})
+	var in []reflect.Value
+	if args.mType.withContext {
+		ctx := args.Ctx
+		if ctx == nil {
+			ctx = conn.Context()
+		}
+		in = []reflect.Value{s.rcvr, reflect.ValueOf(ctx),
+			reflect.ValueOf(conn), args.Arg, args.Reply}
+	} else {
+		in = []reflect.Value{s.rcvr,
+			reflect.ValueOf(conn), args.Arg, args.Reply}
+	}
+	returnValues := function.Call(in)
 	// The return value for the method is an error.
 	errInter := returnValues[0].Interface()
 	if errInter != nil {
@@ -464,8 +552,24 @@ func (s *service) call(conn *Conn, args *Args) (resp interface{}, err error) {
 // ServeCodec is like ServeConn but uses the specified codec to
 // decode requests and encode responses.
 func (server *Server) ServeCodec(req *http.Request, codec ServerCodec, onInit ...InitHandler) {
+	server.serveConn(req, codec, nil, onInit...)
+}
+
+func (server *Server) serveConn(req *http.Request, codec ServerCodec, rwc *ReadWriteCloser, onInit ...InitHandler) {
 	sending := new(sync.Mutex)
 	conn := NewConn(req, sending, codec)
+	conn.rwc = rwc
+	if server.limits.ConnInFlight > 0 {
+		conn.inFlight = make(chan struct{}, server.limits.ConnInFlight)
+	}
+	server.registry.add(conn)
+	if server.registry.isShutdown() {
+		// added after Shutdown listed the conns
+		server.registry.remove(conn)
+		conn.ternimating()
+		conn.shutdown()
+		return
+	}
 
 	for _, fn := range onInit {
 		fn(conn)
@@ -478,6 +582,9 @@ func (server *Server) ServeCodec(req *http.Request, codec ServerCodec, onInit ..
 			if _, ok := err.(*websocket.CloseError); ok {
 				break
 			}
+			if err == io.EOF {
+				break
+			}
 			if strings.Contains(err.Error(), "use of closed network connection") {
 				break
 			}
@@ -486,56 +593,137 @@ func (server *Server) ServeCodec(req *http.Request, codec ServerCodec, onInit ..
 			break
 		}
 
-		// get service
-		service, mType, err := server.getService(req)
-		if err != nil {
-			if server.onMissingMethod == nil {
-				server.sendResponse(sending, req, invalidRequest, codec, err)
-				server.freeRequest(req)
-				continue
-			}
+		server.serveRequest(conn, sending, codec, req)
+	}
 
-			// on missing method
-			go func(method string, params json.RawMessage) {
-				reply, err := server.onMissingMethod(conn, method, params)
-				server.sendResponse(sending, req, reply, codec, err)
-				server.freeRequest(req)
-			}(codec.GetMethod(), codec.GetParams())
-			continue
-		}
+	server.registry.remove(conn)
+	conn.ternimating()
 
-		reqArgs, err := server.getArgs(codec, mType)
-		if err != nil {
-			server.logger.Errorf("read request body:%s", err)
-			// send a response if we actually managed to read a header.
+	//  close may write in that conn, just prevnet that
+	sending.Lock()
+	codec.Close()
+	sending.Unlock()
+}
+
+// serveRequest handles req read from codec, the response is written
+// once the handler returns.
+func (server *Server) serveRequest(conn *Conn, sending *sync.Mutex, codec ServerCodec, req *Request) {
+	if server.registry.isShutdown() {
+		server.sendResponse(sending, req, invalidRequest, codec, errShuttingDown)
+		server.freeRequest(req)
+		return
+	}
+
+	// get service
+	service, mType, err := server.getService(req)
+	if err != nil {
+		if server.onMissingMethod == nil {
 			server.sendResponse(sending, req, invalidRequest, codec, err)
 			server.freeRequest(req)
-			continue
+			return
 		}
 
-		go func(args *Args) {
-			var (
-				reply interface{}
-				err   error
-			)
-
-			if server.onWrap != nil {
-				reply, err = server.onWrap(service.call)(conn, args)
-			} else {
-				reply, err = service.call(conn, args)
-			}
+		// on missing method
+		args := &Args{
+			Method: codec.GetMethod(),
+			RawReq: codec.GetParams(),
+		}
+		if mc, ok := codec.(MetaCodec); ok {
+			args.Meta = mc.GetMeta()
+		}
+		if !server.dispatch(conn, func() {
+			var cancel context.CancelFunc
+			args.Ctx, cancel = context.WithCancel(conn.Context())
+			defer cancel()
 
+			reply, err := server.missingMethodHandler()(conn, args)
 			server.sendResponse(sending, req, reply, codec, err)
 			server.freeRequest(req)
-		}(reqArgs)
+		}) {
+			server.sendResponse(sending, req, invalidRequest, codec, errServerBusy)
+			server.freeRequest(req)
+		}
+		return
 	}
 
-	conn.ternimating()
+	if err := server.authorize(conn, service, mType); err != nil {
+		server.sendResponse(sending, req, invalidRequest, codec, err)
+		server.freeRequest(req)
+		return
+	}
 
-	//  close may write in that conn, just prevnet that
-	sending.Lock()
-	codec.Close()
-	sending.Unlock()
+	reqArgs, err := server.getArgs(codec, mType)
+	if err != nil {
+		server.logger.Errorf("read request body:%s", err)
+		// send a response if we actually managed to read a header.
+		server.sendResponse(sending, req, invalidRequest, codec, err)
+		server.freeRequest(req)
+		return
+	}
+
+	if !server.dispatch(conn, func() {
+		var (
+			reply  interface{}
+			err    error
+			cancel context.CancelFunc
+		)
+		reqArgs.Ctx, cancel = service.context(conn, mType)
+		defer cancel()
+
+		start := mType.stats.start()
+		reply, err = server.handler(service, mType)(conn, reqArgs)
+		mType.stats.end(start, err)
+
+		server.sendResponse(sending, req, reply, codec, err)
+		server.freeRequest(req)
+	}) {
+		server.sendResponse(sending, req, invalidRequest, codec, errServerBusy)
+		server.freeRequest(req)
+	}
+}
+
+// handler wraps the call of mtype with the server, service and
+// method middlewares, from the outermost to the innermost.
+func (server *Server) handler(s *service, mtype *methodType) ServiceHandler {
+	server.mu.RLock()
+	middlewares := server.middlewares
+	server.mu.RUnlock()
+
+	handler := chain(s.call, mtype.middlewares)
+	handler = chain(handler, s.middlewares)
+	return chain(handler, middlewares)
+}
+
+// missingMethodHandler wraps the missing method handler with the server
+// middlewares.
+func (server *Server) missingMethodHandler() ServiceHandler {
+	server.mu.RLock()
+	middlewares := server.middlewares
+	server.mu.RUnlock()
+
+	return chain(func(conn *Conn, args *Args) (interface{}, error) {
+		return server.onMissingMethod(conn, args.Method, args.RawReq)
+	}, middlewares)
+}
+
+func chain(handler ServiceHandler, middlewares []WrapHandler) ServiceHandler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// context returns the ctx of a call to mtype, it derives from the ctx
+// of the conn and expires after the timeout of the method, if any.
+func (s *service) context(conn *Conn, mtype *methodType) (context.Context, context.CancelFunc) {
+	timeout := mtype.timeout
+	if timeout == 0 {
+		timeout = s.timeout
+	}
+	if timeout > 0 {
+		return context.WithTimeout(conn.Context(), timeout)
+	}
+	return context.WithCancel(conn.Context())
 }
 
 func (server *Server) getRequest() *Request {
@@ -584,6 +772,9 @@ func (server *Server) getArgs(codec ServerCodec, mType *methodType) (*Args, erro
 		Method: codec.GetMethod(),
 		RawReq: codec.GetParams(),
 	}
+	if mc, ok := codec.(MetaCodec); ok {
+		args.Meta = mc.GetMeta()
+	}
 
 	// Decode the argument value.
 	argIsValue := false // if true, need to indirect before calling.
@@ -595,8 +786,12 @@ func (server *Server) getArgs(codec ServerCodec, mType *methodType) (*Args, erro
 	}
 
 	// argv guaranteed to be a pointer now.
-	if err := codec.ReadRequestBody(args.Arg.Interface()); err != nil {
-		return nil, err
+	err := codec.ReadRequestBody(args.Arg.Interface())
+	if err == errMissingParams && args.mType.noParams {
+		err = nil
+	}
+	if err != nil {
+		return nil, NewError(CodeInvalidParams, err.Error())
 	}
 	if argIsValue {
 		args.Arg = args.Arg.Elem()
@@ -609,7 +804,7 @@ func (server *Server) getArgs(codec ServerCodec, mType *methodType) (*Args, erro
 func (server *Server) getService(req *Request) (*service, *methodType, error) {
 	dot := strings.LastIndex(req.ServiceMethod, ".")
 	if dot < 0 {
-		return nil, nil, errors.New("rpc: service/method request ill-formed: " + req.ServiceMethod)
+		return nil, nil, NewError(CodeMethodNotFound, "rpc: service/method request ill-formed: "+req.ServiceMethod)
 	}
 	serviceName := req.ServiceMethod[:dot]
 	methodName := req.ServiceMethod[dot+1:]
@@ -619,12 +814,12 @@ func (server *Server) getService(req *Request) (*service, *methodType, error) {
 	service := server.serviceMap[serviceName]
 	server.mu.RUnlock()
 	if service == nil {
-		return nil, nil, errors.New("rpc: can't find service " + serviceName)
+		return nil, nil, NewError(CodeMethodNotFound, "rpc: can't find service "+serviceName)
 	}
 
 	mtype := service.method[methodName]
 	if mtype == nil {
-		return nil, nil, errors.New("rpc: can't find service method " + req.ServiceMethod)
+		return nil, nil, NewError(CodeMethodNotFound, "rpc: can't find service method "+req.ServiceMethod)
 	}
 	return service, mtype, nil
 }
@@ -636,11 +831,36 @@ func (server *Server) read(codec ServerCodec) (req *Request, err error) {
 	return
 }
 
-// OnConnect ...
+// SetConnOptions sets the keepalive, deadlines and message size limit
+// of the websocket conns served by OnConnect.
+func (server *Server) SetConnOptions(opts ...ConnOption) {
+	server.connOpts = opts
+}
+
+// OnConnect serves ws with the codec of the subprotocol the client
+// negotiated, JSON if there is none. The conn is refused when the
+// authenticator fails.
 func (server *Server) OnConnect(r *http.Request, ws *websocket.Conn, onInit ...InitHandler) {
-	rwc := NewReadWriteCloser(ws)
-	codec := NewServerCodec(rwc)
-	server.ServeCodec(r, codec, onInit...)
+	principal, ok := server.authenticate(r, ws)
+	if !ok {
+		return
+	}
+	if principal != nil {
+		onInit = append([]InitHandler{func(conn *Conn) {
+			conn.SetPrincipal(principal)
+		}}, onInit...)
+	}
+
+	encoding := server.encoding(ws.Subprotocol())
+	rwc := NewReadWriteCloser(ws, server.connOpts...)
+	if encoding.Binary {
+		rwc.MessageType = websocket.BinaryMessage
+	}
+	codec := encoding.NewCodec(rwc)
+	if server.wrapCodec != nil {
+		codec = server.wrapCodec(codec)
+	}
+	server.serveConn(r, codec, rwc, onInit...)
 }
 
 // A ServerCodec implements reading of RPC requests and writing of
@@ -650,6 +870,8 @@ func (server *Server) OnConnect(r *http.Request, ws *websocket.Conn, onInit ...I
 // write a response back. The server calls Close when finished with the
 // connection. ReadRequestBody may be called with a nil
 // argument to force the body of the request to be read and discarded.
+// A codec may hand out several requests read from one frame, such as a
+// JSON-RPC 2.0 batch, and is responsible for grouping their responses.
 type ServerCodec interface {
 	ReadRequestHeader(*Request) error
 	ReadRequestBody(interface{}) error
@@ -664,3 +886,9 @@ type ServerCodec interface {
 
 	Close() error
 }
+
+// MetaCodec is implemented by the codecs reading the optional meta field
+// of the request envelope, a map of strings.
+type MetaCodec interface {
+	GetMeta() map[string]string
+}
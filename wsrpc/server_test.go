@@ -0,0 +1,97 @@
+package wsrpc
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type Waiter struct {
+	cancelled chan error
+}
+
+func (w *Waiter) Wait(ctx context.Context, conn *Conn, args int, reply *int) error {
+	<-ctx.Done()
+	w.cancelled <- ctx.Err()
+	return ctx.Err()
+}
+
+func (w *Waiter) Value(ctx context.Context, conn *Conn, args int, reply *string) error {
+	*reply, _ = ctx.Value(waiterKey{}).(string)
+	return nil
+}
+
+type waiterKey struct{}
+
+func TestContextMethod(t *testing.T) {
+	waiter := &Waiter{cancelled: make(chan error, 1)}
+	server := NewServer()
+	err := server.Register(waiter, WithMethod("Wait", WithTimeout(50*time.Millisecond)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.OnWrap(func(next ServiceHandler) ServiceHandler {
+		return func(conn *Conn, args *Args) (interface{}, error) {
+			args.Ctx = context.WithValue(args.Ctx, waiterKey{}, "wrapped")
+			return next(conn, args)
+		}
+	})
+	ts, url := newTestServer(t, server)
+	defer ts.Close()
+
+	client, err := Dial(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	err = client.Call(context.Background(), "Waiter.Wait", 0, new(int))
+	if err == nil || err.Error() != context.DeadlineExceeded.Error() {
+		t.Errorf("expected deadline exceeded got %v", err)
+	}
+	<-waiter.cancelled
+
+	var value string
+	if err := client.Call(context.Background(), "Waiter.Value", 0, &value); err != nil {
+		t.Fatal(err)
+	}
+	if value != "wrapped" {
+		t.Errorf("expected ctx value from wrap handler got %q", value)
+	}
+}
+
+func TestContextCancelOnClose(t *testing.T) {
+	waiter := &Waiter{cancelled: make(chan error, 1)}
+	server := NewServer()
+	if err := server.Register(waiter); err != nil {
+		t.Fatal(err)
+	}
+	ts, url := newTestServer(t, server)
+	defer ts.Close()
+
+	client, err := Dial(context.Background(), url)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client.Go("Waiter.Wait", 0, new(int), nil)
+	time.Sleep(20 * time.Millisecond)
+	client.Close()
+
+	select {
+	case err := <-waiter.cancelled:
+		if err != context.Canceled {
+			t.Errorf("expected canceled got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("ctx not cancelled when the conn terminated")
+	}
+}
+
+func TestRegisterUnknownMethodOption(t *testing.T) {
+	server := NewServer()
+	err := server.Register(new(Arith), WithMethod("Missing", WithTimeout(time.Second)))
+	if err == nil {
+		t.Error("expected error for unknown method option")
+	}
+}
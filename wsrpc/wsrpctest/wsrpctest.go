@@ -0,0 +1,305 @@
+// Package wsrpctest runs a wsrpc.Server against in-memory conns, so
+// handlers, notifiers and missing method handlers can be tested without
+// an HTTP listener.
+package wsrpctest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"github.com/hkjojo/go-toolkits/wsrpc"
+)
+
+// ErrClosed is returned by the calls of a closed Client.
+var ErrClosed = errors.New("wsrpctest: client closed")
+
+// Notification is a notification written by the server.
+type Notification struct {
+	Method string
+	Params json.RawMessage
+}
+
+// Option configures a Client.
+type Option func(*options)
+
+// WithRequest sets the HTTP request carried by the conn, such as one
+// with auth headers. A GET of / by default.
+func WithRequest(r *http.Request) Option {
+	return func(o *options) {
+		o.request = r
+	}
+}
+
+// WithInit runs handlers once the conn is created, like the onInit
+// handlers of Server.OnConnect.
+func WithInit(handlers ...wsrpc.InitHandler) Option {
+	return func(o *options) {
+		o.onInit = append(o.onInit, handlers...)
+	}
+}
+
+// WithNotificationBuffer sets how many notifications are kept until
+// read from Notifications, default 100. Notifications beyond it block
+// the conn until read or the client is closed.
+func WithNotificationBuffer(size int) Option {
+	return func(o *options) {
+		o.buffer = size
+	}
+}
+
+type options struct {
+	request *http.Request
+	onInit  []wsrpc.InitHandler
+	buffer  int
+}
+
+type request struct {
+	Version string            `json:"jsonrpc"`
+	Method  string            `json:"method"`
+	Params  interface{}       `json:"params"`
+	ID      *uint64           `json:"id,omitempty"`
+	Meta    map[string]string `json:"meta,omitempty"`
+}
+
+type response struct {
+	ID           *uint64         `json:"id"`
+	Result       json.RawMessage `json:"result"`
+	Error        *wsrpc.Error    `json:"error"`
+	Method       string          `json:"method"`
+	Notification string          `json:"notification"`
+	Params       json.RawMessage `json:"params"`
+}
+
+// Client is an in-memory conn of a server speaking JSON-RPC 2.0.
+type Client struct {
+	pipe          net.Conn
+	conn          chan *wsrpc.Conn
+	notifications chan Notification
+	stop          chan struct{} // closed by Close, unblocks read
+	stopOnce      sync.Once
+	done          chan struct{}
+
+	encMu sync.Mutex // serializes writes of enc
+	enc   *json.Encoder
+
+	mu      sync.Mutex // protects seq, pending, err
+	seq     uint64
+	pending map[uint64]chan *response
+	err     error
+}
+
+// Connect serves a new in-memory conn with server and returns its client.
+func Connect(server *wsrpc.Server, opts ...Option) *Client {
+	o := options{buffer: 100}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.request == nil {
+		o.request = httptest.NewRequest(http.MethodGet, "/", nil)
+	}
+
+	serverSide, clientSide := net.Pipe()
+	c := &Client{
+		pipe:          clientSide,
+		conn:          make(chan *wsrpc.Conn, 1),
+		notifications: make(chan Notification, o.buffer),
+		stop:          make(chan struct{}),
+		done:          make(chan struct{}),
+		enc:           json.NewEncoder(clientSide),
+		pending:       make(map[uint64]chan *response),
+	}
+
+	onInit := append([]wsrpc.InitHandler{func(conn *wsrpc.Conn) {
+		c.conn <- conn
+	}}, o.onInit...)
+	go server.ServeCodec(o.request, wsrpc.NewServerCodec(serverSide), onInit...)
+	go c.read()
+	return c
+}
+
+// Conn waits for the server side conn. It returns ErrClosed if the
+// server refused the conn, such as during Shutdown, or the client is
+// closed before the conn is created.
+func (c *Client) Conn(ctx context.Context) (*wsrpc.Conn, error) {
+	select {
+	case conn := <-c.conn:
+		c.conn <- conn
+		return conn, nil
+	case <-c.done:
+		select {
+		case conn := <-c.conn:
+			c.conn <- conn
+			return conn, nil
+		default:
+			return nil, ErrClosed
+		}
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Call calls method with params and unmarshals the result into reply.
+// Error responses are returned as *wsrpc.Error.
+func (c *Client) Call(ctx context.Context, method string, params, reply interface{}) error {
+	data, err := c.call(ctx, &request{Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+	if reply == nil {
+		return nil
+	}
+	return json.Unmarshal(data, reply)
+}
+
+// Notify sends a notification, a request without id.
+func (c *Client) Notify(method string, params interface{}) error {
+	return c.write(&request{Method: method, Params: params})
+}
+
+// Notifications returns the notifications written by the server.
+func (c *Client) Notifications() <-chan Notification {
+	return c.notifications
+}
+
+// Replay sends the recorded requests one after the other, waiting for
+// each response, and returns the responses as records.
+func (c *Client) Replay(ctx context.Context, records []wsrpc.Record) ([]wsrpc.Record, error) {
+	var replies []wsrpc.Record
+	for _, record := range records {
+		if record.Kind != wsrpc.RecordRequest {
+			continue
+		}
+		var params interface{}
+		if record.Params != nil {
+			params = record.Params
+		}
+		result, err := c.call(ctx, &request{Method: record.Method, Params: params, Meta: record.Meta})
+
+		reply := wsrpc.Record{
+			Conn:   record.Conn,
+			Kind:   wsrpc.RecordResponse,
+			Seq:    record.Seq,
+			Method: record.Method,
+			Result: result,
+		}
+		if err != nil && !errors.As(err, &reply.Error) {
+			return replies, err
+		}
+		replies = append(replies, reply)
+	}
+	return replies, nil
+}
+
+// Close closes the conn, the server terminates it as a closed websocket.
+func (c *Client) Close() error {
+	c.stopOnce.Do(func() { close(c.stop) })
+	err := c.pipe.Close()
+	<-c.done
+	return err
+}
+
+func (c *Client) call(ctx context.Context, req *request) (json.RawMessage, error) {
+	ch := make(chan *response, 1)
+
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return nil, c.err
+	}
+	c.seq++
+	id := c.seq
+	c.pending[id] = ch
+	c.mu.Unlock()
+
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	req.ID = &id
+	if err := c.write(req); err != nil {
+		return nil, err
+	}
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return nil, ErrClosed
+		}
+		if resp.Error != nil {
+			return nil, resp.Error
+		}
+		return resp.Result, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (c *Client) write(req *request) error {
+	req.Version = "2.0"
+	if req.Params == nil {
+		req.Params = []interface{}{}
+	}
+
+	c.encMu.Lock()
+	defer c.encMu.Unlock()
+
+	if err := c.enc.Encode(req); err != nil {
+		if err == io.ErrClosedPipe {
+			return ErrClosed
+		}
+		return err
+	}
+	return nil
+}
+
+func (c *Client) read() {
+	defer close(c.done)
+	defer close(c.notifications)
+
+	dec := json.NewDecoder(c.pipe)
+	for {
+		var resp response
+		if err := dec.Decode(&resp); err != nil {
+			break
+		}
+
+		if resp.ID == nil && (resp.Notification != "" || resp.Method != "") {
+			method := resp.Method
+			if method == "" {
+				method = resp.Notification
+			}
+			select {
+			case c.notifications <- Notification{Method: method, Params: resp.Params}:
+			case <-c.stop:
+			}
+			continue
+		}
+		if resp.ID == nil {
+			continue
+		}
+
+		c.mu.Lock()
+		ch := c.pending[*resp.ID]
+		c.mu.Unlock()
+		if ch != nil {
+			ch <- &resp
+		}
+	}
+
+	c.mu.Lock()
+	c.err = ErrClosed
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+	c.mu.Unlock()
+	c.pipe.Close()
+}
@@ -0,0 +1,154 @@
+package wsrpctest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hkjojo/go-toolkits/wsrpc"
+)
+
+type Counter struct {
+	n int
+}
+
+func (c *Counter) Add(conn *wsrpc.Conn, delta int, reply *int) error {
+	if delta < 0 {
+		return wsrpc.NewError(-32010, "negative delta")
+	}
+	c.n += delta
+	*reply = c.n
+	return conn.Notify("Counter.Changed", c.n)
+}
+
+func (c *Counter) Whoami(conn *wsrpc.Conn, args int, reply *string) error {
+	*reply, _ = conn.GetData("user").(string)
+	return nil
+}
+
+func newServer(t *testing.T) *wsrpc.Server {
+	server := wsrpc.NewServer()
+	if err := server.Register(new(Counter)); err != nil {
+		t.Fatal(err)
+	}
+	server.OnMissingMethod(func(conn *wsrpc.Conn, method string, params json.RawMessage) (interface{}, error) {
+		return map[string]interface{}{"proxied": method, "params": params}, nil
+	})
+	return server
+}
+
+func TestClient(t *testing.T) {
+	client := Connect(newServer(t), WithInit(func(conn *wsrpc.Conn) {
+		conn.SetData("user", "alice")
+	}))
+	defer client.Close()
+	ctx := context.Background()
+
+	var n int
+	if err := client.Call(ctx, "Counter.Add", 2, &n); err != nil || n != 2 {
+		t.Fatalf("expected 2 got %d %v", n, err)
+	}
+	select {
+	case notification := <-client.Notifications():
+		if notification.Method != "Counter.Changed" || string(notification.Params) != "2" {
+			t.Errorf("unexpected notification %+v", notification)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected a notification")
+	}
+
+	err := client.Call(ctx, "Counter.Add", -1, &n)
+	var e *wsrpc.Error
+	if !errors.As(err, &e) || e.Code != -32010 {
+		t.Errorf("expected code -32010 got %v", err)
+	}
+
+	var user string
+	if err := client.Call(ctx, "Counter.Whoami", 0, &user); err != nil || user != "alice" {
+		t.Errorf("expected alice got %s %v", user, err)
+	}
+	conn, err := client.Conn(ctx)
+	if err != nil || conn.GetData("user") != "alice" {
+		t.Errorf("expected the server conn of the client got %v", err)
+	}
+
+	var proxied struct {
+		Proxied string          `json:"proxied"`
+		Params  json.RawMessage `json:"params"`
+	}
+	if err := client.Call(ctx, "Backend.Get", []int{1}, &proxied); err != nil {
+		t.Fatal(err)
+	}
+	if proxied.Proxied != "Backend.Get" || string(proxied.Params) != "[1]" {
+		t.Errorf("unexpected proxied reply %+v", proxied)
+	}
+
+	client.Close()
+	if err := client.Call(ctx, "Counter.Add", 1, &n); err != ErrClosed {
+		t.Errorf("expected closed got %v", err)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	records := []wsrpc.Record{
+		{Kind: wsrpc.RecordRequest, Seq: 1, Method: "Counter.Add", Params: json.RawMessage("[3]")},
+		{Kind: wsrpc.RecordResponse, Seq: 1, Method: "Counter.Add", Result: json.RawMessage("3")},
+		{Kind: wsrpc.RecordRequest, Seq: 2, Method: "Counter.Add", Params: json.RawMessage("[-1]")},
+	}
+
+	client := Connect(newServer(t), WithNotificationBuffer(10))
+	defer client.Close()
+
+	replies, err := client.Replay(context.Background(), records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replies) != 2 {
+		t.Fatalf("expected 2 replies got %d", len(replies))
+	}
+	if replies[0].Seq != 1 || string(replies[0].Result) != "3" {
+		t.Errorf("unexpected reply %+v", replies[0])
+	}
+	if replies[1].Error == nil || replies[1].Error.Code != -32010 {
+		t.Errorf("expected error reply got %+v", replies[1])
+	}
+}
+
+func TestClientClose(t *testing.T) {
+	client := Connect(newServer(t), WithNotificationBuffer(1))
+	ctx := context.Background()
+
+	var n int
+	if err := client.Call(ctx, "Counter.Add", 1, &n); err != nil {
+		t.Fatal(err)
+	}
+	// the second notification blocks on the full buffer
+	called := make(chan error, 1)
+	go func() { called <- client.Call(ctx, "Counter.Add", 1, nil) }()
+	time.Sleep(10 * time.Millisecond)
+
+	closed := make(chan error, 1)
+	go func() { closed <- client.Close() }()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("expected Close to return with undrained notifications")
+	}
+	if err := <-called; err != ErrClosed {
+		t.Errorf("expected closed got %v", err)
+	}
+
+	server := newServer(t)
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	refused := Connect(server)
+	defer refused.Close()
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if _, err := refused.Conn(ctx); err != ErrClosed {
+		t.Errorf("expected closed got %v", err)
+	}
+}